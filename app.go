@@ -1,16 +1,20 @@
 package tool
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
+	"time"
 
 	"github.com/wjoj/tool/v2/config"
 	"github.com/wjoj/tool/v2/db/dbx"
 	"github.com/wjoj/tool/v2/db/mongox"
 	"github.com/wjoj/tool/v2/db/redisx"
+	"github.com/wjoj/tool/v2/health"
 	"github.com/wjoj/tool/v2/httpx"
 	"github.com/wjoj/tool/v2/log"
 	"github.com/wjoj/tool/v2/resources/casbinx"
@@ -26,60 +30,142 @@ type fnNameType string
 const (
 	fnNameConfig  fnNameType = "config"
 	fnNameGorm    fnNameType = "gorm"
-	fnNameGenGorm fnNameType = "gengorm"
+	fnNameGenGorm fnNameType = "gengorm" //已改为gen子命令 不再作为组件启动
 	fnNameLog     fnNameType = "log"
 	fnNameRedis   fnNameType = "redis"
 	fnNameMongo   fnNameType = "mongo"
 	fnNameHttp    fnNameType = "http"
 	fnNameCasbin  fnNameType = "casbin"
 	fnNameJwt     fnNameType = "jwt"
+	fnNameWith    fnNameType = "with"
+	fnNameServer  fnNameType = "server"
 )
 
+// fnDeps 内置组件的依赖 只有已注册的依赖才生效
+var fnDeps = map[fnNameType][]fnNameType{
+	fnNameLog:    {fnNameConfig},
+	fnNameRedis:  {fnNameConfig, fnNameLog},
+	fnNameGorm:   {fnNameConfig, fnNameLog},
+	fnNameMongo:  {fnNameConfig, fnNameLog},
+	fnNameJwt:    {fnNameConfig, fnNameLog},
+	fnNameCasbin: {fnNameConfig, fnNameLog, fnNameGorm, fnNameRedis},
+	fnNameHttp:   {fnNameConfig, fnNameLog, fnNameRedis, fnNameGorm, fnNameMongo, fnNameJwt, fnNameCasbin},
+}
+
 type funcErr struct {
-	Fn        func() error
-	RekeaseFn func() error
-	Name      fnNameType
+	Fn           func() error
+	RekeaseFn    func() error
+	RekeaseCtxFn func(ctx context.Context) error //优先于RekeaseFn 可感知停止期限
+	Name         fnNameType
+}
+
+// fnComponent 内置组件适配Component
+type fnComponent struct {
+	fn   funcErr
+	deps []string
+}
+
+// newFnComponent 依赖只包含fs中已注册的内置组件
+func newFnComponent(f funcErr, fs []funcErr) *fnComponent {
+	comp := &fnComponent{fn: f}
+	for _, dep := range fnDeps[f.Name] {
+		if slices.ContainsFunc(fs, func(f funcErr) bool { return f.Name == dep }) {
+			comp.deps = append(comp.deps, string(dep))
+		}
+	}
+	return comp
+}
+
+func (c *fnComponent) Name() string {
+	return string(c.fn.Name)
+}
+
+func (c *fnComponent) Dependencies() []string {
+	return c.deps
+}
+
+func (c *fnComponent) Start(ctx context.Context) error {
+	if c.fn.Fn == nil {
+		return nil
+	}
+	return c.fn.Fn()
+}
+
+func (c *fnComponent) Stop(ctx context.Context) error {
+	if c.fn.RekeaseCtxFn != nil {
+		return c.fn.RekeaseCtxFn(ctx)
+	}
+	if c.fn.RekeaseFn == nil {
+		return nil
+	}
+	return c.fn.RekeaseFn()
 }
 
 type cmdarg struct {
 	config     *string
 	configroot *string
+	sets       *[]string
 }
 
 type App struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
 	isConfig bool
 	fnMap    map[fnNameType]funcErr
 	cmdarg   *cmdarg
 	opt      Options
 	rootCmd  *cobra.Command
 	cmds     []*cobra.Command
+	comps    []Component
+	withs    []funcErr
+	srvs     []*serverComponent
+	models   []any
+	httpOpts []httpx.Option
+	isGen    bool
+	genOpts  []dbx.GenOption
 }
 
 func NewApp(opts ...Option) *App {
+	ctx, cancel := context.WithCancel(context.Background())
+	opt := applyOptions(opts...)
 	return &App{
+		ctx:      ctx,
+		cancel:   cancel,
 		isConfig: false,
-		opt:      applyOptions(opts...),
+		opt:      opt,
 		fnMap:    map[fnNameType]funcErr{},
 		cmdarg:   &cmdarg{},
 		rootCmd: &cobra.Command{
-			Use:     "echo [string to echo]",
-			Aliases: []string{"say"},
-			Short:   "Echo anything to the screen",
-			Long:    "an utterly useless command for testing",
-			Example: "Just run cobra-test echo",
+			Use:   opt.name,
+			Short: opt.description,
+			Long:  opt.description,
 		},
 	}
 }
 
+// Context 根context 收到退出信号时取消
+func (a *App) Context() context.Context {
+	return a.ctx
+}
+
 func (a *App) setIsConfig() {
 	a.isConfig = true
 }
 
+// setFlag 注册--set Config和ConfigSource都会调用 只注册一次
+func (a *App) setFlag() {
+	if a.cmdarg.sets == nil {
+		a.cmdarg.sets = a.rootCmd.PersistentFlags().StringArray("set", nil, "override configuration, e.g. --set dbs.def.password=xxx")
+	}
+}
+
 func (a *App) Config() *App {
 	a.setIsConfig()
 	a.cmdarg.config = a.rootCmd.PersistentFlags().StringP("config", "c", "config.yaml", "configuration file")
+	a.setFlag()
 	a.fnMap[fnNameConfig] = funcErr{
 		Fn: func() error {
+			config.SetFlagOverrides(*a.cmdarg.sets)
 			return config.Read(*a.cmdarg.configroot, *a.cmdarg.config)
 		},
 		RekeaseFn: nil,
@@ -88,11 +174,29 @@ func (a *App) Config() *App {
 	return a
 }
 
+// ConfigSource 从配置来源读取配置 如config.RedisSource config.HTTPSource
+func (a *App) ConfigSource(src config.ConfigSource, opts ...config.SourceOption) *App {
+	a.setIsConfig()
+	a.setFlag()
+	a.fnMap[fnNameConfig] = funcErr{
+		Fn: func() error {
+			config.SetFlagOverrides(*a.cmdarg.sets)
+			return config.ReadSource(src, opts...)
+		},
+		Name: fnNameConfig,
+	}
+	return a
+}
+
 func (a *App) Log(options ...log.Option) *App {
 	a.setIsConfig()
 	a.fnMap[fnNameLog] = funcErr{
 		Fn: func() error {
-			return log.Load(config.GetLogs(), options...)
+			if err := log.Load(config.GetLogs(), options...); err != nil {
+				return err
+			}
+			config.OnChangeOf(config.SectionLogs, log.Reload)
+			return nil
 		},
 		RekeaseFn: func() error {
 			log.CloseAll()
@@ -122,7 +226,11 @@ func (a *App) Gorm(options ...dbx.Option) *App {
 	a.setIsConfig()
 	a.fnMap[fnNameGorm] = funcErr{
 		Fn: func() error {
-			return dbx.Init(config.GetDbs(), options...)
+			if err := dbx.Init(config.GetDbs(), options...); err != nil {
+				return err
+			}
+			config.OnChangeOf(config.SectionDbs, dbx.Reload)
+			return nil
 		},
 		RekeaseFn: dbx.CloseAll,
 		Name:      fnNameGorm,
@@ -130,16 +238,12 @@ func (a *App) Gorm(options ...dbx.Option) *App {
 	return a
 }
 
+// GenGorm 启用gen子命令 根据数据库表生成model和query代码 不在启动时执行
+// 配置文件gen段的设置优先于options
 func (a *App) GenGorm(options ...dbx.GenOption) *App {
 	a.setIsConfig()
-	a.fnMap[fnNameGenGorm] = funcErr{
-		Fn: func() error {
-			dbx.GenByGorm(options...)
-			return nil
-		},
-		RekeaseFn: nil,
-		Name:      fnNameGenGorm,
-	}
+	a.isGen = true
+	a.genOpts = options
 	return a
 }
 
@@ -170,7 +274,11 @@ func (a *App) Jwt(options ...jwt.Option) *App {
 	a.setIsConfig()
 	a.fnMap[fnNameJwt] = funcErr{
 		Fn: func() error {
-			return jwt.Init(config.GetJwts(), options...)
+			if err := jwt.Init(config.GetJwts(), options...); err != nil {
+				return err
+			}
+			config.OnChangeOf(config.SectionJwts, jwt.Reload)
+			return nil
 		},
 		RekeaseFn: nil,
 		Name:      fnNameJwt,
@@ -179,29 +287,62 @@ func (a *App) Jwt(options ...jwt.Option) *App {
 }
 func (a *App) HttpServer(options ...httpx.Option) *App {
 	a.setIsConfig()
+	a.httpOpts = options
 	a.fnMap[fnNameHttp] = funcErr{
 		Fn: func() error {
-			return httpx.Init(config.GetHttp(), options...)
+			if err := httpx.Init(config.GetHttp(), options...); err != nil {
+				return err
+			}
+			config.OnChangeOf(config.SectionHttp, httpx.Reload)
+			return nil
 		},
-		RekeaseFn: httpx.ShutdownAll,
-		Name:      fnNameHttp,
+		RekeaseCtxFn: httpx.ShutdownAllContext,
+		Name:         fnNameHttp,
 	}
 	return a
 }
 
+// With is为true时添加启动步骤 在内置组件(http除外)启动之后、http启动之前按添加顺序执行
 func (a *App) With(is bool, fn func(a *App) error) *App {
-	return a
+	if !is || fn == nil {
+		return a
+	}
+	return a.WithFunc(is, func() error {
+		return fn(a)
+	})
 }
 
+// WithFunc is为true时添加启动步骤
 func (a *App) WithFunc(is bool, fn func() error) *App {
+	if !is || fn == nil {
+		return a
+	}
+	a.withs = append(a.withs, funcErr{
+		Fn:   fn,
+		Name: fnNameType(fmt.Sprintf("%s-%d", fnNameWith, len(a.withs)+1)),
+	})
 	return a
 }
 
+// WithRekease is为true时添加释放步骤 在http关闭之后、内置组件释放之前执行
 func (a *App) WithRekease(is bool, fn func(a *App) error) *App {
-	return a
+	if !is || fn == nil {
+		return a
+	}
+	return a.WithRekeaseFunc(is, func() error {
+		return fn(a)
+	})
 }
 
+// WithRekeaseFunc is为true时添加释放步骤
 func (a *App) WithRekeaseFunc(is bool, fn func() error) *App {
+	if !is || fn == nil {
+		return a
+	}
+	a.withs = append(a.withs, funcErr{
+		RekeaseFn: fn,
+		Name:      fnNameType(fmt.Sprintf("%s-%d", fnNameWith, len(a.withs)+1)),
+	})
 	return a
 }
 
@@ -215,49 +356,145 @@ func (a *App) AddCommand(cmd *cobra.Command) *App {
 	return a
 }
 
+// AddServer 保留的旧接口 不添加服务
+//
+// Deprecated: 使用AddServerComponent
 func (a *App) AddServer(srvName string) *App {
 	return a
 }
 
-func (a *App) run(fs []funcErr) error {
-	for i := range fs {
-		f := fs[i]
-		if f.Fn == nil {
-			continue
-		}
+// AddServerComponent 添加长期运行的服务 与http服务一起启动和优雅关闭
+func (a *App) AddServerComponent(srvName string, srv Server) *App {
+	a.srvs = append(a.srvs, &serverComponent{
+		name: string(fnNameServer) + "-" + srvName,
+		srv:  srv,
+	})
+	return a
+}
 
-		if err := f.Fn(); err != nil {
-			fmt.Printf("%+v err:%+v\n", f.Name, err)
-			return err
+// Migrate 设置db migrate子命令迁移的模型
+func (a *App) Migrate(models ...any) *App {
+	a.models = append(a.models, models...)
+	return a
+}
+
+// Migrations 注册db的版本迁移 由db migrate up|down|status|to子命令执行 key为db的key
+// sql文件的迁移使用dbx.SQLMigrations读取
+func (a *App) Migrations(key string, ms ...dbx.Migration) *App {
+	dbx.RegisterMigrations(key, ms...)
+	return a
+}
+
+// dbx.OutboxRelay 可以通过App.Component注册
+var _ Component = (*dbx.OutboxRelay)(nil)
+
+// Component 注册自定义组件 与内置组件一起按依赖顺序启动和停止
+func (a *App) Component(comps ...Component) *App {
+	a.comps = append(a.comps, comps...)
+	return a
+}
+
+func (a *App) run(reg *Registry) error {
+	if err := reg.Start(a.ctx); err != nil {
+		log.Errorf("app start error: %+v", err)
+		return err
+	}
+	return nil
+}
+
+// notify 第一次收到退出信号时取消根context 第二次立即退出
+func (a *App) notify() func() {
+	quit := make(chan os.Signal, 2)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig, ok := <-quit
+		if !ok {
+			return
+		}
+		log.Infof("received signal %v, shutting down", sig)
+		a.cancel()
+		sig, ok = <-quit
+		if !ok {
+			return
 		}
+		log.Errorf("received signal %v again, forcing exit", sig)
+		os.Exit(1)
+	}()
+	return func() {
+		signal.Stop(quit)
+		close(quit)
+	}
+}
+
+func (a *App) rekease(reg *Registry) error {
+	if !a.opt.quit {
+		<-a.ctx.Done()
+	}
+	a.cancel()
+	// 先标记未就绪 等待负载均衡摘除流量后再停止组件
+	health.SetShutdown(true)
+	if a.opt.drainDelay > 0 {
+		time.Sleep(a.opt.drainDelay)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.opt.shutdownTimeout)
+	defer cancel()
+	if err := reg.Stop(ctx); err != nil {
+		log.Errorf("app stop error: %+v", err)
+		return err
 	}
 	return nil
 }
 
-func (a *App) rekease(fs []funcErr) error {
-	rel := func() error {
-		for _, f := range fs {
-			if f.RekeaseFn == nil {
-				continue
-			}
-			if err := f.RekeaseFn(); err != nil {
-				fmt.Printf("%+v err:%+v\n", f.Name, err)
-				return err
-			}
+// registry 注册内置组件和自定义组件
+// 启动顺序: 内置组件 -> With添加的步骤 -> http/gengorm -> AddServerComponent添加的服务 停止顺序相反
+func (a *App) registry(fs []funcErr) (*Registry, error) {
+	reg := NewRegistry()
+	reg.SetStopTimeout(a.opt.stopTimeout)
+	for name, timeout := range a.opt.stopTimeouts {
+		reg.SetComponentStopTimeout(name, timeout)
+	}
+	var builtins, withs []string
+	comps := make([]*fnComponent, 0, len(fs))
+	for _, f := range fs {
+		comp := newFnComponent(f, fs)
+		if f.Name != fnNameHttp {
+			builtins = append(builtins, string(f.Name))
 		}
-		return nil
+		comps = append(comps, comp)
 	}
-	if a.opt.quit {
-		return rel()
+	for _, f := range a.withs {
+		comps = append(comps, &fnComponent{
+			fn:   f,
+			deps: append(slices.Clone(builtins), withs...),
+		})
+		withs = append(withs, string(f.Name))
 	}
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	return rel()
+	for _, comp := range comps {
+		if comp.fn.Name == fnNameHttp {
+			comp.deps = append(comp.deps, withs...)
+		}
+		if err := reg.Register(comp); err != nil {
+			return nil, err
+		}
+	}
+	deps := make([]string, len(comps))
+	for i, comp := range comps {
+		deps[i] = comp.Name()
+	}
+	for _, srv := range a.srvs {
+		// 每次注册使用新的组件 不修改AddServerComponent添加的服务
+		if err := reg.Register(&serverComponent{name: srv.name, srv: srv.srv, deps: deps}); err != nil {
+			return nil, err
+		}
+	}
+	if err := reg.Register(a.comps...); err != nil {
+		return nil, err
+	}
+	return reg, nil
 }
 
-func (a *App) Run() error {
-	a.cmdarg.configroot = a.rootCmd.PersistentFlags().StringP("configroot", "r", "etc", "configure the root directory")
+// funcs 返回配置 日志和names中已注册的内置组件 names为空时返回所有已注册的内置组件
+func (a *App) funcs(names ...fnNameType) ([]funcErr, error) {
 	fs := []funcErr{}
 	if a.isConfig { //需要配置
 		fnConfig, is := a.fnMap[fnNameConfig]
@@ -265,7 +502,7 @@ func (a *App) Run() error {
 			a.Config()
 			fnConfig, is = a.fnMap[fnNameConfig]
 			if !is {
-				return errors.New("config not found")
+				return nil, errors.New("config not found")
 			}
 		}
 		fs = append(fs, fnConfig)
@@ -275,6 +512,7 @@ func (a *App) Run() error {
 		fs = append(fs, fnLog)
 	} else {
 		fs = append(fs, funcErr{
+			Name: fnNameLog,
 			Fn: func() error {
 				return log.Load(map[string]log.Config{
 					utils.DefaultKey.DefaultKey: {
@@ -290,7 +528,9 @@ func (a *App) Run() error {
 	fnames := []fnNameType{
 		fnNameRedis, fnNameGorm, fnNameMongo,
 		fnNameJwt, fnNameCasbin, fnNameHttp,
-		fnNameGenGorm,
+	}
+	if len(names) != 0 {
+		fnames = names
 	}
 	for _, fname := range fnames {
 		fn, is := a.fnMap[fname]
@@ -298,20 +538,75 @@ func (a *App) Run() error {
 			fs = append(fs, fn)
 		}
 	}
-	a.rootCmd.Run = func(cmd *cobra.Command, args []string) {
-		if err := a.run(fs); err != nil {
-			return
-		}
-		if err := a.rekease(fs); err != nil {
-			return
+	return fs, nil
+}
+
+// bootstrap 只启动配置 日志和names中的内置组件 供子命令使用 返回的Registry用于停止
+func (a *App) bootstrap(names ...fnNameType) (*Registry, error) {
+	fs, err := a.funcs(names...)
+	if err != nil {
+		return nil, err
+	}
+	reg := NewRegistry()
+	reg.SetStopTimeout(a.opt.stopTimeout)
+	for _, f := range fs {
+		if err := reg.Register(newFnComponent(f, fs)); err != nil {
+			return nil, err
 		}
 	}
-	a.rootCmd.AddCommand(a.cmds...)
-	err := a.rootCmd.Execute()
+	if err := reg.Start(a.ctx); err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
+
+func (a *App) Run() error {
+	a.cmdarg.configroot = a.rootCmd.PersistentFlags().StringP("configroot", "r", "etc", "configure the root directory")
+	fs, err := a.funcs()
 	if err != nil {
-		fmt.Println("xxxxx")
 		return err
 	}
-
-	return nil
+	reg, err := a.registry(fs)
+	if err != nil {
+		return err
+	}
+	a.rootCmd.SilenceUsage = true
+	serve := func(cmd *cobra.Command, args []string) error {
+		stop := a.notify()
+		defer stop()
+		if err := a.run(reg); err != nil {
+			return err
+		}
+		return a.rekease(reg)
+	}
+	// 没有子命令时与serve相同
+	a.rootCmd.RunE = serve
+	a.rootCmd.AddCommand(&cobra.Command{
+		Use:   "serve",
+		Short: "Start all components and serve until a signal is received",
+		Args:  cobra.NoArgs,
+		RunE:  serve,
+	})
+	a.rootCmd.AddCommand(a.versionCmd())
+	if _, is := a.fnMap[fnNameGorm]; is {
+		a.rootCmd.AddCommand(a.dbCmd())
+	}
+	if _, is := a.fnMap[fnNameGorm]; is || a.isGen {
+		a.rootCmd.AddCommand(a.genCmd())
+	}
+	if _, is := a.fnMap[fnNameRedis]; is {
+		a.rootCmd.AddCommand(a.redisCmd())
+	}
+	if _, is := a.fnMap[fnNameHttp]; is {
+		a.rootCmd.AddCommand(a.routesCmd())
+	}
+	if a.isConfig {
+		a.rootCmd.AddCommand(a.configCmd())
+	}
+	a.rootCmd.AddCommand(a.cmds...)
+	err = a.rootCmd.Execute()
+	if err != nil && !a.opt.exitDisable {
+		os.Exit(1)
+	}
+	return err
 }
@@ -1,20 +1,86 @@
 package tool
 
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
 type Options struct {
-	quit bool
+	name            string
+	description     string
+	quit            bool
+	exitDisable     bool
+	shutdownTimeout time.Duration
+	stopTimeout     time.Duration
+	stopTimeouts    map[string]time.Duration
+	drainDelay      time.Duration
 }
 
 type Option func(c *Options)
 
+// WithNameOption 设置命令名称 默认为可执行文件名
+func WithNameOption(name string) Option {
+	return func(c *Options) {
+		c.name = name
+	}
+}
+
+// WithDescriptionOption 设置命令描述
+func WithDescriptionOption(desc string) Option {
+	return func(c *Options) {
+		c.description = desc
+	}
+}
+
 func WithQuitEnableOption() Option {
 	return func(c *Options) {
 		c.quit = true
 	}
 }
 
+// WithExitDisableOption 启动或停止失败时不退出进程(默认以非0退出码退出), 由Run返回错误
+func WithExitDisableOption() Option {
+	return func(c *Options) {
+		c.exitDisable = true
+	}
+}
+
+// WithShutdownTimeoutOption 设置停止所有组件的总期限 默认25s
+func WithShutdownTimeoutOption(timeout time.Duration) Option {
+	return func(c *Options) {
+		c.shutdownTimeout = timeout
+	}
+}
+
+// WithStopTimeoutOption 设置每个组件默认的停止超时时间 默认10s
+func WithStopTimeoutOption(timeout time.Duration) Option {
+	return func(c *Options) {
+		c.stopTimeout = timeout
+	}
+}
+
+// WithComponentStopTimeoutOption 设置指定组件的停止超时时间 name为组件名称 如ComponentHttp
+func WithComponentStopTimeoutOption(name string, timeout time.Duration) Option {
+	return func(c *Options) {
+		c.stopTimeouts[name] = timeout
+	}
+}
+
+// WithDrainDelayOption 设置停止前的等待时间 期间/readyz返回失败 便于负载均衡摘除流量
+func WithDrainDelayOption(delay time.Duration) Option {
+	return func(c *Options) {
+		c.drainDelay = delay
+	}
+}
+
 func applyOptions(options ...Option) Options {
 	opts := Options{
-		quit: false,
+		name:            filepath.Base(os.Args[0]),
+		quit:            false,
+		shutdownTimeout: 25 * time.Second,
+		stopTimeout:     10 * time.Second,
+		stopTimeouts:    map[string]time.Duration{},
 	}
 	for _, option := range options {
 		if option == nil {
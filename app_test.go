@@ -0,0 +1,215 @@
+package tool
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/wjoj/tool/v2/config"
+	"github.com/wjoj/tool/v2/health"
+	"github.com/wjoj/tool/v2/log"
+)
+
+func TestMain(m *testing.M) {
+	log.NewGlobal(log.Config{Level: "info"})
+	os.Exit(m.Run())
+}
+
+func TestConfigSetFlag(t *testing.T) {
+	a := NewApp().Config().ConfigSource(&config.FileSource{Path: "config.yaml"})
+	if err := a.rootCmd.PersistentFlags().Parse([]string{"--set", "a=1", "--set", "b=2"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*a.cmdarg.sets) != 2 {
+		t.Fatalf("sets %v", *a.cmdarg.sets)
+	}
+}
+
+// testRecorder 记录启动和停止顺序
+type testRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *testRecorder) add(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *testRecorder) fn(event string) func() error {
+	return func() error {
+		r.add(event)
+		return nil
+	}
+}
+
+func (r *testRecorder) list() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.events)
+}
+
+// testServer 在Shutdown前阻塞 block不为nil时Shutdown阻塞到关闭
+type testServer struct {
+	rec   *testRecorder
+	name  string
+	done  chan struct{}
+	block chan struct{}
+}
+
+func (s *testServer) ListenAndServe() error {
+	<-s.done
+	return http.ErrServerClosed
+}
+
+func (s *testServer) Shutdown(ctx context.Context) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.rec.add("stop " + s.name)
+	close(s.done)
+	return nil
+}
+
+// testApp 用记录顺序的函数代替内置的log redis http组件
+func testApp(rec *testRecorder, opts ...Option) (*App, []funcErr) {
+	a := NewApp(append([]Option{WithQuitEnableOption()}, opts...)...)
+	var fs []funcErr
+	for _, name := range []fnNameType{fnNameLog, fnNameRedis, fnNameHttp} {
+		f := funcErr{
+			Name:      name,
+			Fn:        rec.fn("start " + string(name)),
+			RekeaseFn: rec.fn("stop " + string(name)),
+		}
+		a.fnMap[name] = f
+		fs = append(fs, f)
+	}
+	return a, fs
+}
+
+// registry多次调用时服务的依赖不累加 AddServer不添加服务
+func TestAppServerDeps(t *testing.T) {
+	rec := &testRecorder{}
+	a, fs := testApp(rec)
+	a.AddServer("old").AddServerComponent("grpc", &testServer{rec: rec, name: "grpc", done: make(chan struct{})})
+	for range 2 {
+		reg, err := a.registry(fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sorted, err := reg.Sorted()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := names(sorted); !slices.Equal(got, []string{"log", "redis", "http", "server-grpc"}) {
+			t.Fatalf("sorted %v", got)
+		}
+		if deps := sorted[3].Dependencies(); !slices.Equal(deps, []string{"log", "redis", "http"}) {
+			t.Fatalf("server deps %v", deps)
+		}
+	}
+}
+
+func TestAppOrder(t *testing.T) {
+	t.Cleanup(func() { health.SetShutdown(false) })
+	rec := &testRecorder{}
+	a, fs := testApp(rec, WithDrainDelayOption(50*time.Millisecond))
+	a.WithFunc(true, rec.fn("start with-1")).
+		WithRekeaseFunc(true, rec.fn("stop with-2")).
+		AddServerComponent("grpc", &testServer{rec: rec, name: "grpc", done: make(chan struct{})})
+	reg, err := a.registry(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sorted, err := reg.Sorted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 内置组件 -> With添加的步骤 -> http -> AddServerComponent添加的服务
+	want := []string{"log", "redis", "with-1", "with-2", "http", "server-grpc"}
+	if got := names(sorted); !slices.Equal(got, want) {
+		t.Fatalf("sorted %v", got)
+	}
+
+	if err := a.run(reg); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := a.rekease(reg); err != nil {
+		t.Fatal(err)
+	}
+	// 停止前先标记未就绪并等待摘除流量
+	if !health.IsShutdown() || time.Since(start) < 50*time.Millisecond {
+		t.Fatalf("drain shutdown %v elapsed %v", health.IsShutdown(), time.Since(start))
+	}
+	want = []string{
+		"start log", "start redis", "start with-1", "start http",
+		"stop grpc", "stop http", "stop with-2", "stop redis", "stop log",
+	}
+	if got := rec.list(); !slices.Equal(got, want) {
+		t.Fatalf("events %v", got)
+	}
+}
+
+func TestAppShutdownTimeout(t *testing.T) {
+	t.Cleanup(func() { health.SetShutdown(false) })
+	block := make(chan struct{})
+	t.Cleanup(func() { close(block) })
+	rec := &testRecorder{}
+	a, fs := testApp(rec, WithShutdownTimeoutOption(50*time.Millisecond))
+	a.AddServerComponent("slow", &testServer{rec: rec, name: "slow", done: make(chan struct{}), block: block})
+	reg, err := a.registry(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.run(reg); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	err = a.rekease(reg)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "server-slow") {
+		t.Fatalf("stop %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("stop took %v", elapsed)
+	}
+	if a.ctx.Err() == nil {
+		t.Fatal("root context should be canceled")
+	}
+	if got := rec.list(); slices.Contains(got, "stop slow") {
+		t.Fatalf("events %v", got)
+	}
+}
+
+// 第二次收到退出信号时立即退出 在子进程中执行
+func TestAppNotifySecondSignal(t *testing.T) {
+	if os.Getenv("TOOL_TEST_NOTIFY") == "1" {
+		a := NewApp()
+		stop := a.notify()
+		defer stop()
+		syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		<-a.ctx.Done()
+		syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		time.Sleep(5 * time.Second)
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestAppNotifySecondSignal$")
+	cmd.Env = append(os.Environ(), "TOOL_TEST_NOTIFY=1")
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Fatalf("exit %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "shutting down") || !strings.Contains(string(out), "forcing exit") {
+		t.Fatalf("output %s", out)
+	}
+}
@@ -0,0 +1,365 @@
+package tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/wjoj/tool/v2/config"
+	"github.com/wjoj/tool/v2/db/dbx"
+	"github.com/wjoj/tool/v2/health"
+	"github.com/wjoj/tool/v2/httpx"
+)
+
+// withComponents 启动子命令需要的组件 执行fn后停止
+func (a *App) withComponents(fn func(ctx context.Context) error, names ...fnNameType) error {
+	reg, err := a.bootstrap(names...)
+	if err != nil {
+		return err
+	}
+	err = fn(a.ctx)
+	ctx, cancel := context.WithTimeout(context.Background(), a.opt.shutdownTimeout)
+	defer cancel()
+	return errors.Join(err, reg.Stop(ctx))
+}
+
+// configCmd 配置相关的子命令
+func (a *App) configCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Configuration tools",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "print",
+		Short: "Print the effective configuration with secrets masked",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := a.fnMap[fnNameConfig].Fn(); err != nil {
+				return err
+			}
+			return config.Print(cmd.OutOrStdout())
+		},
+	}, &cobra.Command{
+		Use:   "validate",
+		Short: "Validate the configuration and exit",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := a.fnMap[fnNameConfig].Fn(); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "config ok")
+			return nil
+		},
+	})
+	return cmd
+}
+
+// dbCmd 数据库相关的子命令
+func (a *App) dbCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "db",
+		Short: "Database tools",
+	}
+	cmd.AddCommand(a.migrateCmd(), a.genCmd(), &cobra.Command{
+		Use:   "ping",
+		Short: "Ping all configured databases",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.withComponents(func(ctx context.Context) error {
+				return printChecks(ctx, cmd.OutOrStdout(), "db:")
+			}, fnNameGorm)
+		},
+	})
+	return cmd
+}
+
+// migrateCmd 没有子命令时自动迁移App.Migrate设置的模型 子命令执行App.Migrations注册的版本迁移
+func (a *App) migrateCmd() *cobra.Command {
+	var keys []string
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Migrate the models registered by App.Migrate, or run versioned migrations with the subcommands",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(a.models) == 0 {
+				return errors.New("no models to migrate, register them with App.Migrate")
+			}
+			return a.withComponents(func(ctx context.Context) error {
+				if err := dbx.AutoMigrate(a.models...); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "migrated %d models\n", len(a.models))
+				return nil
+			}, fnNameGorm)
+		},
+	}
+	cmd.PersistentFlags().StringSliceVar(&keys, "db", nil, "db keys to migrate, default all with registered migrations")
+	// run 对每个db执行fn
+	run := func(fn func(ctx context.Context, w io.Writer, key string, m *dbx.Migrator) error) func(cmd *cobra.Command, args []string) error {
+		return func(cmd *cobra.Command, args []string) error {
+			all := dbx.Migrations()
+			if len(keys) == 0 {
+				for key := range all {
+					keys = append(keys, key)
+				}
+				slices.Sort(keys)
+			}
+			if len(keys) == 0 {
+				return errors.New("no migrations, register them with App.Migrations")
+			}
+			return a.withComponents(func(ctx context.Context) error {
+				for _, key := range keys {
+					if _, is := config.GetDbs()[key]; !is {
+						return fmt.Errorf("db %s not configured", key)
+					}
+					m, err := dbx.NewMigrator(dbx.Get(key), all[key])
+					if err != nil {
+						return fmt.Errorf("db %s: %w", key, err)
+					}
+					// 迁移key配置的数据库 database模式的租户库需要分别迁移
+					if err := fn(dbx.SkipTenant(ctx), cmd.OutOrStdout(), key, m); err != nil {
+						return fmt.Errorf("db %s: %w", key, err)
+					}
+				}
+				return nil
+			}, fnNameGorm)
+		}
+	}
+	printDone := func(w io.Writer, key string, done []dbx.MigrationStatus) {
+		if len(done) == 0 {
+			fmt.Fprintf(w, "%s: nothing to migrate\n", key)
+		}
+		for _, st := range done {
+			action := "down"
+			if st.Applied {
+				action = "up"
+			}
+			fmt.Fprintf(w, "%s: %s %d_%s\n", key, action, st.Version, st.Name)
+		}
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "up",
+		Short: "Apply all pending migrations",
+		Args:  cobra.NoArgs,
+		RunE: run(func(ctx context.Context, w io.Writer, key string, m *dbx.Migrator) error {
+			done, err := m.Up(ctx)
+			printDone(w, key, done)
+			return err
+		}),
+	}, &cobra.Command{
+		Use:   "down [steps]",
+		Short: "Roll back the last applied migrations, 1 by default",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			steps := 1
+			if len(args) != 0 {
+				n, err := strconv.Atoi(args[0])
+				if err != nil || n < 1 {
+					return fmt.Errorf("invalid steps %q", args[0])
+				}
+				steps = n
+			}
+			return run(func(ctx context.Context, w io.Writer, key string, m *dbx.Migrator) error {
+				done, err := m.Down(ctx, steps)
+				printDone(w, key, done)
+				return err
+			})(cmd, args)
+		},
+	}, &cobra.Command{
+		Use:   "to <version>",
+		Short: "Migrate up or down to the version",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			version, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil || version < 0 {
+				return fmt.Errorf("invalid version %q", args[0])
+			}
+			return run(func(ctx context.Context, w io.Writer, key string, m *dbx.Migrator) error {
+				done, err := m.To(ctx, version)
+				printDone(w, key, done)
+				return err
+			})(cmd, args)
+		},
+	}, &cobra.Command{
+		Use:   "status",
+		Short: "Print the status of the migrations",
+		Args:  cobra.NoArgs,
+		RunE: run(func(ctx context.Context, w io.Writer, key string, m *dbx.Migrator) error {
+			status, err := m.Status(ctx)
+			if err != nil {
+				return err
+			}
+			tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "DB\tVERSION\tNAME\tSTATUS\tAPPLIED AT")
+			for _, st := range status {
+				state, at := "pending", ""
+				if st.Applied {
+					state, at = "applied", st.AppliedAt.Format(time.DateTime)
+				}
+				if st.Missing {
+					state = "missing"
+				}
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", key, st.Version, st.Name, state, at)
+			}
+			return tw.Flush()
+		}),
+	})
+	return cmd
+}
+
+// genCmd 根据数据库表生成model和query代码 只初始化数据库
+// --dry-run 不修改文件 输出会变化和不再生成的文件 有变化时返回错误 用于CI检查生成的代码是否过期
+func (a *App) genCmd() *cobra.Command {
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "gen",
+		Short: "Generate gorm models and queries from the database tables",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, is := a.fnMap[fnNameGorm]; !is {
+				a.Gorm()
+			}
+			return a.withComponents(func(ctx context.Context) error {
+				changes, err := dbx.Gen(config.GetGen(), dryRun, a.genOpts...)
+				if err != nil {
+					return err
+				}
+				w := cmd.OutOrStdout()
+				if !dryRun {
+					for _, c := range changes {
+						if c.New == nil {
+							fmt.Fprintf(w, "removed %s\n", c.Path)
+							continue
+						}
+						fmt.Fprintf(w, "generated %s\n", c.Path)
+					}
+					return nil
+				}
+				for _, c := range changes {
+					fmt.Fprint(w, c.Diff())
+				}
+				if len(changes) != 0 {
+					return fmt.Errorf("%d generated files are stale", len(changes))
+				}
+				fmt.Fprintln(w, "generated code is up to date")
+				return nil
+			}, fnNameGorm)
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the changes without writing files, exit non-zero if any")
+	return cmd
+}
+
+// redisCmd redis相关的子命令
+func (a *App) redisCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "redis",
+		Short: "Redis tools",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "ping",
+		Short: "Ping all configured redis",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.withComponents(func(ctx context.Context) error {
+				return printChecks(ctx, cmd.OutOrStdout(), "redis:")
+			}, fnNameRedis)
+		},
+	})
+	return cmd
+}
+
+// printChecks 执行名称以prefix开头的健康检查并输出结果 有失败时返回错误
+func printChecks(ctx context.Context, w io.Writer, prefix string) error {
+	health.SetInterval(0)
+	report := health.Check(ctx)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	var failed []string
+	for _, name := range health.Names() {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		st := report.Checks[name]
+		if st.Status != health.StatusUp {
+			failed = append(failed, name)
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", name, st.Status, st.LastError)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, st.Status, st.Latency)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("ping failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// routesCmd 输出注册的http路由
+func (a *App) routesCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "routes",
+		Short: "List the registered http routes",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.withComponents(func(ctx context.Context) error {
+				routes, err := httpx.Routes(config.GetHttp(), a.httpOpts...)
+				if err != nil {
+					return err
+				}
+				keys := make([]string, 0, len(routes))
+				for key := range routes {
+					keys = append(keys, key)
+				}
+				slices.Sort(keys)
+				w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "SERVER\tMETHOD\tPATH\tHANDLER")
+				for _, key := range keys {
+					for _, r := range routes[key] {
+						fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", key, r.Method, r.Path, r.Handler)
+					}
+				}
+				return w.Flush()
+			}, fnNameRedis, fnNameGorm, fnNameMongo, fnNameJwt, fnNameCasbin)
+		},
+	}
+}
+
+// versionCmd 输出构建信息
+func (a *App) versionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print the build information",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			w := cmd.OutOrStdout()
+			info, is := debug.ReadBuildInfo()
+			if !is {
+				return errors.New("build information not available")
+			}
+			fmt.Fprintf(w, "name:       %s\n", a.rootCmd.Name())
+			fmt.Fprintf(w, "module:     %s %s\n", info.Main.Path, info.Main.Version)
+			fmt.Fprintf(w, "go:         %s\n", info.GoVersion)
+			for _, s := range info.Settings {
+				switch s.Key {
+				case "vcs.revision":
+					fmt.Fprintf(w, "revision:   %s\n", s.Value)
+				case "vcs.time":
+					fmt.Fprintf(w, "build time: %s\n", s.Value)
+				case "vcs.modified":
+					fmt.Fprintf(w, "modified:   %s\n", s.Value)
+				}
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,100 @@
+package tool
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/wjoj/tool/v2/config"
+)
+
+// runCmd 执行子命令 返回输出
+func runCmd(t *testing.T, a *App, args ...string) (string, error) {
+	t.Helper()
+	t.Cleanup(func() {
+		config.SetFlagOverrides(nil)
+		viper.Reset()
+	})
+	var buf bytes.Buffer
+	a.rootCmd.SetOut(&buf)
+	a.rootCmd.SetErr(&buf)
+	a.rootCmd.SetArgs(args)
+	err := a.Run()
+	return buf.String(), err
+}
+
+func TestConfigCmd(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(`
+dbs:
+  def:
+    driver: mysql
+    user: root
+    password: file-secret
+    dbname: test
+jwts:
+  def:
+    secret: jwt-secret
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		secrets []string
+		err     string
+	}{
+		{
+			name:    "print",
+			args:    []string{"config", "print", "-r", dir},
+			want:    []string{"user: root", "password: '******'", "secret: '******'"},
+			secrets: []string{"file-secret", "jwt-secret"},
+		},
+		{
+			name:    "print with set",
+			args:    []string{"config", "print", "-r", dir, "--set", "dbs.def.password=flag-secret", "--set", "dbs.def.user=admin"},
+			want:    []string{"user: admin", "password: '******'"},
+			secrets: []string{"file-secret", "flag-secret"},
+		},
+		{
+			name: "validate",
+			args: []string{"config", "validate", "-r", dir},
+			want: []string{"config ok"},
+		},
+		{
+			name: "validate invalid",
+			args: []string{"config", "validate", "-r", dir, "--set", "dbs.def.port=70000"},
+			err:  "dbs.def.port: must be 1-65535",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewApp(WithNameOption("tool"), WithExitDisableOption()).Config()
+			out, err := runCmd(t, a, tt.args...)
+			if len(tt.err) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err %v\n%s", err, out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%v\n%s", err, out)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Fatalf("missing %q in:\n%s", want, out)
+				}
+			}
+			for _, secret := range tt.secrets {
+				if strings.Contains(out, secret) {
+					t.Fatalf("%s is not masked:\n%s", secret, out)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Component 组件生命周期
+// 通过 App.Component 注册后, 与内置的 redis/gorm/http 等组件一起按依赖顺序启动, 按相反顺序停止
+type Component interface {
+	Name() string                    //组件名称 唯一
+	Dependencies() []string          //依赖的组件名称 依赖的组件先启动后停止
+	Start(ctx context.Context) error //启动
+	Stop(ctx context.Context) error  //停止
+}
+
+// Registry 组件注册表
+type Registry struct {
+	comps       map[string]Component
+	names       []string //注册顺序 无依赖关系时保持注册顺序
+	started     []Component
+	stopTimeout time.Duration            //每个组件默认的停止超时时间 0:只受Stop的ctx限制
+	timeouts    map[string]time.Duration //指定组件的停止超时时间
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		comps:    make(map[string]Component),
+		timeouts: make(map[string]time.Duration),
+	}
+}
+
+// SetStopTimeout 设置每个组件默认的停止超时时间
+func (r *Registry) SetStopTimeout(timeout time.Duration) {
+	r.stopTimeout = timeout
+}
+
+// SetComponentStopTimeout 设置指定组件的停止超时时间
+func (r *Registry) SetComponentStopTimeout(name string, timeout time.Duration) {
+	r.timeouts[name] = timeout
+}
+
+// Register 注册组件 名称重复返回错误
+func (r *Registry) Register(comps ...Component) error {
+	for _, c := range comps {
+		if c == nil {
+			continue
+		}
+		name := c.Name()
+		if len(name) == 0 {
+			return errors.New("component name is empty")
+		}
+		if _, is := r.comps[name]; is {
+			return fmt.Errorf("component %s already registered", name)
+		}
+		r.comps[name] = c
+		r.names = append(r.names, name)
+	}
+	return nil
+}
+
+// Get 获取已注册的组件
+func (r *Registry) Get(name string) (Component, bool) {
+	c, is := r.comps[name]
+	return c, is
+}
+
+// Sorted 按依赖关系排序(拓扑排序) 依赖不存在或存在循环依赖时返回错误
+func (r *Registry) Sorted() ([]Component, error) {
+	// 未访问的为0
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[string]int, len(r.comps))
+	sorted := make([]Component, 0, len(r.comps))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			idx := slices.Index(path, name)
+			return fmt.Errorf("component dependency cycle: %s", strings.Join(append(path[idx:], name), " -> "))
+		}
+		state[name] = visiting
+		c := r.comps[name]
+		for _, dep := range c.Dependencies() {
+			if _, is := r.comps[dep]; !is {
+				return fmt.Errorf("component %s depends on %s which is not registered", name, dep)
+			}
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		sorted = append(sorted, c)
+		return nil
+	}
+	for _, name := range r.names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// Start 按依赖顺序启动所有组件 启动失败时按相反顺序停止已启动的组件
+func (r *Registry) Start(ctx context.Context) error {
+	sorted, err := r.Sorted()
+	if err != nil {
+		return err
+	}
+	for _, c := range sorted {
+		if err := c.Start(ctx); err != nil {
+			err = fmt.Errorf("component %s start error: %w", c.Name(), err)
+			if stopErr := r.Stop(ctx); stopErr != nil {
+				return errors.Join(err, stopErr)
+			}
+			return err
+		}
+		r.started = append(r.started, c)
+	}
+	return nil
+}
+
+// Stop 按启动的相反顺序停止组件 返回所有停止错误
+// ctx为整体的停止期限 每个组件另受自身停止超时时间限制, 超时的组件不会阻塞后面组件的停止
+func (r *Registry) Stop(ctx context.Context) error {
+	var errs []error
+	for i := len(r.started) - 1; i >= 0; i-- {
+		c := r.started[i]
+		if err := r.stop(ctx, c); err != nil {
+			errs = append(errs, fmt.Errorf("component %s stop error: %w", c.Name(), err))
+		}
+	}
+	r.started = nil
+	return errors.Join(errs...)
+}
+
+func (r *Registry) stop(ctx context.Context, c Component) error {
+	timeout, is := r.timeouts[c.Name()]
+	if !is {
+		timeout = r.stopTimeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Stop(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 内置组件名称 自定义组件可以依赖这些组件
+const (
+	ComponentConfig  = string(fnNameConfig)
+	ComponentLog     = string(fnNameLog)
+	ComponentRedis   = string(fnNameRedis)
+	ComponentGorm    = string(fnNameGorm)
+	ComponentMongo   = string(fnNameMongo)
+	ComponentJwt     = string(fnNameJwt)
+	ComponentCasbin  = string(fnNameCasbin)
+	ComponentHttp    = string(fnNameHttp)
+	ComponentGenGorm = string(fnNameGenGorm) // Deprecated: 代码生成已改为gen子命令 不再作为组件启动
+)
@@ -0,0 +1,209 @@
+package tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testComponent struct {
+	name     string
+	deps     []string
+	startErr error
+	stopErr  error
+	stopWait time.Duration //停止耗时
+	block    chan struct{} //不为nil时停止一直阻塞到关闭 不响应ctx
+	events   *[]string
+}
+
+func (c *testComponent) Name() string           { return c.name }
+func (c *testComponent) Dependencies() []string { return c.deps }
+
+func (c *testComponent) Start(ctx context.Context) error {
+	if c.startErr != nil {
+		return c.startErr
+	}
+	*c.events = append(*c.events, "start "+c.name)
+	return nil
+}
+
+func (c *testComponent) Stop(ctx context.Context) error {
+	if c.block != nil {
+		<-c.block
+		return nil
+	}
+	time.Sleep(c.stopWait)
+	*c.events = append(*c.events, "stop "+c.name)
+	return c.stopErr
+}
+
+func names(comps []Component) []string {
+	var ns []string
+	for _, c := range comps {
+		ns = append(ns, c.Name())
+	}
+	return ns
+}
+
+func TestRegistrySorted(t *testing.T) {
+	var events []string
+	r := NewRegistry()
+	err := r.Register(
+		&testComponent{name: "api", deps: []string{"db", "cache"}, events: &events},
+		&testComponent{name: "cache", deps: []string{"config"}, events: &events},
+		&testComponent{name: "db", deps: []string{"config"}, events: &events},
+		&testComponent{name: "config", events: &events},
+		&testComponent{name: "metrics", events: &events},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sorted, err := r.Sorted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 依赖先于被依赖的组件 无依赖关系时保持注册顺序
+	if got := names(sorted); !slices.Equal(got, []string{"config", "db", "cache", "api", "metrics"}) {
+		t.Fatalf("sorted %v", got)
+	}
+	if err := r.Register(&testComponent{name: "db", events: &events}); err == nil {
+		t.Fatal("expected duplicate error")
+	}
+}
+
+func TestRegistrySortedError(t *testing.T) {
+	var events []string
+	tests := []struct {
+		name  string
+		comps []Component
+		want  string
+	}{
+		{
+			name: "cycle",
+			comps: []Component{
+				&testComponent{name: "a", deps: []string{"b"}, events: &events},
+				&testComponent{name: "b", deps: []string{"c"}, events: &events},
+				&testComponent{name: "c", deps: []string{"a"}, events: &events},
+			},
+			want: "cycle: a -> b -> c -> a",
+		},
+		{
+			name:  "self",
+			comps: []Component{&testComponent{name: "a", deps: []string{"a"}, events: &events}},
+			want:  "cycle: a -> a",
+		},
+		{
+			name:  "missing",
+			comps: []Component{&testComponent{name: "a", deps: []string{"db"}, events: &events}},
+			want:  "a depends on db which is not registered",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			if err := r.Register(tt.comps...); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := r.Sorted(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("sorted %v", err)
+			}
+			if err := r.Start(context.Background()); err == nil {
+				t.Fatal("start should fail")
+			}
+		})
+	}
+	if len(events) != 0 {
+		t.Fatalf("events %v", events)
+	}
+}
+
+func TestRegistryStartRollback(t *testing.T) {
+	var events []string
+	errStart := errors.New("start fail")
+	errStop := errors.New("stop fail")
+	r := NewRegistry()
+	r.Register(
+		&testComponent{name: "a", events: &events},
+		&testComponent{name: "b", deps: []string{"a"}, stopErr: errStop, events: &events},
+		&testComponent{name: "c", deps: []string{"b"}, startErr: errStart, events: &events},
+		&testComponent{name: "d", deps: []string{"c"}, events: &events},
+	)
+	err := r.Start(context.Background())
+	if !errors.Is(err, errStart) || !errors.Is(err, errStop) {
+		t.Fatalf("start %v", err)
+	}
+	// 已启动的组件按相反顺序停止 未启动的不停止
+	if want := []string{"start a", "start b", "stop b", "stop a"}; !slices.Equal(events, want) {
+		t.Fatalf("events %v", events)
+	}
+}
+
+func TestRegistryStop(t *testing.T) {
+	var events []string
+	errA, errC := errors.New("a fail"), errors.New("c fail")
+	r := NewRegistry()
+	r.Register(
+		&testComponent{name: "a", stopErr: errA, events: &events},
+		&testComponent{name: "b", deps: []string{"a"}, events: &events},
+		&testComponent{name: "c", deps: []string{"b"}, stopErr: errC, events: &events},
+	)
+	if err := r.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	err := r.Stop(context.Background())
+	if !errors.Is(err, errA) || !errors.Is(err, errC) {
+		t.Fatalf("stop %v", err)
+	}
+	if want := []string{"start a", "start b", "start c", "stop c", "stop b", "stop a"}; !slices.Equal(events, want) {
+		t.Fatalf("events %v", events)
+	}
+	// 已停止的组件不再停止
+	if err := r.Stop(context.Background()); err != nil || len(events) != 6 {
+		t.Fatalf("second stop %v %v", err, events)
+	}
+}
+
+func TestRegistryStopTimeout(t *testing.T) {
+	var events []string
+	block := make(chan struct{})
+	t.Cleanup(func() { close(block) })
+	r := NewRegistry()
+	r.SetStopTimeout(20 * time.Millisecond)
+	r.SetComponentStopTimeout("slow", 30*time.Millisecond)
+	r.SetComponentStopTimeout("fast", 0)
+	r.Register(
+		&testComponent{name: "first", events: &events},
+		&testComponent{name: "default", block: block, events: &events},
+		&testComponent{name: "slow", block: block, events: &events},
+		&testComponent{name: "fast", stopWait: 10 * time.Millisecond, events: &events},
+	)
+	if err := r.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	events = events[:0]
+	start := time.Now()
+	err := r.Stop(context.Background())
+	elapsed := time.Since(start)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("stop %v", err)
+	}
+	for _, name := range []string{"default", "slow"} {
+		if !strings.Contains(err.Error(), fmt.Sprintf("component %s stop error", name)) {
+			t.Fatalf("%s should time out: %v", name, err)
+		}
+	}
+	if strings.Contains(err.Error(), "fast") {
+		t.Fatalf("fast should stop: %v", err)
+	}
+	// 超时的组件不阻塞后面组件的停止
+	if elapsed > 500*time.Millisecond {
+		t.Fatalf("stop took %v", elapsed)
+	}
+	if !slices.Contains(events, "stop first") || !slices.Contains(events, "stop fast") {
+		t.Fatalf("events %v", events)
+	}
+}
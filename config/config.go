@@ -1,6 +1,8 @@
 package config
 
 import (
+	"sync"
+
 	"github.com/wjoj/tool/v2/db/dbx"
 	"github.com/wjoj/tool/v2/db/mongox"
 	"github.com/wjoj/tool/v2/db/redisx"
@@ -12,6 +14,7 @@ import (
 )
 
 var (
+	mu         sync.RWMutex //配置重新加载时保护以下变量
 	env        EnvType
 	namespace  string
 	configFile string
@@ -23,6 +26,7 @@ var (
 	http       map[string]httpx.Config
 	casbins    map[string]casbinx.Config
 	jwts       map[string]jwt.Config
+	gen        map[string]dbx.GenConfig
 )
 
 func SetDefaultKey(key string) {
@@ -35,44 +39,66 @@ func GetDefaultKey() string {
 
 // GetEnv 获取环境
 func GetEnv() EnvType {
+	mu.RLock()
+	defer mu.RUnlock()
 	return env
 }
 func SetEnv(e EnvType) {
+	mu.Lock()
+	defer mu.Unlock()
 	env = e
 }
 func GetNamespace() string {
+	mu.RLock()
+	defer mu.RUnlock()
 	return namespace
 }
 
 func SetNamespace(n string) {
+	mu.Lock()
+	defer mu.Unlock()
 	namespace = n
 }
 func GetConfigFile() string {
+	mu.RLock()
+	defer mu.RUnlock()
 	return configFile
 }
 func SetConfigFile(f string) {
+	mu.Lock()
+	defer mu.Unlock()
 	configFile = f
 }
 
 func GetConfigRoot() string {
+	mu.RLock()
+	defer mu.RUnlock()
 	return configRoot
 }
 func SetConfigRoot(r string) {
+	mu.Lock()
+	defer mu.Unlock()
 	configRoot = r
 }
 
 // SetLog 设置日志
 func SetLog(lgs map[string]log.Config) {
+	mu.Lock()
+	defer mu.Unlock()
 	logs = lgs
 }
 
 func GetLogs() map[string]log.Config {
+	mu.RLock()
+	defer mu.RUnlock()
 	return logs
 }
 
 // GetLog 获取日志
 func GetLog(key ...string) (logc log.Config) {
 	logc, err := utils.Get("config log", GetDefaultKey(), func(k string) (log.Config, bool) {
+		mu.RLock()
+		defer mu.RUnlock()
 		m, is := logs[k]
 		return m, is
 	}, key...)
@@ -83,16 +109,22 @@ func GetLog(key ...string) (logc log.Config) {
 }
 
 func SetRediss(r map[string]redisx.Config) {
+	mu.Lock()
+	defer mu.Unlock()
 	rediss = r
 }
 
 // SetRedis 设置redis
 func GetRediss() map[string]redisx.Config {
+	mu.RLock()
+	defer mu.RUnlock()
 	return rediss
 }
 
 func GetRedis(key ...string) (redis redisx.Config) {
 	redis, err := utils.Get("config redis", GetDefaultKey(), func(k string) (redisx.Config, bool) {
+		mu.RLock()
+		defer mu.RUnlock()
 		m, is := rediss[k]
 		return m, is
 	}, key...)
@@ -103,16 +135,22 @@ func GetRedis(key ...string) (redis redisx.Config) {
 }
 
 func SetDbs(d map[string]dbx.Config) {
+	mu.Lock()
+	defer mu.Unlock()
 	dbs = d
 }
 
 // SetDb 设置db
 func GetDbs() map[string]dbx.Config {
+	mu.RLock()
+	defer mu.RUnlock()
 	return dbs
 }
 
 func GetDb(key ...string) (db dbx.Config) {
 	db, err := utils.Get("config db", GetDefaultKey(), func(k string) (dbx.Config, bool) {
+		mu.RLock()
+		defer mu.RUnlock()
 		m, is := dbs[k]
 		return m, is
 	}, key...)
@@ -123,15 +161,21 @@ func GetDb(key ...string) (db dbx.Config) {
 }
 
 func SetMongos(m map[string]mongox.Config) {
+	mu.Lock()
+	defer mu.Unlock()
 	mongos = m
 }
 
 func GetMongos() map[string]mongox.Config {
+	mu.RLock()
+	defer mu.RUnlock()
 	return mongos
 }
 
 func GetMongo(key ...string) (mgo mongox.Config) {
 	mgo, err := utils.Get("config mongo", GetDefaultKey(), func(k string) (mongox.Config, bool) {
+		mu.RLock()
+		defer mu.RUnlock()
 		m, is := mongos[k]
 		return m, is
 	}, key...)
@@ -142,15 +186,21 @@ func GetMongo(key ...string) (mgo mongox.Config) {
 }
 
 func SetHttp(h map[string]httpx.Config) {
+	mu.Lock()
+	defer mu.Unlock()
 	http = h
 }
 
 func GetHttp() map[string]httpx.Config {
+	mu.RLock()
+	defer mu.RUnlock()
 	return http
 }
 
 func GetHttpServer(key ...string) (cfg httpx.Config) {
 	cfg, err := utils.Get("config http", GetDefaultKey(), func(k string) (httpx.Config, bool) {
+		mu.RLock()
+		defer mu.RUnlock()
 		m, is := http[k]
 		return m, is
 	}, key...)
@@ -161,15 +211,21 @@ func GetHttpServer(key ...string) (cfg httpx.Config) {
 }
 
 func SetCasbins(c map[string]casbinx.Config) {
+	mu.Lock()
+	defer mu.Unlock()
 	casbins = c
 }
 
 func GetCasbins() map[string]casbinx.Config {
+	mu.RLock()
+	defer mu.RUnlock()
 	return casbins
 }
 
 func GetCasbin(key ...string) (casbin casbinx.Config) {
 	casbin, err := utils.Get("config casbin", GetDefaultKey(), func(k string) (casbinx.Config, bool) {
+		mu.RLock()
+		defer mu.RUnlock()
 		m, is := casbins[k]
 		return m, is
 	}, key...)
@@ -180,15 +236,21 @@ func GetCasbin(key ...string) (casbin casbinx.Config) {
 }
 
 func SetJwts(j map[string]jwt.Config) {
+	mu.Lock()
+	defer mu.Unlock()
 	jwts = j
 }
 
 func GetJwts() map[string]jwt.Config {
+	mu.RLock()
+	defer mu.RUnlock()
 	return jwts
 }
 
 func GetJwt(key ...string) (jt jwt.Config) {
 	jt, err := utils.Get("config jwt", GetDefaultKey(), func(k string) (jwt.Config, bool) {
+		mu.RLock()
+		defer mu.RUnlock()
 		m, is := jwts[k]
 		return m, is
 	}, key...)
@@ -197,3 +259,15 @@ func GetJwt(key ...string) (jt jwt.Config) {
 	}
 	return
 }
+
+func SetGen(g map[string]dbx.GenConfig) {
+	mu.Lock()
+	defer mu.Unlock()
+	gen = g
+}
+
+func GetGen() map[string]dbx.GenConfig {
+	mu.RLock()
+	defer mu.RUnlock()
+	return gen
+}
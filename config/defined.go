@@ -31,4 +31,5 @@ type App struct {
 	Http      map[string]httpx.Config   `yaml:"http" json:"http"`           //http配置
 	Casbins   map[string]casbinx.Config `yaml:"casbins" json:"casbins"`     //casbin配置
 	Jwts      map[string]jwt.Config     `yaml:"jwts" json:"jwts"`
+	Gen       map[string]dbx.GenConfig  `yaml:"gen" json:"gen"` //gen子命令生成代码的配置 key为db的key
 }
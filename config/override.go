@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// 配置覆盖的优先级: 配置文件 < 环境变量 < 命令行参数
+//
+// 环境变量命名: 前缀_配置段_KEY_字段, 全部大写, 字段名为配置文件中的名称去掉分隔符
+// 例: TOOL_DBS_DEF_PASSWORD 覆盖 dbs.def.password
+// 例: TOOL_HTTP_DEF_CORSCFG_ALLOWORIGINS=a.com,b.com 覆盖 http.def.corsCfg.allowOrigins
+// 例: TOOL_ENV 覆盖 env (兼容环境变量ENV)
+//
+// 命令行参数: --set 配置路径=值 可重复, 例: --set dbs.def.password=xxx
+
+var (
+	envPrefix     = "TOOL"
+	flagOverrides []string
+)
+
+// SetEnvPrefix 设置环境变量前缀 默认TOOL
+func SetEnvPrefix(prefix string) {
+	envPrefix = strings.ToUpper(prefix)
+}
+
+// SetFlagOverrides 设置命令行参数覆盖的配置 格式: 配置路径=值
+func SetFlagOverrides(sets []string) {
+	flagOverrides = sets
+}
+
+// applyOverrides 将环境变量和命令行参数覆盖到viper
+func applyOverrides() error {
+	if env := os.Getenv("ENV"); len(env) != 0 {
+		viper.Set("env", env)
+	}
+	applyEnv(os.Environ())
+	return applyFlags(flagOverrides)
+}
+
+// envOf 配置中的环境 环境变量和命令行参数优先 用于选择环境配置文件
+func envOf(cfg map[string]any) string {
+	env, _ := cfg["env"].(string)
+	if e := os.Getenv("ENV"); len(e) != 0 {
+		env = e
+	}
+	if e := os.Getenv(envPrefix + "_ENV"); len(e) != 0 {
+		env = e
+	}
+	for _, set := range flagOverrides {
+		if path, val, is := strings.Cut(set, "="); is && strings.TrimSpace(path) == "env" {
+			env = val
+		}
+	}
+	if len(env) == 0 {
+		env = string(EnvDevelopment)
+	}
+	return env
+}
+
+func applyEnv(environ []string) {
+	prefix := envPrefix + "_"
+	ty := reflect.TypeOf(App{})
+	for _, kv := range environ {
+		name, val, is := strings.Cut(kv, "=")
+		if !is || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		path, is := envPath(strings.TrimPrefix(name, prefix), ty)
+		if !is {
+			continue
+		}
+		viper.Set(strings.Join(path, "."), val)
+	}
+}
+
+// envPath 按配置结构将环境变量名解析为配置路径
+func envPath(name string, ty reflect.Type) ([]string, bool) {
+	for ty.Kind() == reflect.Ptr {
+		ty = ty.Elem()
+	}
+	switch ty.Kind() {
+	case reflect.Struct:
+		for i := range ty.NumField() {
+			field := ty.Field(i)
+			tag := fieldTagName(field)
+			if len(tag) == 0 {
+				continue
+			}
+			envName := strings.ToUpper(strings.NewReplacer("_", "", "-", "").Replace(tag))
+			if name == envName {
+				return []string{tag}, true
+			}
+			if rest, is := strings.CutPrefix(name, envName+"_"); is {
+				if path, is := envPath(rest, field.Type); is {
+					return append([]string{tag}, path...), true
+				}
+			}
+		}
+	case reflect.Map:
+		// key可以包含下划线 依次尝试每个分隔位置
+		for i := range len(name) {
+			if name[i] != '_' || i == 0 {
+				continue
+			}
+			if path, is := envPath(name[i+1:], ty.Elem()); is {
+				return append([]string{strings.ToLower(name[:i])}, path...), true
+			}
+		}
+	}
+	return nil, false
+}
+
+func fieldTagName(field reflect.StructField) string {
+	tag := field.Tag.Get(tagName)
+	if len(tag) == 0 {
+		tag = field.Tag.Get("yaml")
+	}
+	tag, _, _ = strings.Cut(tag, ",")
+	if tag == "-" {
+		return ""
+	}
+	if len(tag) == 0 {
+		return field.Name
+	}
+	return tag
+}
+
+func applyFlags(sets []string) error {
+	for _, set := range sets {
+		path, val, is := strings.Cut(set, "=")
+		path = strings.TrimSpace(path)
+		if !is || len(path) == 0 {
+			return fmt.Errorf("invalid config override %q, format: path=value", set)
+		}
+		viper.Set(path, val)
+	}
+	return nil
+}
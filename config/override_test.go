@@ -0,0 +1,69 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestEnvPath(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"DBS_DEF_PASSWORD", []string{"dbs", "def", "password"}},
+		{"DBS_MY_DB_PASSWORD", []string{"dbs", "my_db", "password"}},
+		{"HTTP_DEF_CORSCFG_ALLOWORIGINS", []string{"http", "def", "corsCfg", "allowOrigins"}},
+		{"HTTP_DEF_SHUTDOWNCLOSEMAXWAIT", []string{"http", "def", "shutdownCloseMaxWait"}},
+		{"ENV", []string{"env"}},
+		{"DBS_DEF_UNKNOWN", nil},
+		{"DBS_PASSWORD", nil},
+		{"UNKNOWN", nil},
+	}
+	for _, tt := range tests {
+		path, is := envPath(tt.name, reflect.TypeOf(App{}))
+		if is != (tt.want != nil) || !slices.Equal(path, tt.want) {
+			t.Errorf("envPath(%s) = %v %v", tt.name, path, is)
+		}
+	}
+}
+
+// 优先级: 配置文件 < 环境变量 < 命令行参数
+func TestOverridePrecedence(t *testing.T) {
+	// viper.Set的覆盖值在重新读取后仍保留
+	t.Cleanup(func() {
+		SetFlagOverrides(nil)
+		viper.Reset()
+	})
+	t.Setenv("TOOL_DBS_DEF_PASSWORD", "env-pass")
+	t.Setenv("TOOL_DBS_DEF_DBNAME", "env-db")
+	t.Setenv("TOOL_DBS_DEF_PORT", "3307")
+	t.Setenv("OTHER_DBS_DEF_USER", "other")
+	SetFlagOverrides([]string{"dbs.def.dbname=flag-db", "http.def.port=9000"})
+	src := &memSource{cfg: map[string]any{
+		"dbs": map[string]any{"def": map[string]any{
+			"user":     "file-user",
+			"password": "file-pass",
+			"dbname":   "file-db",
+			"port":     3306,
+		}},
+		"http": map[string]any{"def": map[string]any{"port": 8080}},
+	}}
+	if err := ReadSource(src); err != nil {
+		t.Fatal(err)
+	}
+	db := GetDbs()["def"]
+	if db.User != "file-user" || db.Pass != "env-pass" || db.DbName != "flag-db" || db.Port != 3307 {
+		t.Fatalf("db %+v", db)
+	}
+	if port := GetHttp()["def"].Port; port != 9000 {
+		t.Fatalf("http port %d", port)
+	}
+
+	SetFlagOverrides([]string{"dbs.def.dbname"})
+	if err := ReadSource(src); err == nil {
+		t.Fatal("expected invalid override")
+	}
+}
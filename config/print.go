@@ -0,0 +1,77 @@
+package config
+
+import (
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const maskValue = "******"
+
+// secretKeys 需要隐藏的配置字段
+var secretKeys = []string{"password", "pass", "secret", "token", "dsn"}
+
+// Current 当前生效的配置(合并配置文件、环境变量和命令行参数之后)
+func Current() *App {
+	return &App{
+		Env:       GetEnv(),
+		Namespace: GetNamespace(),
+		Logs:      GetLogs(),
+		Rediss:    GetRediss(),
+		Dbs:       GetDbs(),
+		Mongos:    GetMongos(),
+		Http:      GetHttp(),
+		Casbins:   GetCasbins(),
+		Jwts:      GetJwts(),
+		Gen:       GetGen(),
+	}
+}
+
+// Print 以yaml格式输出当前生效的配置 密码等字段隐藏
+func Print(w io.Writer) error {
+	by, err := yaml.Marshal(Current())
+	if err != nil {
+		return err
+	}
+	var m map[string]any
+	if err := yaml.Unmarshal(by, &m); err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	defer enc.Close()
+	return enc.Encode(mask(m))
+}
+
+// mask 隐藏密码等字段 url中的密码
+func mask(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, item := range val {
+			if s, is := item.(string); is && len(s) != 0 && slices.Contains(secretKeys, strings.ToLower(k)) {
+				val[k] = maskValue
+				continue
+			}
+			val[k] = mask(item)
+		}
+		return val
+	case []any:
+		for i := range val {
+			val[i] = mask(val[i])
+		}
+		return val
+	case string:
+		if !strings.Contains(val, "@") || !strings.Contains(val, "://") {
+			return val
+		}
+		u, err := url.Parse(val)
+		if err != nil || u.User == nil {
+			return val
+		}
+		return u.Redacted()
+	}
+	return v
+}
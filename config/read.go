@@ -2,46 +2,43 @@ package config
 
 import (
 	"errors"
-	"fmt"
-	"os"
 	"path/filepath"
-	"strings"
 
-	"github.com/fsnotify/fsnotify"
 	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
 )
 
+var tagName = "yaml"
+
+// Read 读取本地配置文件 合并环境配置和引入的文件 并监听修改
 func Read(cfgRoot, cfgFile string) error {
-	cfgpath := filepath.Join(cfgRoot, cfgFile)
-	ext := strings.ToLower(strings.Replace(filepath.Ext(cfgFile), ".", "", 1))
-	if ext == "yml" {
-		ext = "yaml"
-	}
-	viper.SetConfigFile(cfgpath)
-	viper.SetConfigType(ext)
-	if err := viper.ReadInConfig(); err != nil {
-		return errors.New("read config file failed: " + err.Error())
-	}
-	tagName := ext
-	if ext == "yml" {
-		tagName = "yaml"
+	tagName = configType(cfgFile)
+	if err := readSource(&FileSource{Path: filepath.Join(cfgRoot, cfgFile)}); err != nil {
+		return err
 	}
+	SetConfigRoot(cfgRoot)
+	SetConfigFile(cfgFile)
+	return nil
+}
+
+// unmarshal 解析viper中的配置
+func unmarshal() (*App, error) {
 	var cfg *App
-	if err := viper.Unmarshal(&cfg, decoderTagName(tagName)); err != nil {
-		return errors.New("unmarshal config failed: " + err.Error())
+	if err := viper.Unmarshal(&cfg, decoderTagName(tagName), decoderSecret()); err != nil {
+		return nil, errors.New("unmarshal config failed: " + err.Error())
+	}
+	if cfg == nil {
+		cfg = &App{}
 	}
 	if len(cfg.Env) == 0 {
 		cfg.Env = "dev"
 	}
-	envstr := os.Getenv("ENV")
-	if len(envstr) != 0 {
-		cfg.Env = EnvType(envstr)
-	}
+	return cfg, nil
+}
 
+// set 设置全局配置
+func set(cfg *App) {
 	SetEnv(cfg.Env)
-	SetConfigRoot(cfgRoot)
-	SetConfigFile(cfgFile)
 	SetNamespace(cfg.Namespace)
 	SetLog(cfg.Logs)
 	SetRediss(cfg.Rediss)
@@ -50,11 +47,7 @@ func Read(cfgRoot, cfgFile string) error {
 	SetHttp(cfg.Http)
 	SetCasbins(cfg.Casbins)
 	SetJwts(cfg.Jwts)
-	viper.OnConfigChange(func(e fsnotify.Event) { // 监听配置文件修改
-		fmt.Printf("config file changed:%+v\n", e)
-	})
-	viper.WatchConfig()
-	return nil
+	SetGen(cfg.Gen)
 }
 
 func decoderTagName(tag string) viper.DecoderConfigOption {
@@ -62,3 +55,10 @@ func decoderTagName(tag string) viper.DecoderConfigOption {
 		dc.TagName = tag
 	}
 }
+
+// decoderSecret 解析前替换密钥引用
+func decoderSecret() viper.DecoderConfigOption {
+	return func(dc *mapstructure.DecoderConfig) {
+		dc.DecodeHook = mapstructure.ComposeDecodeHookFunc(secretHook(), dc.DecodeHook)
+	}
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/wjoj/tool/v2/log"
+	"github.com/wjoj/tool/v2/utils"
+)
+
+// SectionType 配置段
+type SectionType string
+
+const (
+	SectionLogs    SectionType = "logs"
+	SectionRediss  SectionType = "rediss"
+	SectionDbs     SectionType = "dbs"
+	SectionMongos  SectionType = "mongos"
+	SectionHttp    SectionType = "http"
+	SectionCasbins SectionType = "casbins"
+	SectionJwts    SectionType = "jwts"
+	SectionGen     SectionType = "gen" //只用于gen子命令 不通知修改
+)
+
+type ChangeType string
+
+const (
+	ChangeTypeAdd    ChangeType = "add"
+	ChangeTypeUpdate ChangeType = "update"
+	ChangeTypeDelete ChangeType = "delete"
+)
+
+// ErrRestartRequired 订阅者返回该错误表示修改无法动态生效 需要重启
+var ErrRestartRequired = utils.ErrRestartRequired
+
+// Change 配置段中某个key的修改
+type Change struct {
+	Section SectionType
+	Key     string
+	Type    ChangeType
+	Old     any //修改前的配置 类型为*C 新增时为nil
+	New     any //修改后的配置 类型为*C 删除时为nil
+}
+
+type ChangeFunc func(c Change) error
+
+var (
+	subMu       sync.RWMutex
+	subscribers = map[SectionType][]ChangeFunc{}
+	reloadMu    sync.Mutex
+)
+
+// OnChange 订阅配置段的修改
+func OnChange(section SectionType, fn ChangeFunc) {
+	subMu.Lock()
+	defer subMu.Unlock()
+	subscribers[section] = append(subscribers[section], fn)
+}
+
+// OnChangeOf 订阅配置段的修改 C为配置段中每个key的配置类型
+// 例: config.OnChangeOf(config.SectionDbs, func(key string, old, new *dbx.Config) error {...})
+func OnChangeOf[C any](section SectionType, fn func(key string, old, new *C) error) {
+	OnChange(section, func(c Change) error {
+		old, _ := c.Old.(*C)
+		new, _ := c.New.(*C)
+		return fn(c.Key, old, new)
+	})
+}
+
+// Reload 重新读取配置 与当前配置比较后更新并通知订阅者
+// 没有订阅者或订阅者返回ErrRestartRequired的修改会记录为需要重启
+func Reload() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	cached, err := load()
+	if err != nil {
+		return err
+	}
+	if cached != nil {
+		log.Warnf("config source unavailable, using cache: %v", cached)
+	}
+	cfg, err := unmarshal()
+	if err != nil {
+		return err
+	}
+	if err := validate(cfg); err != nil { // 校验失败时保留当前配置
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+	changes := Diff(cfg)
+	if GetEnv() != cfg.Env || GetNamespace() != cfg.Namespace {
+		log.Warnf("config env/namespace changed, restart required")
+	}
+	set(cfg)
+	var errs []error
+	for _, c := range changes {
+		if err := notify(c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Diff 比较当前配置与cfg 返回所有配置段的修改
+func Diff(cfg *App) []Change {
+	var changes []Change
+	changes = append(changes, diffSection(SectionLogs, GetLogs(), cfg.Logs)...)
+	changes = append(changes, diffSection(SectionRediss, GetRediss(), cfg.Rediss)...)
+	changes = append(changes, diffSection(SectionDbs, GetDbs(), cfg.Dbs)...)
+	changes = append(changes, diffSection(SectionMongos, GetMongos(), cfg.Mongos)...)
+	changes = append(changes, diffSection(SectionHttp, GetHttp(), cfg.Http)...)
+	changes = append(changes, diffSection(SectionCasbins, GetCasbins(), cfg.Casbins)...)
+	changes = append(changes, diffSection(SectionJwts, GetJwts(), cfg.Jwts)...)
+	return changes
+}
+
+func diffSection[C any](section SectionType, old, new map[string]C) []Change {
+	keys := make([]string, 0, len(old)+len(new))
+	for key := range old {
+		keys = append(keys, key)
+	}
+	for key := range new {
+		if _, is := old[key]; !is {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	var changes []Change
+	for _, key := range keys {
+		o, oldIs := old[key]
+		n, newIs := new[key]
+		c := Change{
+			Section: section,
+			Key:     key,
+		}
+		switch {
+		case oldIs && newIs:
+			if reflect.DeepEqual(o, n) {
+				continue
+			}
+			c.Type = ChangeTypeUpdate
+			c.Old, c.New = &o, &n
+		case newIs:
+			c.Type = ChangeTypeAdd
+			c.New = &n
+		default:
+			c.Type = ChangeTypeDelete
+			c.Old = &o
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// notify 通知订阅者
+func notify(c Change) error {
+	subMu.RLock()
+	fns := slices.Clone(subscribers[c.Section])
+	subMu.RUnlock()
+	if len(fns) == 0 {
+		log.Warnf("config %s.%s %s, restart required", c.Section, c.Key, c.Type)
+		return nil
+	}
+	var errs []error
+	restart := false
+	for _, fn := range fns {
+		err := fn(c)
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, ErrRestartRequired) {
+			restart = true
+			continue
+		}
+		errs = append(errs, fmt.Errorf("config %s.%s %s error: %w", c.Section, c.Key, c.Type, err))
+	}
+	if restart {
+		log.Warnf("config %s.%s %s, restart required", c.Section, c.Key, c.Type)
+	} else if len(errs) == 0 {
+		log.Infof("config %s.%s %s applied", c.Section, c.Key, c.Type)
+	}
+	return errors.Join(errs...)
+}
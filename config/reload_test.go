@@ -0,0 +1,148 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/wjoj/tool/v2/httpx"
+)
+
+// memSource 内存中的配置来源 修改后由测试调用Reload
+type memSource struct {
+	mu  sync.Mutex
+	cfg map[string]any
+}
+
+func (s *memSource) Name() string {
+	return "mem"
+}
+
+func (s *memSource) Load(ctx context.Context) (map[string]any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.cfg), nil
+}
+
+func (s *memSource) Watch(ctx context.Context, changed func()) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (s *memSource) set(cfg map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+}
+
+// resetSubscribers 测试结束后恢复订阅者
+func resetSubscribers(t *testing.T) {
+	t.Helper()
+	subMu.Lock()
+	old := subscribers
+	subscribers = map[SectionType][]ChangeFunc{}
+	subMu.Unlock()
+	t.Cleanup(func() {
+		subMu.Lock()
+		subscribers = old
+		subMu.Unlock()
+	})
+}
+
+func TestDiff(t *testing.T) {
+	old := GetHttp()
+	t.Cleanup(func() { SetHttp(old) })
+	SetHttp(map[string]httpx.Config{
+		"api":   {Port: 8080},
+		"admin": {Port: 8081},
+		"same":  {Port: 8082},
+	})
+	cfg := &App{
+		Logs:    GetLogs(),
+		Rediss:  GetRediss(),
+		Dbs:     GetDbs(),
+		Mongos:  GetMongos(),
+		Casbins: GetCasbins(),
+		Jwts:    GetJwts(),
+		Http: map[string]httpx.Config{
+			"api":  {Port: 9090},
+			"same": {Port: 8082},
+			"new":  {Port: 8083},
+		},
+	}
+	changes := Diff(cfg)
+	var got []string
+	for _, c := range changes {
+		got = append(got, string(c.Section)+"."+c.Key+" "+string(c.Type))
+	}
+	if want := []string{"http.admin delete", "http.api update", "http.new add"}; !slices.Equal(got, want) {
+		t.Fatalf("changes %v", got)
+	}
+	if c := changes[0]; c.Old.(*httpx.Config).Port != 8081 || c.New != nil {
+		t.Fatalf("delete %+v", c)
+	}
+	if c := changes[1]; c.Old.(*httpx.Config).Port != 8080 || c.New.(*httpx.Config).Port != 9090 {
+		t.Fatalf("update %+v", c)
+	}
+	if c := changes[2]; c.Old != nil || c.New.(*httpx.Config).Port != 8083 {
+		t.Fatalf("add %+v", c)
+	}
+}
+
+func TestReload(t *testing.T) {
+	resetSubscribers(t)
+	src := &memSource{cfg: map[string]any{"http": map[string]any{"def": map[string]any{"port": 8081}}}}
+	if err := ReadSource(src); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	OnChangeOf(SectionHttp, func(key string, old, new *httpx.Config) error {
+		switch {
+		case old == nil:
+			got = append(got, "add "+key)
+		case new == nil:
+			got = append(got, "delete "+key)
+		default:
+			if old.Port != 8081 || new.Port != 8082 {
+				t.Errorf("update %d -> %d", old.Port, new.Port)
+			}
+			got = append(got, "update "+key)
+		}
+		return nil
+	})
+	errApply := errors.New("apply fail")
+	OnChange(SectionDbs, func(c Change) error { return errApply })
+	OnChange(SectionLogs, func(c Change) error { return ErrRestartRequired })
+
+	src.set(map[string]any{
+		"http": map[string]any{
+			"def":   map[string]any{"port": 8082},
+			"admin": map[string]any{"port": 8083},
+		},
+		"logs": map[string]any{"def": map[string]any{"level": "debug"}},
+		"dbs":  map[string]any{"def": map[string]any{"dbname": "test"}},
+	})
+	// 订阅者的错误返回 需要重启的修改不返回错误
+	if err := Reload(); !errors.Is(err, errApply) || errors.Is(err, ErrRestartRequired) {
+		t.Fatalf("reload %v", err)
+	}
+	if !slices.Equal(got, []string{"add admin", "update def"}) {
+		t.Fatalf("http changes %v", got)
+	}
+	if GetHttp()["def"].Port != 8082 || GetDbs()["def"].DbName != "test" {
+		t.Fatal("config not updated")
+	}
+
+	// 校验失败时保留当前配置 不通知
+	got = nil
+	src.set(map[string]any{"http": map[string]any{"def": map[string]any{"port": 70000}}})
+	if err := Reload(); err == nil {
+		t.Fatal("expected invalid config")
+	}
+	if len(got) != 0 || GetHttp()["def"].Port != 8082 {
+		t.Fatalf("invalid reload changes %v port %d", got, GetHttp()["def"].Port)
+	}
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/go-viper/mapstructure/v2"
+)
+
+// 配置中的密钥引用在解析配置时替换为实际的值 格式: ${提供者:引用}
+// 例: pass: ${file:/run/secrets/db_pass}
+// 例: password: ${env:REDIS_PASS}
+// 例: url: mongodb://root:${env:MONGO_PASS}@127.0.0.1:27017
+// 例: secret: ${enc:base64(nonce+密文)} AES-GCM加密 密钥为环境变量TOOL_SECRET_KEY(base64编码 16/24/32字节)
+//
+// 内置提供者: file env enc, 可通过RegisterSecretProvider添加 如vault
+
+// SecretProvider 密钥提供者 ref为引用中冒号后的内容
+type SecretProvider interface {
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// SecretProviderFunc 函数形式的密钥提供者
+type SecretProviderFunc func(ctx context.Context, ref string) (string, error)
+
+func (f SecretProviderFunc) Resolve(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+var (
+	secretMu        sync.RWMutex
+	secretProviders = map[string]SecretProvider{
+		"file": SecretProviderFunc(fileSecret),
+		"env":  SecretProviderFunc(envSecret),
+		"enc":  SecretProviderFunc(encSecret),
+	}
+	secretRef = regexp.MustCompile(`\$\{([a-zA-Z][a-zA-Z0-9_-]*):([^}]*)\}`)
+)
+
+// RegisterSecretProvider 注册密钥提供者 名称相同时覆盖 需在config.Read之前调用
+func RegisterSecretProvider(name string, p SecretProvider) {
+	secretMu.Lock()
+	defer secretMu.Unlock()
+	secretProviders[name] = p
+}
+
+// ResolveSecrets 替换s中所有的密钥引用
+func ResolveSecrets(ctx context.Context, s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var errs []error
+	out := secretRef.ReplaceAllStringFunc(s, func(m string) string {
+		sub := secretRef.FindStringSubmatch(m)
+		secretMu.RLock()
+		p, is := secretProviders[sub[1]]
+		secretMu.RUnlock()
+		if !is {
+			errs = append(errs, fmt.Errorf("secret provider %s not found", sub[1]))
+			return m
+		}
+		val, err := p.Resolve(ctx, sub[2])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("secret %s:%s resolve failed: %v", sub[1], sub[2], err))
+			return m
+		}
+		return val
+	})
+	if len(errs) != 0 {
+		return "", errors.Join(errs...)
+	}
+	return out, nil
+}
+
+// secretHook 解析配置时替换字符串中的密钥引用
+func secretHook() mapstructure.DecodeHookFuncKind {
+	return func(f, t reflect.Kind, data any) (any, error) {
+		if f != reflect.String {
+			return data, nil
+		}
+		return ResolveSecrets(context.Background(), data.(string))
+	}
+}
+
+func fileSecret(_ context.Context, ref string) (string, error) {
+	b, err := os.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+func envSecret(_ context.Context, ref string) (string, error) {
+	val, is := os.LookupEnv(ref)
+	if !is {
+		return "", fmt.Errorf("env %s not set", ref)
+	}
+	return val, nil
+}
+
+func encSecret(_ context.Context, ref string) (string, error) {
+	key, err := secretKey()
+	if err != nil {
+		return "", err
+	}
+	return DecryptSecret(key, ref)
+}
+
+// secretKey 从环境变量读取enc使用的密钥
+func secretKey() ([]byte, error) {
+	name := envPrefix + "_SECRET_KEY"
+	val := os.Getenv(name)
+	if len(val) == 0 {
+		return nil, fmt.Errorf("env %s not set", name)
+	}
+	key, err := base64.StdEncoding.DecodeString(val)
+	if err != nil {
+		return nil, fmt.Errorf("env %s must be base64 encoded: %v", name, err)
+	}
+	return key, nil
+}
+
+// EncryptSecret 使用AES-GCM加密 返回base64(nonce+密文) 配置中写为${enc:返回值}
+func EncryptSecret(key []byte, plaintext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(plaintext), nil)), nil
+}
+
+// DecryptSecret 解密EncryptSecret的结果
+func DecryptSecret(key []byte, ciphertext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	b, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	if len(b) < gcm.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+	plain, err := gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
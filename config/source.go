@@ -0,0 +1,177 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	stdlog "log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/wjoj/tool/v2/log"
+)
+
+// ConfigSource 配置来源 如本地文件 redis http
+type ConfigSource interface {
+	Name() string                                     //名称 用于日志
+	Load(ctx context.Context) (map[string]any, error) //读取完整的配置
+	Watch(ctx context.Context, changed func()) error  //配置修改时调用changed 阻塞直到ctx结束
+}
+
+type sourceOptions struct {
+	cacheFile string
+	timeout   time.Duration
+}
+
+type SourceOption func(o *sourceOptions)
+
+// WithSourceCacheOption 保存最后一次成功读取的配置到文件 来源不可用时使用该文件
+func WithSourceCacheOption(file string) SourceOption {
+	return func(o *sourceOptions) {
+		o.cacheFile = file
+	}
+}
+
+// WithSourceTimeoutOption 读取配置的超时时间 默认10s
+func WithSourceTimeoutOption(timeout time.Duration) SourceOption {
+	return func(o *sourceOptions) {
+		o.timeout = timeout
+	}
+}
+
+var (
+	sourceMu   sync.Mutex
+	source     ConfigSource
+	sourceOpt  sourceOptions
+	stopSource context.CancelFunc
+)
+
+// ReadSource 从配置来源读取配置 并监听修改
+func ReadSource(src ConfigSource, opts ...SourceOption) error {
+	tagName = "yaml"
+	return readSource(src, opts...)
+}
+
+func readSource(src ConfigSource, opts ...SourceOption) error {
+	o := sourceOptions{timeout: 10 * time.Second}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&o)
+		}
+	}
+	sourceMu.Lock()
+	if stopSource != nil {
+		stopSource()
+	}
+	source, sourceOpt = src, o
+	sourceMu.Unlock()
+
+	cached, err := load()
+	if err != nil {
+		return err
+	}
+	if cached != nil { // 日志还未初始化
+		stdlog.Printf("config source %s unavailable, using cache %s: %v", src.Name(), o.cacheFile, cached)
+	}
+	cfg, err := unmarshal()
+	if err != nil {
+		return err
+	}
+	if err := validate(cfg); err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+	set(cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sourceMu.Lock()
+	stopSource = cancel
+	sourceMu.Unlock()
+	go func() {
+		err := src.Watch(ctx, func() {
+			log.Infof("config source %s changed", src.Name())
+			if err := Reload(); err != nil {
+				log.Errorf("config reload error: %v", err)
+			}
+		})
+		if err != nil {
+			log.Errorf("config source %s watch error: %v", src.Name(), err)
+		}
+	}()
+	return nil
+}
+
+// load 从配置来源读取配置到viper 再应用环境变量和命令行参数
+// 来源不可用但使用了缓存时返回来源的错误cached
+func load() (cached error, err error) {
+	sourceMu.Lock()
+	src, o := source, sourceOpt
+	sourceMu.Unlock()
+	if src == nil {
+		return nil, errors.New("config source not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+	cfg, err := src.Load(ctx)
+	if err != nil {
+		if len(o.cacheFile) == 0 {
+			return nil, err
+		}
+		var cerr error
+		if cfg, cerr = readCache(o.cacheFile); cerr != nil {
+			return nil, errors.Join(err, cerr)
+		}
+		cached = err
+	} else if len(o.cacheFile) != 0 {
+		if err := writeCache(o.cacheFile, cfg); err != nil {
+			return nil, err
+		}
+	}
+	// 清空viper中的配置
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(strings.NewReader("")); err != nil {
+		return nil, err
+	}
+	if err := viper.MergeConfigMap(cfg); err != nil {
+		return nil, fmt.Errorf("merge config %s failed: %v", src.Name(), err)
+	}
+	if err := applyOverrides(); err != nil {
+		return nil, err
+	}
+	return cached, nil
+}
+
+func readCache(file string) (map[string]any, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read config cache failed: %v", err)
+	}
+	var cfg map[string]any
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("read config cache failed: %v", err)
+	}
+	return cfg, nil
+}
+
+// writeCache 先写临时文件再替换 避免进程中断时缓存损坏
+func writeCache(file string, cfg map[string]any) error {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("write config cache failed: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return fmt.Errorf("write config cache failed: %v", err)
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("write config cache failed: %v", err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return fmt.Errorf("write config cache failed: %v", err)
+	}
+	return nil
+}
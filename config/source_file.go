@@ -0,0 +1,219 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// 配置文件的合并顺序(后者覆盖前者):
+//
+//	基础配置引入的文件 < 基础配置(config.yaml) < 环境配置引入的文件 < 环境配置(config.prod.yaml)
+//
+// 环境配置与基础配置在同一目录 文件名为: 基础配置名.环境.扩展名, 不存在时忽略
+// include 引入其他配置文件 路径相对于当前文件所在目录, 可为字符串或列表, 引入的文件中也可以使用include
+// 例:
+//
+//	include:
+//	  - dbs.yaml
+//	  - rediss.yaml
+
+const includeKey = "include"
+
+// FileSource 本地配置文件
+type FileSource struct {
+	Path string //基础配置文件路径
+
+	mu    sync.Mutex
+	files map[string]struct{} //基础配置 环境配置和引入的文件
+}
+
+func (s *FileSource) Name() string {
+	return s.Path
+}
+
+// Load 读取基础配置 合并引入的文件和环境配置
+func (s *FileSource) Load(ctx context.Context) (map[string]any, error) {
+	files := map[string]struct{}{}
+	cfg, err := loadFile(s.Path, files, nil)
+	if err != nil {
+		return nil, err
+	}
+	ext := filepath.Ext(s.Path)
+	overlay := strings.TrimSuffix(s.Path, ext) + "." + envOf(cfg) + ext
+	if _, err := os.Stat(overlay); err == nil {
+		over, err := loadFile(overlay, files, nil)
+		if err != nil {
+			return nil, err
+		}
+		mergeMap(cfg, over)
+	}
+	s.mu.Lock()
+	s.files = files
+	s.mu.Unlock()
+	return cfg, nil
+}
+
+// Watch 监听读取过的所有文件 兼容k8s configmap的符号链接替换
+func (s *FileSource) Watch(ctx context.Context, changed func()) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := s.watchDirs(w); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if e.Has(fsnotify.Chmod) {
+				continue
+			}
+			s.mu.Lock()
+			_, is := s.files[filepath.Clean(e.Name)]
+			s.mu.Unlock()
+			if !is && filepath.Base(e.Name) != "..data" {
+				continue
+			}
+			changed()
+			// 重新加载后引入的文件可能变化
+			if err := s.watchDirs(w); err != nil {
+				return err
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (s *FileSource) watchDirs(w *fsnotify.Watcher) error {
+	s.mu.Lock()
+	dirs := []string{filepath.Dir(filepath.Clean(s.Path))}
+	for file := range s.files {
+		dirs = append(dirs, filepath.Dir(file))
+	}
+	s.mu.Unlock()
+	watched := w.WatchList()
+	for _, dir := range dirs {
+		if slices.Contains(watched, dir) {
+			continue
+		}
+		if err := w.Add(dir); err != nil {
+			return fmt.Errorf("config watch %s failed: %v", dir, err)
+		}
+		watched = append(watched, dir)
+	}
+	return nil
+}
+
+// loadFile 读取配置文件 先合并include引入的文件 再用文件本身的配置覆盖
+// files 记录读取过的文件 stack 为当前引入链 用于检测循环引入
+func loadFile(file string, files map[string]struct{}, stack []string) (map[string]any, error) {
+	file = filepath.Clean(file)
+	for _, f := range stack {
+		if f == file {
+			return nil, fmt.Errorf("config include cycle: %s -> %s", strings.Join(stack, " -> "), file)
+		}
+	}
+	stack = append(stack, file)
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read config file %s failed: %v", file, err)
+	}
+	files[file] = struct{}{}
+	settings, err := parseConfig(b, configType(file))
+	if err != nil {
+		return nil, fmt.Errorf("read config file %s failed: %v", file, err)
+	}
+	includes, err := includePaths(settings[includeKey])
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %v", file, err)
+	}
+	delete(settings, includeKey)
+	merged := map[string]any{}
+	for _, inc := range includes {
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(filepath.Dir(file), inc)
+		}
+		m, err := loadFile(inc, files, stack)
+		if err != nil {
+			return nil, err
+		}
+		mergeMap(merged, m)
+	}
+	mergeMap(merged, settings)
+	return merged, nil
+}
+
+// parseConfig 解析配置内容 key均为小写
+func parseConfig(b []byte, typ string) (map[string]any, error) {
+	if len(typ) == 0 {
+		typ = "yaml"
+	}
+	v := viper.New()
+	v.SetConfigType(typ)
+	if err := v.ReadConfig(bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+	return v.AllSettings(), nil
+}
+
+func includePaths(val any) ([]string, error) {
+	switch val := val.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{val}, nil
+	case []any:
+		paths := make([]string, 0, len(val))
+		for _, v := range val {
+			path, is := v.(string)
+			if !is {
+				return nil, fmt.Errorf("include must be a string or a list of strings, got %T", v)
+			}
+			paths = append(paths, path)
+		}
+		return paths, nil
+	case []string:
+		return val, nil
+	}
+	return nil, fmt.Errorf("include must be a string or a list of strings, got %T", val)
+}
+
+// mergeMap 将src深度合并到dst 相同key的非map值由src覆盖
+func mergeMap(dst, src map[string]any) {
+	for key, sv := range src {
+		sm, sIs := sv.(map[string]any)
+		dm, dIs := dst[key].(map[string]any)
+		if sIs && dIs {
+			mergeMap(dm, sm)
+			continue
+		}
+		dst[key] = sv
+	}
+}
+
+func configType(file string) string {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(file), "."))
+	if ext == "yml" {
+		ext = "yaml"
+	}
+	return ext
+}
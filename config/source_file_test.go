@@ -0,0 +1,125 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// 合并顺序: 基础配置引入的文件 < 基础配置 < 环境配置引入的文件 < 环境配置
+func TestFileSourceOverlay(t *testing.T) {
+	t.Setenv("ENV", "")
+	t.Setenv("TOOL_ENV", "")
+	dir := writeFiles(t, map[string]string{
+		"base.yaml":        "x:\n  a: 1\n  b: 1\n",
+		"config.yaml":      "include: base.yaml\nenv: prod\nx:\n  b: 2\n  c: 2\n",
+		"prod-inc.yaml":    "x:\n  c: 3\n  d: 3\n",
+		"config.prod.yaml": "include:\n  - prod-inc.yaml\nx:\n  d: 4\n",
+		"config.test.yaml": "x:\n  a: test\n",
+	})
+	src := &FileSource{Path: filepath.Join(dir, "config.yaml")}
+	cfg, err := src.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := cfg["x"].(map[string]any)
+	if x["a"] != 1 || x["b"] != 2 || x["c"] != 3 || x["d"] != 4 {
+		t.Fatalf("merged %v", x)
+	}
+	if _, is := cfg[includeKey]; is {
+		t.Fatal("include should be removed")
+	}
+	if len(src.files) != 4 {
+		t.Fatalf("files %v", src.files)
+	}
+
+	// 环境变量选择环境配置 不存在时忽略
+	t.Setenv("TOOL_ENV", "test")
+	cfg, err = src.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := cfg["x"].(map[string]any); x["a"] != "test" || x["d"] != nil {
+		t.Fatalf("test env %v", x)
+	}
+	t.Setenv("TOOL_ENV", "staging")
+	cfg, err = src.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := cfg["x"].(map[string]any); x["b"] != 2 || x["c"] != 2 {
+		t.Fatalf("missing env %v", x)
+	}
+}
+
+func TestFileSourceInclude(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{
+			name: "diamond",
+			files: map[string]string{
+				"config.yaml": "include: [a.yaml, b.yaml]\n",
+				"a.yaml":      "include: common.yaml\n",
+				"b.yaml":      "include: common.yaml\n",
+				"common.yaml": "x: 1\n",
+			},
+		},
+		{
+			name: "cycle",
+			files: map[string]string{
+				"config.yaml": "include: a.yaml\n",
+				"a.yaml":      "include: b.yaml\n",
+				"b.yaml":      "include: a.yaml\n",
+			},
+			err: "config include cycle",
+		},
+		{
+			name:  "self",
+			files: map[string]string{"config.yaml": "include: config.yaml\n"},
+			err:   "config include cycle",
+		},
+		{
+			name:  "missing",
+			files: map[string]string{"config.yaml": "include: none.yaml\n"},
+			err:   "none.yaml",
+		},
+		{
+			name:  "invalid",
+			files: map[string]string{"config.yaml": "include: [1]\n"},
+			err:   "include must be a string",
+		},
+	}
+	t.Setenv("ENV", "")
+	t.Setenv("TOOL_ENV", "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeFiles(t, tt.files)
+			_, err := (&FileSource{Path: filepath.Join(dir, "config.yaml")}).Load(context.Background())
+			if len(tt.err) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("load %v", err)
+			}
+		})
+	}
+}
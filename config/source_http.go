@@ -0,0 +1,141 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	nethttp "net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPSource 从http接口读取配置 使用ETag判断是否修改
+type HTTPSource struct {
+	URL      string
+	Header   nethttp.Header
+	Client   *nethttp.Client //默认nethttp.DefaultClient
+	Type     string          //内容格式 默认按Content-Type判断 无法判断时为yaml
+	Interval time.Duration   //轮询间隔 默认30s
+
+	mu   sync.Mutex
+	etag string
+	last map[string]any
+}
+
+func (s *HTTPSource) Name() string {
+	return s.URL
+}
+
+func (s *HTTPSource) Load(ctx context.Context) (map[string]any, error) {
+	cfg, _, err := s.fetch(ctx)
+	return cfg, err
+}
+
+// fetch 读取配置 未修改(304)时返回上次的配置和false
+func (s *HTTPSource) fetch(ctx context.Context) (map[string]any, bool, error) {
+	req, err := nethttp.NewRequestWithContext(ctx, nethttp.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	for key, vals := range s.Header {
+		for _, val := range vals {
+			req.Header.Add(key, val)
+		}
+	}
+	s.mu.Lock()
+	if len(s.etag) != 0 && s.last != nil {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	s.mu.Unlock()
+	cli := s.Client
+	if cli == nil {
+		cli = nethttp.DefaultClient
+	}
+	resp, err := cli.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == nethttp.StatusNotModified {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return copyMap(s.last), false, nil
+	}
+	if resp.StatusCode != nethttp.StatusOK {
+		return nil, false, fmt.Errorf("config source %s: unexpected status %s", s.URL, resp.Status)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	typ := s.Type
+	if len(typ) == 0 {
+		typ = contentType(resp.Header.Get("Content-Type"))
+	}
+	cfg, err := parseConfig(b, typ)
+	if err != nil {
+		return nil, false, fmt.Errorf("config source %s: %v", s.URL, err)
+	}
+	s.mu.Lock()
+	s.etag = resp.Header.Get("ETag")
+	s.last = copyMap(cfg)
+	s.mu.Unlock()
+	return cfg, true, nil
+}
+
+func (s *HTTPSource) Watch(ctx context.Context, changed func()) error {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			s.mu.Lock()
+			last := s.last
+			s.mu.Unlock()
+			cfg, modified, err := s.fetch(ctx)
+			if err != nil || !modified {
+				continue
+			}
+			// 没有ETag时比较内容
+			if last != nil && reflect.DeepEqual(cfg, last) {
+				continue
+			}
+			changed()
+		}
+	}
+}
+
+func contentType(header string) string {
+	mt, _, _ := mime.ParseMediaType(header)
+	switch {
+	case strings.HasSuffix(mt, "json"):
+		return "json"
+	case strings.HasSuffix(mt, "toml"):
+		return "toml"
+	}
+	return "yaml"
+}
+
+// copyMap 深度复制配置 避免合并时修改缓存的配置
+func copyMap(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]any, len(m))
+	for key, val := range m {
+		if sub, is := val.(map[string]any); is {
+			val = copyMap(sub)
+		}
+		out[key] = val
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisSource 从redis读取配置
+// Hash为false时key的值为完整的配置, 为true时key为hash 每个field为一个配置片段 按field名称排序后合并
+// Interval为0时使用键空间通知监听修改 需要redis开启 notify-keyspace-events (如 KA)
+type RedisSource struct {
+	Client   redis.UniversalClient
+	Key      string
+	Hash     bool
+	Type     string        //内容格式 默认yaml
+	DB       int           //键空间通知使用的db
+	Interval time.Duration //轮询间隔 大于0时使用轮询
+}
+
+func (s *RedisSource) Name() string {
+	return "redis:" + s.Key
+}
+
+func (s *RedisSource) Load(ctx context.Context) (map[string]any, error) {
+	if !s.Hash {
+		b, err := s.Client.Get(ctx, s.Key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("config key %s not found", s.Key)
+		} else if err != nil {
+			return nil, err
+		}
+		return parseConfig(b, s.Type)
+	}
+	fields, err := s.Client.HGetAll(ctx, s.Key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("config key %s not found", s.Key)
+	}
+	cfg := map[string]any{}
+	for _, field := range sortedKeys(fields) {
+		m, err := parseConfig([]byte(fields[field]), s.Type)
+		if err != nil {
+			return nil, fmt.Errorf("config %s field %s: %v", s.Key, field, err)
+		}
+		mergeMap(cfg, m)
+	}
+	return cfg, nil
+}
+
+func (s *RedisSource) Watch(ctx context.Context, changed func()) error {
+	if s.Interval > 0 {
+		return pollWatch(ctx, s.Interval, s.Load, changed)
+	}
+	sub := s.Client.Subscribe(ctx, fmt.Sprintf("__keyspace@%d__:%s", s.DB, s.Key))
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			changed()
+		}
+	}
+}
+
+// pollWatch 定时读取配置 与上次不同时调用changed
+func pollWatch(ctx context.Context, interval time.Duration, load func(ctx context.Context) (map[string]any, error), changed func()) error {
+	last, _ := load(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			cfg, err := load(ctx)
+			if err != nil || reflect.DeepEqual(cfg, last) {
+				continue
+			}
+			last = cfg
+			changed()
+		}
+	}
+}
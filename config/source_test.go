@@ -0,0 +1,242 @@
+package config
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/wjoj/tool/v2/log"
+)
+
+func TestMain(m *testing.M) {
+	log.NewGlobal(log.Config{Level: "info"})
+	os.Exit(m.Run())
+}
+
+// fakeRedis 支持GET SET HSET HGETALL SUBSCRIBE的redis替身 修改时发送键空间通知
+type fakeRedis struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	strs map[string]string
+	hash map[string]map[string]string
+	subs map[string][]net.Conn
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{
+		ln:   ln,
+		strs: map[string]string{},
+		hash: map[string]map[string]string{},
+		subs: map[string][]net.Conn{},
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(rd)
+		if err != nil {
+			return
+		}
+		r.mu.Lock()
+		switch strings.ToUpper(args[0]) {
+		case "GET":
+			if val, is := r.strs[args[1]]; is {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(val), val)
+			} else {
+				io.WriteString(conn, "$-1\r\n")
+			}
+		case "SET":
+			r.strs[args[1]] = args[2]
+			io.WriteString(conn, "+OK\r\n")
+			r.notify(args[1], "set")
+		case "HSET":
+			if r.hash[args[1]] == nil {
+				r.hash[args[1]] = map[string]string{}
+			}
+			for i := 2; i+1 < len(args); i += 2 {
+				r.hash[args[1]][args[i]] = args[i+1]
+			}
+			io.WriteString(conn, ":1\r\n")
+			r.notify(args[1], "hset")
+		case "HGETALL":
+			h := r.hash[args[1]]
+			fmt.Fprintf(conn, "*%d\r\n", len(h)*2)
+			for field, val := range h {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n$%d\r\n%s\r\n", len(field), field, len(val), val)
+			}
+		case "SUBSCRIBE":
+			for i, ch := range args[1:] {
+				r.subs[ch] = append(r.subs[ch], conn)
+				fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:%d\r\n", len(ch), ch, i+1)
+			}
+		case "PING":
+			io.WriteString(conn, "+PONG\r\n")
+		case "HELLO":
+			io.WriteString(conn, "-ERR unknown command 'HELLO'\r\n")
+		default:
+			io.WriteString(conn, "+OK\r\n")
+		}
+		r.mu.Unlock()
+	}
+}
+
+// notify 调用方持有mu
+func (r *fakeRedis) notify(key, event string) {
+	ch := "__keyspace@0__:" + key
+	for _, conn := range r.subs[ch] {
+		fmt.Fprintf(conn, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(ch), ch, len(event), event)
+	}
+}
+
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func waitFor(t *testing.T, what string, fn func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !fn() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRedisSource(t *testing.T) {
+	fake := newFakeRedis(t)
+	cli := redis.NewClient(&redis.Options{Addr: fake.ln.Addr().String()})
+	defer cli.Close()
+	ctx := context.Background()
+
+	cli.Set(ctx, "app", "http:\n  def:\n    port: 8081\n", 0)
+	if err := ReadSource(&RedisSource{Client: cli, Key: "app"}); err != nil {
+		t.Fatal(err)
+	}
+	if port := GetHttp()["def"].Port; port != 8081 {
+		t.Fatalf("port %d", port)
+	}
+	waitFor(t, "redis subscribe", func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return len(fake.subs["__keyspace@0__:app"]) != 0
+	})
+	// 键空间通知触发重新加载
+	cli.Set(ctx, "app", "http:\n  def:\n    port: 8082\n", 0)
+	waitFor(t, "redis reload", func() bool { return GetHttp()["def"].Port == 8082 })
+
+	cli.HSet(ctx, "app:hash", "a", "http:\n  def:\n    port: 1\n    debug: true\n", "b", "http:\n  def:\n    port: 2\n")
+	cfg, err := (&RedisSource{Client: cli, Key: "app:hash", Hash: true}).Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	def := cfg["http"].(map[string]any)["def"].(map[string]any)
+	if def["port"] != 2 || def["debug"] != true {
+		t.Fatalf("hash merge %v", def)
+	}
+}
+
+func TestHTTPSourceCache(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		port    = 9001
+		etagHit int
+	)
+	srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		etag := strconv.Quote(strconv.Itoa(port))
+		if r.Header.Get("If-None-Match") == etag {
+			etagHit++
+			w.WriteHeader(nethttp.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", etag)
+		fmt.Fprintf(w, `{"http":{"def":{"port":%d}}}`, port)
+	}))
+	cache := filepath.Join(t.TempDir(), "cache", "config.json")
+
+	src := &HTTPSource{URL: srv.URL, Interval: 20 * time.Millisecond}
+	if err := ReadSource(src, WithSourceCacheOption(cache)); err != nil {
+		t.Fatal(err)
+	}
+	if p := GetHttp()["def"].Port; p != 9001 {
+		t.Fatalf("port %d", p)
+	}
+	mu.Lock()
+	port = 9002
+	mu.Unlock()
+	waitFor(t, "http reload", func() bool { return GetHttp()["def"].Port == 9002 })
+	mu.Lock()
+	hits := etagHit
+	mu.Unlock()
+	if hits == 0 {
+		t.Error("ETag not used")
+	}
+
+	// 来源不可用时使用缓存
+	srv.Close()
+	if err := ReadSource(&HTTPSource{URL: srv.URL}, WithSourceCacheOption(cache), WithSourceTimeoutOption(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if p := GetHttp()["def"].Port; p != 9002 {
+		t.Fatalf("cached port %d", p)
+	}
+	if err := ReadSource(&HTTPSource{URL: srv.URL}, WithSourceTimeoutOption(time.Second)); err == nil {
+		t.Fatal("expected error without cache")
+	}
+}
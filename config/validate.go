@@ -0,0 +1,100 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/spf13/viper"
+	"github.com/wjoj/tool/v2/utils"
+)
+
+// Validator 配置段中每个key的配置实现该接口时参与校验
+type Validator interface {
+	Validate() error
+}
+
+// Validate 校验所有配置段 返回所有错误 路径如 dbs.def.port: must be 1-65535
+func Validate(cfg *App) error {
+	var errs utils.FieldErrors
+	validateSection(&errs, SectionLogs, cfg.Logs)
+	validateSection(&errs, SectionRediss, cfg.Rediss)
+	validateSection(&errs, SectionDbs, cfg.Dbs)
+	validateSection(&errs, SectionMongos, cfg.Mongos)
+	validateSection(&errs, SectionHttp, cfg.Http)
+	validateSection(&errs, SectionCasbins, cfg.Casbins)
+	validateSection(&errs, SectionJwts, cfg.Jwts)
+	validateSection(&errs, SectionGen, cfg.Gen)
+	return errs.Err()
+}
+
+func validateSection[C any](errs *utils.FieldErrors, section SectionType, cfgs map[string]C) {
+	for _, key := range sortedKeys(cfgs) {
+		c := cfgs[key]
+		if v, is := any(&c).(Validator); is {
+			errs.Join(string(section)+"."+key, v.Validate())
+		}
+	}
+}
+
+// validate 校验viper中的未知配置和解析后的配置
+func validate(cfg *App) error {
+	var errs utils.FieldErrors
+	unknownKeys(&errs, "", viper.AllSettings(), reflect.TypeOf(App{}))
+	errs.Join("", Validate(cfg))
+	return errs.Err()
+}
+
+// unknownKeys 按配置结构检查未知的key viper中的key均为小写
+func unknownKeys(errs *utils.FieldErrors, path string, val any, ty reflect.Type) {
+	for ty.Kind() == reflect.Ptr {
+		ty = ty.Elem()
+	}
+	m, is := val.(map[string]any)
+	if !is {
+		return
+	}
+	join := func(key string) string {
+		if len(path) == 0 {
+			return key
+		}
+		return path + "." + key
+	}
+	switch ty.Kind() {
+	case reflect.Struct:
+		fields := map[string]reflect.StructField{}
+		for i := range ty.NumField() {
+			field := ty.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if tag := fieldTagName(field); len(tag) != 0 {
+				fields[strings.ToLower(tag)] = field
+			}
+		}
+		for _, key := range sortedKeys(m) {
+			field, is := fields[key]
+			if !is {
+				if len(path) == 0 && key == includeKey {
+					continue
+				}
+				errs.Add(join(key), "unknown key")
+				continue
+			}
+			unknownKeys(errs, join(fieldTagName(field)), m[key], field.Type)
+		}
+	case reflect.Map:
+		for _, key := range sortedKeys(m) {
+			unknownKeys(errs, join(key), m[key], ty.Elem())
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/wjoj/tool/v2/db/dbx"
+	"github.com/wjoj/tool/v2/httpx"
+	"github.com/wjoj/tool/v2/utils"
+)
+
+func TestValidate(t *testing.T) {
+	if err := Validate(&App{Dbs: map[string]dbx.Config{"def": {DbName: "test"}}}); err != nil {
+		t.Fatal(err)
+	}
+	err := Validate(&App{
+		Dbs: map[string]dbx.Config{
+			"def":   {Driver: dbx.DriverMySQL, Port: 70000, DbName: "test"},
+			"ok":    {DbName: "test"},
+			"other": {Driver: "oracle", User: "root", Pass: "x", DbName: "test"},
+		},
+		Http: map[string]httpx.Config{"api": {Port: -1}},
+	})
+	var errs utils.FieldErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("validate %v", err)
+	}
+	want := []string{
+		"dbs.def.user: is required",
+		"dbs.def.password: is required",
+		"dbs.def.port: must be 1-65535",
+		`dbs.other.driver: unknown driver "oracle"`,
+		"http.api.port: must be 1-65535",
+	}
+	if got := strings.Split(err.Error(), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("errors:\n%v", err)
+	}
+}
+
+func TestValidateUnknownKeys(t *testing.T) {
+	src := &memSource{cfg: map[string]any{
+		"include": "ignored.yaml",
+		"dbx":     map[string]any{"def": map[string]any{"dbname": "test"}},
+		"http": map[string]any{"def": map[string]any{
+			"prot":    8080,
+			"corsCfg": map[string]any{"allowOrigins": []any{"a.com"}, "allowOrigin": "b.com"},
+		}},
+		"dbs": map[string]any{"def": map[string]any{"dbname": "test"}},
+	}}
+	err := ReadSource(src)
+	if err == nil {
+		t.Fatal("expected unknown keys")
+	}
+	for _, want := range []string{
+		"dbx: unknown key",
+		"http.def.prot: unknown key",
+		"http.def.corsCfg.alloworigin: unknown key",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in:\n%v", want, err)
+		}
+	}
+	for _, key := range []string{"include", "allowOrigins", "dbname"} {
+		if strings.Contains(err.Error(), key+": unknown key") {
+			t.Errorf("%s should be known:\n%v", key, err)
+		}
+	}
+}
@@ -0,0 +1,516 @@
+package dbx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/wjoj/tool/v2/log"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// AuditAction 审计的操作
+type AuditAction string
+
+const (
+	AuditCreate AuditAction = "create"
+	AuditUpdate AuditAction = "update"
+	AuditDelete AuditAction = "delete"
+)
+
+// Auditable 实现的模型记录审计
+// AuditFields 返回记录的字段 字段名或列名 为空时记录所有字段
+type Auditable interface {
+	AuditFields() []string
+}
+
+// AuditChange 字段修改前后的值 创建时Before为空 删除时After为空
+type AuditChange struct {
+	Before any `json:"before,omitempty"`
+	After  any `json:"after,omitempty"`
+}
+
+// AuditLog 审计记录 默认写入audit_logs表
+type AuditLog struct {
+	ID        uint64                 `gorm:"primaryKey" json:"id"`
+	Table     string                 `gorm:"size:64;index:idx_audit_record" json:"table"`
+	RecordID  string                 `gorm:"size:128;index:idx_audit_record" json:"recordId"` //主键 多个主键用,连接
+	Action    AuditAction            `gorm:"size:16" json:"action"`
+	Actor     string                 `gorm:"size:128;index" json:"actor"`
+	Changes   map[string]AuditChange `gorm:"serializer:json" json:"changes"`
+	CreatedAt time.Time              `json:"createdAt"`
+}
+
+func (AuditLog) TableName() string {
+	return "audit_logs"
+}
+
+type auditActorKey struct{}
+
+// WithAuditActor ctx中设置审计的操作人
+func WithAuditActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, auditActorKey{}, actor)
+}
+
+// AuditActor WithAuditActor设置的操作人
+// 从jwt获取时使用WithActorAuditOption(jwt.Subject)
+func AuditActor(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	actor, _ := ctx.Value(auditActorKey{}).(string)
+	return actor
+}
+
+// AuditSink 审计记录的输出 tx为修改使用的连接 在事务中时和修改一起提交
+type AuditSink interface {
+	WriteAudit(ctx context.Context, tx *gorm.DB, logs []AuditLog) error
+}
+
+// TableAuditSink 写入数据库表 和修改在同一个事务 表需要先迁移AuditLog
+type TableAuditSink struct {
+	Table     string //表名 默认audit_logs
+	BatchSize int    //每次插入的行数 默认100
+}
+
+func (s TableAuditSink) WriteAudit(ctx context.Context, tx *gorm.DB, logs []AuditLog) error {
+	if tx == nil {
+		return errors.New("table audit sink: db is required")
+	}
+	size := s.BatchSize
+	if size <= 0 {
+		size = 100
+	}
+	tx = tx.Session(&gorm.Session{NewDB: true, SkipHooks: true, Context: ctx})
+	if len(s.Table) != 0 {
+		tx = tx.Table(s.Table)
+	}
+	return tx.CreateInBatches(logs, size).Error
+}
+
+// RedisAuditSink 写入redis stream 每条记录的data字段为json
+type RedisAuditSink struct {
+	Client redis.Cmdable //例如redisx.GetClient()
+	Stream string        //stream名称 默认audit_logs
+	MaxLen int64         //stream的近似最大长度 0不限制
+}
+
+func (s RedisAuditSink) WriteAudit(ctx context.Context, _ *gorm.DB, logs []AuditLog) error {
+	if s.Client == nil {
+		return errors.New("redis audit sink: client is required")
+	}
+	stream := s.Stream
+	if len(stream) == 0 {
+		stream = AuditLog{}.TableName()
+	}
+	pipe := s.Client.Pipeline()
+	for _, l := range logs {
+		data, err := json.Marshal(l)
+		if err != nil {
+			return err
+		}
+		pipe.XAdd(ctx, &redis.XAddArgs{Stream: stream, MaxLen: s.MaxLen, Approx: s.MaxLen > 0, Values: map[string]any{"data": data}})
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// LogAuditSink 写入日志
+type LogAuditSink struct{}
+
+func (LogAuditSink) WriteAudit(_ context.Context, _ *gorm.DB, logs []AuditLog) error {
+	for _, l := range logs {
+		log.Infow("db audit", "table", l.Table, "recordId", l.RecordID, "action", l.Action, "actor", l.Actor, "changes", l.Changes)
+	}
+	return nil
+}
+
+// BatchAuditSink 异步批量写入 用于redis和日志等不在事务中的输出
+// 达到size或每interval写入一次 写入失败只记录日志
+type BatchAuditSink struct {
+	sink     AuditSink
+	size     int
+	interval time.Duration
+	mu       sync.Mutex
+	buf      []AuditLog
+	flush    chan struct{}
+	done     chan struct{}
+	closed   bool
+}
+
+// NewBatchAuditSink size默认100 interval默认1s 需要调用Close写入剩余的记录
+func NewBatchAuditSink(sink AuditSink, size int, interval time.Duration) *BatchAuditSink {
+	if size <= 0 {
+		size = 100
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+	s := &BatchAuditSink{
+		sink:     sink,
+		size:     size,
+		interval: interval,
+		flush:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *BatchAuditSink) WriteAudit(_ context.Context, _ *gorm.DB, logs []AuditLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("batch audit sink is closed")
+	}
+	s.buf = append(s.buf, logs...)
+	if len(s.buf) >= s.size {
+		select {
+		case s.flush <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (s *BatchAuditSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case _, ok := <-s.flush:
+			if !ok {
+				s.write()
+				return
+			}
+		}
+		s.write()
+	}
+}
+
+func (s *BatchAuditSink) write() {
+	s.mu.Lock()
+	logs := s.buf
+	s.buf = nil
+	s.mu.Unlock()
+	for len(logs) != 0 {
+		n := min(len(logs), s.size)
+		if err := s.sink.WriteAudit(context.Background(), nil, logs[:n]); err != nil {
+			log.Errorf("db audit write %d logs error: %v", n, err)
+		}
+		logs = logs[n:]
+	}
+}
+
+// Close 写入剩余的记录
+func (s *BatchAuditSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.flush)
+	s.mu.Unlock()
+	<-s.done
+	return nil
+}
+
+type auditOptions struct {
+	sinks []AuditSink
+	actor func(ctx context.Context) string
+}
+
+type AuditOption func(o *auditOptions)
+
+// WithSinkAuditOption 审计记录的输出 可以设置多个 默认TableAuditSink
+func WithSinkAuditOption(sinks ...AuditSink) AuditOption {
+	return func(o *auditOptions) {
+		o.sinks = append(o.sinks, sinks...)
+	}
+}
+
+// WithActorAuditOption 从ctx获取操作人 默认AuditActor 例如jwt.Subject
+func WithActorAuditOption(actor func(ctx context.Context) string) AuditOption {
+	return func(o *auditOptions) {
+		o.actor = actor
+	}
+}
+
+// NewAuditPlugin 审计插件 db.Use(dbx.NewAuditPlugin()) 实现Auditable的模型在创建 更新 删除时记录审计
+// 更新和删除前查询修改的行 更新后按主键重新查询得到修改的字段 模型需要有主键
+// Raw和Exec的sql不记录 没有使用事务并且SkipDefaultTransaction时审计和修改不是原子的
+func NewAuditPlugin(options ...AuditOption) gorm.Plugin {
+	opt := auditOptions{actor: AuditActor}
+	for _, option := range options {
+		if option != nil {
+			option(&opt)
+		}
+	}
+	if len(opt.sinks) == 0 {
+		opt.sinks = []AuditSink{TableAuditSink{}}
+	}
+	return &auditPlugin{opt: opt}
+}
+
+const auditBeforeKey = "dbx:audit_before"
+
+type auditPlugin struct {
+	opt auditOptions
+}
+
+func (p *auditPlugin) Name() string {
+	return "dbx:audit"
+}
+
+func (p *auditPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().After("gorm:create").Before("gorm:commit_or_rollback_transaction").Register("dbx:audit_after", p.afterCreate),
+		cb.Update().After("gorm:begin_transaction").Before("gorm:update").Register("dbx:audit_before", p.before),
+		cb.Update().After("gorm:update").Before("gorm:commit_or_rollback_transaction").Register("dbx:audit_after", p.afterUpdate),
+		cb.Delete().After("gorm:begin_transaction").Before("gorm:delete").Register("dbx:audit_before", p.before),
+		cb.Delete().After("gorm:delete").Before("gorm:commit_or_rollback_transaction").Register("dbx:audit_after", p.afterDelete),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fields 模型需要审计的字段 不是Auditable时返回nil
+func (p *auditPlugin) fields(db *gorm.DB) []*schema.Field {
+	sch := db.Statement.Schema
+	if db.Error != nil || sch == nil || len(sch.PrimaryFields) == 0 {
+		return nil
+	}
+	a, is := reflect.New(sch.ModelType).Interface().(Auditable)
+	if !is {
+		return nil
+	}
+	names := a.AuditFields()
+	var fields []*schema.Field
+	for _, f := range sch.Fields {
+		if len(f.DBName) == 0 {
+			continue
+		}
+		if len(names) == 0 || slices.Contains(names, f.Name) || slices.Contains(names, f.DBName) {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// session 使用当前连接的新查询 在事务中时使用事务
+func (p *auditPlugin) session(db *gorm.DB) *gorm.DB {
+	tx := db.Session(&gorm.Session{NewDB: true, SkipHooks: true})
+	if db.Statement.Unscoped {
+		tx = tx.Unscoped()
+	}
+	return tx.Model(reflect.New(db.Statement.Schema.ModelType).Interface())
+}
+
+func (p *auditPlugin) find(tx *gorm.DB, modelType reflect.Type) (reflect.Value, error) {
+	rows := reflect.New(reflect.SliceOf(modelType))
+	if err := tx.Find(rows.Interface()).Error; err != nil {
+		return reflect.Value{}, err
+	}
+	return rows.Elem(), nil
+}
+
+// before 查询将要更新或删除的行
+func (p *auditPlugin) before(db *gorm.DB) {
+	if p.fields(db) == nil {
+		return
+	}
+	stmt := db.Statement
+	tx := p.session(db)
+	conds := false
+	if c, is := stmt.Clauses["WHERE"]; is {
+		if where, is := c.Expression.(clause.Where); is && len(where.Exprs) != 0 {
+			tx, conds = tx.Clauses(where), true
+		}
+	}
+	if rv := stmt.ReflectValue; rv.Kind() == reflect.Struct {
+		for _, f := range stmt.Schema.PrimaryFields {
+			if v, zero := f.ValueOf(stmt.Context, rv); !zero {
+				tx, conds = tx.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: f.DBName}, Value: v}), true
+			}
+		}
+	}
+	if !conds && !db.AllowGlobalUpdate {
+		// 没有条件时gorm返回ErrMissingWhereClause
+		return
+	}
+	rows, err := p.find(tx, stmt.Schema.ModelType)
+	if err != nil {
+		db.AddError(fmt.Errorf("audit: %w", err))
+		return
+	}
+	db.InstanceSet(auditBeforeKey, rows)
+}
+
+func (p *auditPlugin) afterCreate(db *gorm.DB) {
+	fields := p.fields(db)
+	if fields == nil {
+		return
+	}
+	stmt := db.Statement
+	var logs []AuditLog
+	add := func(rv reflect.Value) {
+		rv = reflect.Indirect(rv)
+		changes := map[string]AuditChange{}
+		for _, f := range fields {
+			if v, zero := f.ValueOf(stmt.Context, rv); !zero {
+				changes[f.DBName] = AuditChange{After: v}
+			}
+		}
+		logs = append(logs, p.log(db, AuditCreate, rv, changes))
+	}
+	switch rv := stmt.ReflectValue; rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := range rv.Len() {
+			add(rv.Index(i))
+		}
+	case reflect.Struct:
+		add(rv)
+	}
+	p.write(db, logs)
+}
+
+func (p *auditPlugin) afterUpdate(db *gorm.DB) {
+	fields := p.fields(db)
+	before, is := p.beforeRows(db)
+	if fields == nil || !is || before.Len() == 0 {
+		return
+	}
+	stmt := db.Statement
+	after, err := p.find(p.session(db).Where(p.primaryKeys(db, before)), stmt.Schema.ModelType)
+	if err != nil {
+		db.AddError(fmt.Errorf("audit: %w", err))
+		return
+	}
+	old := make(map[string]reflect.Value, before.Len())
+	for i := range before.Len() {
+		old[p.recordID(db, before.Index(i))] = before.Index(i)
+	}
+	var logs []AuditLog
+	for i := range after.Len() {
+		rv := after.Index(i)
+		prev, is := old[p.recordID(db, rv)]
+		if !is {
+			continue
+		}
+		changes := map[string]AuditChange{}
+		for _, f := range fields {
+			b, _ := f.ValueOf(stmt.Context, prev)
+			a, _ := f.ValueOf(stmt.Context, rv)
+			if !sameAuditValue(b, a) {
+				changes[f.DBName] = AuditChange{Before: b, After: a}
+			}
+		}
+		if len(changes) != 0 {
+			logs = append(logs, p.log(db, AuditUpdate, rv, changes))
+		}
+	}
+	p.write(db, logs)
+}
+
+func (p *auditPlugin) afterDelete(db *gorm.DB) {
+	fields := p.fields(db)
+	before, is := p.beforeRows(db)
+	if fields == nil || !is {
+		return
+	}
+	stmt := db.Statement
+	var logs []AuditLog
+	for i := range before.Len() {
+		rv := before.Index(i)
+		changes := map[string]AuditChange{}
+		for _, f := range fields {
+			if v, zero := f.ValueOf(stmt.Context, rv); !zero {
+				changes[f.DBName] = AuditChange{Before: v}
+			}
+		}
+		logs = append(logs, p.log(db, AuditDelete, rv, changes))
+	}
+	p.write(db, logs)
+}
+
+func (p *auditPlugin) beforeRows(db *gorm.DB) (reflect.Value, bool) {
+	v, is := db.InstanceGet(auditBeforeKey)
+	if !is {
+		return reflect.Value{}, false
+	}
+	rows, is := v.(reflect.Value)
+	return rows, is
+}
+
+// primaryKeys rows的主键条件
+func (p *auditPlugin) primaryKeys(db *gorm.DB, rows reflect.Value) clause.Expression {
+	stmt := db.Statement
+	ors := make([]clause.Expression, rows.Len())
+	for i := range rows.Len() {
+		var ands []clause.Expression
+		for _, f := range stmt.Schema.PrimaryFields {
+			v, _ := f.ValueOf(stmt.Context, rows.Index(i))
+			ands = append(ands, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: f.DBName}, Value: v})
+		}
+		ors[i] = clause.And(ands...)
+	}
+	return clause.Or(ors...)
+}
+
+func (p *auditPlugin) recordID(db *gorm.DB, rv reflect.Value) string {
+	stmt := db.Statement
+	ids := make([]string, len(stmt.Schema.PrimaryFields))
+	for i, f := range stmt.Schema.PrimaryFields {
+		v, _ := f.ValueOf(stmt.Context, rv)
+		ids[i] = fmt.Sprint(v)
+	}
+	return strings.Join(ids, ",")
+}
+
+func (p *auditPlugin) log(db *gorm.DB, action AuditAction, rv reflect.Value, changes map[string]AuditChange) AuditLog {
+	return AuditLog{
+		Table:     db.Statement.Schema.Table,
+		RecordID:  p.recordID(db, rv),
+		Action:    action,
+		Actor:     p.opt.actor(db.Statement.Context),
+		Changes:   changes,
+		CreatedAt: time.Now(),
+	}
+}
+
+// write 写入审计 失败时修改回滚
+func (p *auditPlugin) write(db *gorm.DB, logs []AuditLog) {
+	if db.Error != nil || len(logs) == 0 {
+		return
+	}
+	for _, sink := range p.opt.sinks {
+		if err := sink.WriteAudit(db.Statement.Context, db, logs); err != nil {
+			db.AddError(fmt.Errorf("audit: %w", err))
+			return
+		}
+	}
+}
+
+func sameAuditValue(a, b any) bool {
+	if ta, is := a.(time.Time); is {
+		tb, is := b.(time.Time)
+		return is && ta.Equal(tb)
+	}
+	return reflect.DeepEqual(a, b)
+}
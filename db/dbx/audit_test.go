@@ -0,0 +1,102 @@
+package dbx
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type auditItem struct {
+	ID       int
+	Name     string
+	Password string
+	Stock    int
+}
+
+func (auditItem) AuditFields() []string {
+	return []string{"Name", "stock"}
+}
+
+type failAuditSink struct{}
+
+func (failAuditSink) WriteAudit(context.Context, *gorm.DB, []AuditLog) error {
+	return errors.New("sink failed")
+}
+
+func TestAuditPlugin(t *testing.T) {
+	gdb := newSQLite(t)
+	if err := gdb.AutoMigrate(&auditItem{}, &AuditLog{}, &txItem{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gdb.Use(NewAuditPlugin()); err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithAuditActor(context.Background(), "u1")
+	tx := gdb.WithContext(ctx)
+	if err := tx.Create([]*auditItem{{ID: 1, Name: "a", Password: "x", Stock: 1}, {ID: 2, Name: "b"}}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Model(&auditItem{}).Where("id > ?", 0).Updates(map[string]any{"stock": 5, "password": "y"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Delete(&auditItem{ID: 2}).Error; err != nil {
+		t.Fatal(err)
+	}
+	tx.Create(&txItem{ID: 1})
+
+	var logs []AuditLog
+	gdb.Order("id").Find(&logs)
+	if len(logs) != 5 {
+		t.Fatalf("logs %+v", logs)
+	}
+	if l := logs[0]; l.Action != AuditCreate || l.RecordID != "1" || l.Actor != "u1" || len(l.Changes) != 2 || l.Changes["name"].After != "a" {
+		t.Fatalf("create %+v", l)
+	}
+	if l := logs[2]; l.Action != AuditUpdate || len(l.Changes) != 1 || l.Changes["stock"].Before != float64(1) || l.Changes["stock"].After != float64(5) {
+		t.Fatalf("update %+v", l)
+	}
+	if l := logs[4]; l.Action != AuditDelete || l.RecordID != "2" || l.Changes["name"].Before != "b" {
+		t.Fatalf("delete %+v", l)
+	}
+
+	// 审计写入失败时修改回滚
+	fdb := newSQLite(t)
+	fdb.AutoMigrate(&auditItem{})
+	fdb.Use(NewAuditPlugin(WithSinkAuditOption(failAuditSink{})))
+	if err := fdb.Create(&auditItem{ID: 1}).Error; err == nil {
+		t.Fatal("expected sink error")
+	}
+	var n int64
+	fdb.Model(&auditItem{}).Count(&n)
+	if n != 0 {
+		t.Fatalf("rows %d", n)
+	}
+}
+
+func TestAuditActorOption(t *testing.T) {
+	gdb := newSQLite(t)
+	if err := gdb.AutoMigrate(&auditItem{}, &AuditLog{}); err != nil {
+		t.Fatal(err)
+	}
+	// 例如jwt.Subject
+	actor := func(ctx context.Context) string {
+		sub, _ := ctx.Value(auditActorKey{}).(string)
+		return "jwt:" + sub
+	}
+	if err := gdb.Use(NewAuditPlugin(WithActorAuditOption(actor))); err != nil {
+		t.Fatal(err)
+	}
+	if err := gdb.WithContext(WithAuditActor(context.Background(), "u1")).Create(&auditItem{ID: 1, Name: "a"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	var l AuditLog
+	gdb.First(&l)
+	if l.Actor != "jwt:u1" {
+		t.Fatalf("actor %q", l.Actor)
+	}
+	if err := (RedisAuditSink{}).WriteAudit(context.Background(), nil, []AuditLog{l}); err == nil {
+		t.Fatal("expected missing client error")
+	}
+}
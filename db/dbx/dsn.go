@@ -0,0 +1,286 @@
+package dbx
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/wjoj/tool/v2/utils"
+)
+
+// TLSVerifyType TLS证书的校验方式
+type TLSVerifyType string
+
+const (
+	TLSVerifyFull TLSVerifyType = "full" // 校验证书和主机名 默认
+	TLSVerifyCA   TLSVerifyType = "ca"   // 只校验证书 不校验主机名
+	TLSVerifyNone TLSVerifyType = "none" // 不校验证书 只加密
+)
+
+// TLSConfig 数据库连接的TLS 文件为空时使用系统的根证书
+type TLSConfig struct {
+	CA         string        `yaml:"ca" json:"ca"`                 //CA证书文件
+	Cert       string        `yaml:"cert" json:"cert"`             //客户端证书文件
+	Key        string        `yaml:"key" json:"key"`               //客户端私钥文件
+	ServerName string        `yaml:"serverName" json:"serverName"` //校验的主机名 默认为host
+	Verify     TLSVerifyType `yaml:"verify" json:"verify"`         //full ca none 默认full
+}
+
+func (t *TLSConfig) validate(driver DriverType) error {
+	var errs utils.FieldErrors
+	switch driver {
+	case "", DriverSQLite:
+		errs.Add("", "is not supported by sqlite")
+	}
+	switch t.Verify {
+	case "", TLSVerifyFull, TLSVerifyCA, TLSVerifyNone:
+	default:
+		errs.Add("verify", fmt.Sprintf("must be %s, %s or %s", TLSVerifyFull, TLSVerifyCA, TLSVerifyNone))
+	}
+	if (len(t.Cert) == 0) != (len(t.Key) == 0) {
+		errs.Add("cert", "cert and key must be set together")
+	}
+	return errs.Err()
+}
+
+func (t *TLSConfig) verify() TLSVerifyType {
+	if len(t.Verify) == 0 {
+		return TLSVerifyFull
+	}
+	return t.Verify
+}
+
+// config 加载证书文件 生成tls.Config
+func (t *TLSConfig) config(host string) (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: host}
+	if len(t.ServerName) != 0 {
+		cfg.ServerName = t.ServerName
+	}
+	if len(t.CA) != 0 {
+		pem, err := os.ReadFile(t.CA)
+		if err != nil {
+			return nil, fmt.Errorf("tls ca: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls ca: no certificate found in %s", t.CA)
+		}
+	}
+	if len(t.Cert) != 0 {
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, fmt.Errorf("tls cert: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	switch t.verify() {
+	case TLSVerifyNone:
+		cfg.InsecureSkipVerify = true
+	case TLSVerifyCA:
+		// 跳过默认校验 自行校验证书链 不校验主机名
+		cfg.InsecureSkipVerify = true
+		roots := cfg.RootCAs
+		cfg.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+			if len(raw) == 0 {
+				return errors.New("tls: no server certificate")
+			}
+			certs := make([]*x509.Certificate, len(raw))
+			for i, b := range raw {
+				cert, err := x509.ParseCertificate(b)
+				if err != nil {
+					return err
+				}
+				certs[i] = cert
+			}
+			opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, cert := range certs[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := certs[0].Verify(opts)
+			return err
+		}
+	}
+	return cfg, nil
+}
+
+// mysqlTLSName 注册到mysql驱动的TLS配置名
+func mysqlTLSName(cfg *Config) string {
+	return fmt.Sprintf("dbx-%s-%d-%s", cfg.Host, cfg.Port, cfg.DbName)
+}
+
+var memorySeq atomic.Int64
+
+// sqlitePath sqlite的文件路径 没有扩展名时加上.db
+// :memory: 为内存数据库 每次连接创建新的数据库 连接池中的连接共享 file:开头的路径不修改
+func sqlitePath(cfg *Config) string {
+	name := cfg.DbName
+	switch {
+	case name == ":memory:":
+		// 连接池中每个连接的:memory:是不同的数据库 使用命名的共享缓存
+		name = fmt.Sprintf("file:dbx_memory_%d?mode=memory&cache=shared", memorySeq.Add(1))
+	case strings.HasPrefix(name, "file:"):
+	case len(filepath.Ext(name)) == 0:
+		name += ".db"
+	}
+	if len(cfg.Params) == 0 {
+		return name
+	}
+	sep := "?"
+	if strings.Contains(name, "?") {
+		sep = "&"
+	}
+	return name + sep + encodeParams(cfg.Params, "&", url.QueryEscape)
+}
+
+// encodeParams 按key排序拼接参数
+func encodeParams(params map[string]string, sep string, escape func(string) string) string {
+	keys := slices.Sorted(maps.Keys(params))
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = escape(k) + "=" + escape(params[k])
+	}
+	return strings.Join(pairs, sep)
+}
+
+// pgQuote postgres key=value格式的值 包含空格或引号时使用单引号
+func pgQuote(s string) string {
+	if len(s) != 0 && !strings.ContainsAny(s, ` '\`) {
+		return s
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// buildDSN 根据配置生成驱动的DSN 设置了DSN时直接使用 会补充默认的host和port
+func buildDSN(cfg *Config) (string, error) {
+	if len(cfg.DSN) != 0 {
+		return cfg.DSN, nil
+	}
+	switch cfg.Driver {
+	case "", DriverSQLite:
+		if len(cfg.DbName) == 0 {
+			return "", fmt.Errorf("数据库链接错误: 数据库链接的数据库名不能为空")
+		}
+		return sqlitePath(cfg), nil
+	}
+	if len(cfg.User) == 0 || len(cfg.Pass) == 0 {
+		return "", fmt.Errorf("数据库链接错误: 数据库链接的账号或密码不能为空")
+	}
+	if len(cfg.DbName) == 0 {
+		return "", fmt.Errorf("数据库链接错误: 数据库链接的数据库名不能为空")
+	}
+	if len(cfg.Host) == 0 {
+		cfg.Host = "127.0.0.1"
+	}
+	switch cfg.Driver {
+	case DriverMySQL:
+		if cfg.Port == 0 {
+			cfg.Port = 3306
+		}
+		if len(cfg.Charset) == 0 {
+			cfg.Charset = "utf8mb4"
+		}
+		mc := mysqldriver.NewConfig()
+		mc.User, mc.Passwd, mc.DBName = cfg.User, cfg.Pass, cfg.DbName
+		mc.Net, mc.Addr = "tcp", net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+		mc.ParseTime, mc.AllowNativePasswords = true, true
+		mc.Timeout = time.Duration(cfg.TimeOut) * time.Second
+		mc.Params = map[string]string{"charset": cfg.Charset}
+		maps.Copy(mc.Params, cfg.Params)
+		if cfg.TLS != nil {
+			mc.TLSConfig = mysqlTLSName(cfg)
+		}
+		return mc.FormatDSN(), nil
+	case DriverPostGres:
+		if cfg.Port == 0 {
+			cfg.Port = 5432
+		}
+		params := map[string]string{
+			"host":     cfg.Host,
+			"port":     strconv.Itoa(cfg.Port),
+			"user":     cfg.User,
+			"password": cfg.Pass,
+			"dbname":   cfg.DbName,
+		}
+		if cfg.TimeOut > 0 {
+			params["connect_timeout"] = strconv.Itoa(cfg.TimeOut)
+		}
+		if t := cfg.TLS; t != nil {
+			params["sslmode"] = map[TLSVerifyType]string{TLSVerifyFull: "verify-full", TLSVerifyCA: "verify-ca", TLSVerifyNone: "require"}[t.verify()]
+			for k, v := range map[string]string{"sslrootcert": t.CA, "sslcert": t.Cert, "sslkey": t.Key} {
+				if len(v) != 0 {
+					params[k] = v
+				}
+			}
+		}
+		maps.Copy(params, cfg.Params)
+		return encodeParams(params, " ", pgQuote), nil
+	case DriverMsSQL, DriverSQLServer:
+		if cfg.Port == 0 {
+			cfg.Port = 1433
+		}
+		query := url.Values{"database": {cfg.DbName}}
+		if cfg.TimeOut > 0 {
+			query.Set("connection timeout", strconv.Itoa(cfg.TimeOut))
+		}
+		if t := cfg.TLS; t != nil {
+			query.Set("encrypt", "true")
+			if t.verify() == TLSVerifyNone {
+				query.Set("TrustServerCertificate", "true")
+			}
+			if len(t.CA) != 0 {
+				query.Set("certificate", t.CA)
+			}
+			if len(t.ServerName) != 0 {
+				query.Set("hostNameInCertificate", t.ServerName)
+			}
+		}
+		for k, v := range cfg.Params {
+			query.Set(k, v)
+		}
+		u := url.URL{
+			Scheme:   "sqlserver",
+			User:     url.UserPassword(cfg.User, cfg.Pass),
+			Host:     net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+			RawQuery: query.Encode(),
+		}
+		return u.String(), nil
+	case DriverClickHouse:
+		if cfg.Port == 0 {
+			cfg.Port = 9000
+		}
+		query := url.Values{}
+		if cfg.TimeOut > 0 {
+			query.Set("read_timeout", strconv.Itoa(cfg.TimeOut))
+		}
+		if t := cfg.TLS; t != nil {
+			query.Set("secure", "true")
+			if t.verify() == TLSVerifyNone {
+				query.Set("skip_verify", "true")
+			}
+		}
+		for k, v := range cfg.Params {
+			query.Set(k, v)
+		}
+		u := url.URL{
+			Scheme:   "clickhouse",
+			User:     url.UserPassword(cfg.User, cfg.Pass),
+			Host:     net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+			Path:     "/" + cfg.DbName,
+			RawQuery: query.Encode(),
+		}
+		return u.String(), nil
+	}
+	return "", fmt.Errorf("数据库链接错误: 不支持的驱动 %s", cfg.Driver)
+}
@@ -0,0 +1,169 @@
+package dbx
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type explainOptions struct {
+	db        *gorm.DB
+	key       string
+	model     any
+	table     string
+	plan      bool
+	queryOpts []QueryConditionOption
+}
+
+type ExplainOption func(o *explainOptions)
+
+// WithDBExplainOption 使用的db 默认为key对应的db
+func WithDBExplainOption(db *gorm.DB) ExplainOption {
+	return func(o *explainOptions) {
+		o.db = db
+	}
+}
+
+// WithKeyExplainOption 使用的db的key 默认为默认key
+func WithKeyExplainOption(key string) ExplainOption {
+	return func(o *explainOptions) {
+		o.key = key
+	}
+}
+
+// WithModelExplainOption 查询的模型
+func WithModelExplainOption(model any) ExplainOption {
+	return func(o *explainOptions) {
+		o.model = model
+	}
+}
+
+// WithTableExplainOption 查询的表名 没有模型时使用
+func WithTableExplainOption(table string) ExplainOption {
+	return func(o *explainOptions) {
+		o.table = table
+	}
+}
+
+// WithPlanExplainOption 执行EXPLAIN获取执行计划 sqlserver不支持
+func WithPlanExplainOption() ExplainOption {
+	return func(o *explainOptions) {
+		o.plan = true
+	}
+}
+
+// WithQueryConditionsExplainOption QueryConditions的选项
+func WithQueryConditionsExplainOption(opts ...QueryConditionOption) ExplainOption {
+	return func(o *explainOptions) {
+		o.queryOpts = opts
+	}
+}
+
+// Explanation ExplainConditions的结果
+type Explanation struct {
+	SQL  string           //带占位符的sql
+	Vars []any            //绑定的参数
+	Text string           //参数代入后的sql 仅用于查看
+	Plan []map[string]any //EXPLAIN的结果 使用WithPlanExplainOption时返回
+}
+
+// ExplainConditions 生成QueryConditions的sql和参数 不执行查询
+// 使用WithPlanExplainOption时执行EXPLAIN 可在测试中检查过滤条件是否使用了索引
+func ExplainConditions(item any, options ...ExplainOption) (*Explanation, error) {
+	opt := explainOptions{}
+	for _, option := range options {
+		if option != nil {
+			option(&opt)
+		}
+	}
+	db := opt.db
+	switch {
+	case db != nil:
+	case len(opt.key) == 0:
+		db = Get()
+	default:
+		db = Get(opt.key)
+	}
+	tx := db.Session(&gorm.Session{DryRun: true, NewDB: true})
+	switch {
+	case opt.model != nil:
+		tx = tx.Model(opt.model)
+	case len(opt.table) != 0:
+		tx = tx.Table(opt.table)
+	default:
+		return nil, errors.New("explain conditions: model or table is required")
+	}
+	var dest []map[string]any
+	tx = tx.Scopes(QueryConditions(item, opt.queryOpts...)).Find(&dest)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	stmt := tx.Statement
+	exp := &Explanation{
+		SQL:  stmt.SQL.String(),
+		Vars: stmt.Vars,
+		Text: db.Dialector.Explain(stmt.SQL.String(), stmt.Vars...),
+	}
+	if !opt.plan {
+		return exp, nil
+	}
+	prefix := "EXPLAIN "
+	switch db.Dialector.Name() {
+	case "sqlite":
+		prefix = "EXPLAIN QUERY PLAN "
+	case "sqlserver":
+		return nil, errors.New("explain conditions: sqlserver does not support EXPLAIN")
+	}
+	rows, err := db.Raw(prefix+exp.SQL, exp.Vars...).Rows()
+	if err != nil {
+		return nil, fmt.Errorf("explain conditions: %w", err)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var plan []map[string]any
+	for rows.Next() {
+		vals := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]any, len(cols))
+		for i, col := range cols {
+			if b, is := vals[i].([]byte); is {
+				vals[i] = string(b)
+			}
+			row[col] = vals[i]
+		}
+		plan = append(plan, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	exp.Plan = plan
+	return exp, nil
+}
+
+// FullScan 执行计划中是否有全表扫描
+// sqlite为不使用索引的SCAN mysql为type=ALL postgres为Seq Scan
+func (e *Explanation) FullScan() bool {
+	for _, row := range e.Plan {
+		if detail, is := row["detail"].(string); is && strings.HasPrefix(detail, "SCAN ") && !strings.Contains(detail, " INDEX ") {
+			return true
+		}
+		if typ, is := row["type"].(string); is && typ == "ALL" {
+			return true
+		}
+		if plan, is := row["QUERY PLAN"].(string); is && strings.Contains(plan, "Seq Scan") {
+			return true
+		}
+	}
+	return false
+}
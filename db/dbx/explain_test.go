@@ -0,0 +1,67 @@
+package dbx
+
+import (
+	"testing"
+)
+
+type explainItem struct {
+	ID    int
+	Email string `gorm:"index"`
+	Name  string
+}
+
+func TestExplainConditions(t *testing.T) {
+	gdb := newSQLite(t)
+	if err := gdb.AutoMigrate(&explainItem{}); err != nil {
+		t.Fatal(err)
+	}
+	type filter struct {
+		Email string `query:"email"`
+		Name  string `query:"op=prefix"`
+	}
+	exp, err := ExplainConditions(filter{Email: "a@b.c"}, WithDBExplainOption(gdb), WithModelExplainOption(&explainItem{}), WithPlanExplainOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp.SQL != "SELECT * FROM `explain_item` WHERE email = ?" || len(exp.Vars) != 1 || exp.Vars[0] != "a@b.c" {
+		t.Fatalf("sql %q %v", exp.SQL, exp.Vars)
+	}
+	if exp.Text != `SELECT * FROM `+"`explain_item`"+` WHERE email = "a@b.c"` {
+		t.Fatalf("text %s", exp.Text)
+	}
+	if len(exp.Plan) == 0 || exp.FullScan() {
+		t.Fatalf("email should use the index %v", exp.Plan)
+	}
+
+	exp, err = ExplainConditions(&filter{Name: "a"}, WithDBExplainOption(gdb), WithTableExplainOption("explain_item"), WithPlanExplainOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exp.FullScan() {
+		t.Fatalf("name should scan the table %v", exp.Plan)
+	}
+
+	if _, err := ExplainConditions(filter{}, WithDBExplainOption(gdb)); err == nil {
+		t.Fatal("missing model should fail")
+	}
+}
+
+func TestExplainConditionsDefaultDB(t *testing.T) {
+	gdb := newSQLite(t)
+	oldDbs, oldKey := dbs, defaultKey
+	dbs, defaultKey = map[string]*DB{"explain": gdb}, "explain"
+	t.Cleanup(func() { dbs, defaultKey = oldDbs, oldKey })
+	type filter struct {
+		Email string `query:"email"`
+	}
+	exp, err := ExplainConditions(filter{Email: "a@b.c"}, WithModelExplainOption(&explainItem{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp.SQL != "SELECT * FROM `explain_item` WHERE email = ?" {
+		t.Fatalf("sql %q", exp.SQL)
+	}
+	if _, err := ExplainConditions(filter{}, WithKeyExplainOption("explain"), WithTableExplainOption("explain_item")); err != nil {
+		t.Fatal(err)
+	}
+}
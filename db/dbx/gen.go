@@ -1,6 +1,17 @@
 package dbx
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/wjoj/tool/v2/log"
 	"github.com/wjoj/tool/v2/utils"
 	"gorm.io/gen"
 	"gorm.io/gorm"
@@ -70,15 +81,86 @@ func applyGenOptions(options ...GenOption) GenOptions {
 	return opts
 }
 
+// NullableType 可为空字段的生成方式
+type NullableType string
+
+const (
+	NullableNone      NullableType = "none"      // 不生成指针
+	NullablePointer   NullableType = "pointer"   // 可为空的字段生成指针
+	NullableCoverable NullableType = "coverable" // 可为空和有默认值的字段生成指针
+)
+
+// GenConfig 配置文件gen段中每个db的生成配置 与GenDBInfo同时设置时以配置文件为准
+type GenConfig struct {
+	OutPath        string            `yaml:"outPath" json:"outPath"`               //query代码目录 默认./models/{pkgName}/dao
+	ModelPkgPath   string            `yaml:"modelPkgPath" json:"modelPkgPath"`     //model代码目录 默认./models/{pkgName}
+	PkgName        string            `yaml:"pkgName" json:"pkgName"`               //默认为db的key
+	Include        []string          `yaml:"include" json:"include"`               //生成的表 支持glob 为空时生成所有表
+	Exclude        []string          `yaml:"exclude" json:"exclude"`               //排除的表 支持glob
+	Nullable       NullableType      `yaml:"nullable" json:"nullable"`             //none pointer coverable 默认none
+	TypeMap        map[string]string `yaml:"typeMap" json:"typeMap"`               //数据库类型对应的go类型 覆盖默认的映射 如 tinyint: int8
+	Columns        map[string]string `yaml:"columns" json:"columns"`               //字段类型 key为 表.列 或 列 如 user.status: int8
+	ImportPkgPaths []string          `yaml:"importPkgPaths" json:"importPkgPaths"` //生成的代码需要导入的包
+}
+
+// Validate 校验配置 返回所有错误
+func (c *GenConfig) Validate() error {
+	var errs utils.FieldErrors
+	switch c.Nullable {
+	case "", NullableNone, NullablePointer, NullableCoverable:
+	default:
+		errs.Add("nullable", fmt.Sprintf("must be %s, %s or %s", NullableNone, NullablePointer, NullableCoverable))
+	}
+	for i, pattern := range append(slices.Clone(c.Include), c.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			field := "include"
+			if i >= len(c.Include) {
+				field, i = "exclude", i-len(c.Include)
+			}
+			errs.Add(fmt.Sprintf("%s[%d]", field, i), fmt.Sprintf("invalid glob %q", pattern))
+		}
+	}
+	return errs.Err()
+}
+
+// GenChange 生成后内容变化的文件
+type GenChange struct {
+	Path string
+	Old  []byte //新建的文件为nil
+	New  []byte //不再生成的文件为nil 如删除或排除的表
+}
+
+// Diff 文件变化的行
+func (c GenChange) Diff() string {
+	return utils.Diff(c.Path, string(c.Old), string(c.New))
+}
+
+// GenByGorm 根据数据库表生成model和query代码
 func GenByGorm(options ...GenOption) {
+	if _, err := Gen(nil, false, options...); err != nil {
+		log.Errorf("gen error: %v", err)
+	}
+}
+
+// Gen 根据数据库表生成model和query代码 返回内容变化的文件
+// cfgs为配置文件中的gen段 key为db的key; 先生成到临时目录再和现有文件比较
+// dryRun为true时不修改文件 只返回会变化的文件; 不再生成的.gen.go文件也会返回 dryRun为false时删除
+func Gen(cfgs map[string]GenConfig, dryRun bool, options ...GenOption) ([]GenChange, error) {
 	opt := applyGenOptions(options...)
 
 	keys := opt.defKey.Keys
+	if len(keys) == 0 {
+		for key := range cfgs {
+			keys = append(keys, key)
+		}
+	}
 	if len(keys) == 0 {
 		for key := range dbs {
 			keys = append(keys, key)
 		}
 	}
+	slices.Sort(keys)
+	var changes []GenChange
 	for _, key := range keys {
 		info, is := opt.infos[key]
 		if !is {
@@ -86,6 +168,10 @@ func GenByGorm(options ...GenOption) {
 				FieldNullable: false,
 			}
 		}
+		cfg := cfgs[key]
+		if len(cfg.PkgName) != 0 {
+			info.PkgName = cfg.PkgName
+		}
 		if len(info.PkgName) == 0 {
 			info.PkgName = key
 		}
@@ -95,58 +181,296 @@ func GenByGorm(options ...GenOption) {
 		} else {
 			module = module + "/" + info.PkgName
 		}
+		if len(cfg.OutPath) != 0 {
+			info.OutPath = cfg.OutPath
+		}
 		if len(info.OutPath) == 0 {
 			info.OutPath = "./models/" + info.PkgName + "/dao"
 		}
+		if len(cfg.ModelPkgPath) != 0 {
+			info.ModelPkgPath = cfg.ModelPkgPath
+		}
 		if len(info.ModelPkgPath) == 0 {
 			info.ModelPkgPath = "./models/" + info.PkgName
 		}
-		genb := gen.NewGenerator(gen.Config{
-			OutPath:           info.OutPath,
-			ModelPkgPath:      info.ModelPkgPath,
-			Mode:              gen.WithDefaultQuery | gen.WithQueryInterface,
-			FieldNullable:     info.FieldNullable,
-			FieldCoverable:    false,
-			FieldSignable:     false,
-			FieldWithIndexTag: false,
-			FieldWithTypeTag:  true,
-		})
+		if len(cfg.Nullable) != 0 {
+			info.FieldNullable = cfg.Nullable != NullableNone
+		}
+		changed, err := genTemp(key, module, info, &cfg)
+		if err != nil {
+			return nil, fmt.Errorf("gen %s: %v", key, err)
+		}
+		if !dryRun {
+			if err := applyChanges(changed); err != nil {
+				return nil, err
+			}
+		}
+		changes = append(changes, changed...)
+	}
+	return changes, nil
+}
 
-		genb.WithDataTypeMap(map[string]func(detailType gorm.ColumnType) (dataType string){
-			"tinyint":   func(detailType gorm.ColumnType) (dataType string) { return "int" },
-			"smallint":  func(detailType gorm.ColumnType) (dataType string) { return "int" },
-			"mediumint": func(detailType gorm.ColumnType) (dataType string) { return "int64" },
-			"bigint":    func(detailType gorm.ColumnType) (dataType string) { return "int64" },
-			"int":       func(detailType gorm.ColumnType) (dataType string) { return "int" },
-			"float":     func(detailType gorm.ColumnType) (dataType string) { return "float64" },
-			"json":      func(detailType gorm.ColumnType) (dataType string) { return "datatypes.JSON" }, // 自定义时间
-			"timestamp": func(detailType gorm.ColumnType) (dataType string) { return "typesx.Time" },    // 自定义时间
-			"datetime":  func(detailType gorm.ColumnType) (dataType string) { return "typesx.Time" },    // 自定义时间
-			"date":      func(detailType gorm.ColumnType) (dataType string) { return "typesx.Date" },    // 自定义时间
-			"decimal":   func(detailType gorm.ColumnType) (dataType string) { return "typesx.Decimal" }, // 金额类型全部转换为第三方库,github.com/shopspring/decimal
-		})
-		genb.WithImportPkgPath(append([]string{
-			"github.com/wjoj/tool/v2/typesx",
-			"github.com/wjoj/tool/v2/utils",
-			"github.com/shopspring/decimal",
-			"gorm.io/datatypes",
-			module,
-		}, info.ModelTypePkgPaths...)...)
-
-		genb.UseDB(Get(key))
-		genb.ApplyBasic(genb.GenerateAllTable(setModelOpts()...)...)
+// genTemp 生成到临时目录 返回和现有文件相比变化的文件 不修改现有文件
+// 临时目录中使用相同的go module 保证query代码导入model包的路径和直接生成时一致
+func genTemp(key, module string, info *GenDBInfo, cfg *GenConfig) ([]GenChange, error) {
+	outPath := info.OutPath
+	modelPath := info.ModelPkgPath
+	if !strings.Contains(modelPath, string(filepath.Separator)) {
+		// 和gen一致 不是路径时在OutPath的上级目录
+		modelPath = filepath.Join(filepath.Dir(outPath), modelPath)
+	}
+	dirs := []string{outPath, modelPath}
+	root, modPath, err := findModule(outPath)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.MkdirTemp("", "dbx-gen-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	if len(modPath) != 0 {
+		if err := os.WriteFile(filepath.Join(tmp, "go.mod"), []byte("module "+modPath+"\n"), 0o644); err != nil {
+			return nil, err
+		}
+	}
+	tmpDirs := make([]string, len(dirs))
+	for i, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(root, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("%s is outside the module %s", dir, root)
+		}
+		tmpDirs[i] = filepath.Join(tmp, rel)
+	}
+	tmpInfo := *info
+	tmpInfo.OutPath, tmpInfo.ModelPkgPath = tmpDirs[0], tmpDirs[1]
+	if err := genDB(key, module, &tmpInfo, cfg); err != nil {
+		return nil, err
+	}
+
+	existing, err := snapshot(dirs...)
+	if err != nil {
+		return nil, err
+	}
+	generated := map[string][]byte{}
+	for i, dir := range tmpDirs {
+		files, err := snapshot(dir)
+		if err != nil {
+			return nil, err
+		}
+		for path, b := range files {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return nil, err
+			}
+			generated[filepath.Join(dirs[i], rel)] = b
+		}
+	}
+	return diffSnapshot(existing, generated), nil
+}
+
+// findModule dir所在的go module的根目录和module路径 没有go.mod时根目录为文件系统根目录
+func findModule(dir string) (root, modPath string, err error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+	for d := abs; ; d = filepath.Dir(d) {
+		b, err := os.ReadFile(filepath.Join(d, "go.mod"))
+		if err == nil {
+			for _, line := range strings.Split(string(b), "\n") {
+				if path, is := strings.CutPrefix(strings.TrimSpace(line), "module "); is {
+					return d, strings.Trim(strings.TrimSpace(path), `"`), nil
+				}
+			}
+			return "", "", fmt.Errorf("%s: module path not found", filepath.Join(d, "go.mod"))
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", "", err
+		}
+		if parent := filepath.Dir(d); parent == d {
+			return d, "", nil
+		}
+	}
+}
+
+// genDB 生成一个db的代码 gen出错时会panic 转换为error
+func genDB(key, module string, info *GenDBInfo, cfg *GenConfig) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	gdb := Get(key)
+	genb := gen.NewGenerator(gen.Config{
+		OutPath:           info.OutPath,
+		ModelPkgPath:      info.ModelPkgPath,
+		Mode:              gen.WithDefaultQuery | gen.WithQueryInterface,
+		FieldNullable:     info.FieldNullable,
+		FieldCoverable:    cfg.Nullable == NullableCoverable,
+		FieldSignable:     false,
+		FieldWithIndexTag: false,
+		FieldWithTypeTag:  true,
+	})
+
+	typeMap := map[string]func(detailType gorm.ColumnType) (dataType string){
+		"tinyint":   func(detailType gorm.ColumnType) (dataType string) { return "int" },
+		"smallint":  func(detailType gorm.ColumnType) (dataType string) { return "int" },
+		"mediumint": func(detailType gorm.ColumnType) (dataType string) { return "int64" },
+		"bigint":    func(detailType gorm.ColumnType) (dataType string) { return "int64" },
+		"int":       func(detailType gorm.ColumnType) (dataType string) { return "int" },
+		"float":     func(detailType gorm.ColumnType) (dataType string) { return "float64" },
+		"json":      func(detailType gorm.ColumnType) (dataType string) { return "datatypes.JSON" }, // 自定义时间
+		"timestamp": func(detailType gorm.ColumnType) (dataType string) { return "typesx.Time" },    // 自定义时间
+		"datetime":  func(detailType gorm.ColumnType) (dataType string) { return "typesx.Time" },    // 自定义时间
+		"date":      func(detailType gorm.ColumnType) (dataType string) { return "typesx.Date" },    // 自定义时间
+		"decimal":   func(detailType gorm.ColumnType) (dataType string) { return "typesx.Decimal" }, // 金额类型全部转换为第三方库,github.com/shopspring/decimal
+	}
+	for dbType, goType := range cfg.TypeMap {
+		typeMap[strings.ToLower(dbType)] = func(gorm.ColumnType) string { return goType }
+	}
+	genb.WithDataTypeMap(typeMap)
+	genb.WithImportPkgPath(append(append([]string{
+		"github.com/wjoj/tool/v2/typesx",
+		"github.com/wjoj/tool/v2/utils",
+		"github.com/shopspring/decimal",
+		"gorm.io/datatypes",
+		module,
+	}, info.ModelTypePkgPaths...), cfg.ImportPkgPaths...)...)
+
+	genb.UseDB(gdb)
+	tables, err := gdb.Migrator().GetTables()
+	if err != nil {
+		return err
+	}
+	var models []any
+	for _, table := range tables {
+		if !matchTable(table, cfg.Include, cfg.Exclude) {
+			continue
+		}
+		opts := append(setModelOpts(), columnOpts(table, cfg.Columns)...)
+		var importPkgPaths []string
 		for _, mopt := range info.TableModelOpts {
-			gm := genb.GenerateModel(mopt.Table,
-				append(setModelOpts(),
-					mopt.ModelOpts...,
-				)...,
-			)
-			if len(mopt.ImportPkgPaths) != 0 {
-				gm.ImportPkgPaths = append(gm.ImportPkgPaths, mopt.ImportPkgPaths...)
+			if mopt.Table == table {
+				opts = append(opts, mopt.ModelOpts...)
+				importPkgPaths = append(importPkgPaths, mopt.ImportPkgPaths...)
 			}
 		}
-		genb.Execute()
+		gm := genb.GenerateModel(table, opts...)
+		if len(importPkgPaths) != 0 {
+			gm.ImportPkgPaths = append(gm.ImportPkgPaths, importPkgPaths...)
+		}
+		models = append(models, gm)
+	}
+	genb.ApplyBasic(models...)
+	genb.Execute()
+	return nil
+}
+
+// matchTable include为空时匹配所有表 exclude优先
+func matchTable(table string, include, exclude []string) bool {
+	for _, pattern := range exclude {
+		if is, _ := path.Match(pattern, table); is {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, pattern := range include {
+		if is, _ := path.Match(pattern, table); is {
+			return true
+		}
+	}
+	return false
+}
+
+// columnOpts 字段类型覆盖 表.列 优先于 列
+func columnOpts(table string, columns map[string]string) []gen.ModelOpt {
+	var opts []gen.ModelOpt
+	for key, goType := range columns {
+		if !strings.Contains(key, ".") {
+			opts = append(opts, gen.FieldType(key, goType))
+		}
+	}
+	for key, goType := range columns {
+		if t, column, is := strings.Cut(key, "."); is && t == table {
+			opts = append(opts, gen.FieldType(column, goType))
+		}
+	}
+	return opts
+}
+
+// snapshot 读取目录下所有文件的内容 目录不存在时忽略
+func snapshot(dirs ...string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			files[filepath.Clean(path)] = b
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// diffSnapshot after中内容变化的文件 和before中不再生成的.gen.go文件
+func diffSnapshot(before, after map[string][]byte) []GenChange {
+	var changes []GenChange
+	for path, b := range after {
+		old, is := before[path]
+		if is && bytes.Equal(old, b) {
+			continue
+		}
+		changes = append(changes, GenChange{Path: path, Old: old, New: b})
+	}
+	for path, old := range before {
+		if _, is := after[path]; !is && strings.HasSuffix(path, ".gen.go") {
+			changes = append(changes, GenChange{Path: path, Old: old})
+		}
+	}
+	slices.SortFunc(changes, func(a, b GenChange) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return changes
+}
+
+// applyChanges 写入变化的文件 删除不再生成的文件
+func applyChanges(changes []GenChange) error {
+	for _, c := range changes {
+		if c.New == nil {
+			if err := os.Remove(c.Path); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(c.Path), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(c.Path, c.New, 0o644); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 func setModelOpts() []gen.ModelOpt {
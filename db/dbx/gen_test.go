@@ -0,0 +1,165 @@
+package dbx
+
+import (
+	"bytes"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestMatchTable(t *testing.T) {
+	tests := []struct {
+		table            string
+		include, exclude []string
+		want             bool
+	}{
+		{"user", nil, nil, true},
+		{"user", []string{"user*"}, nil, true},
+		{"order", []string{"user*"}, nil, false},
+		{"user_log", []string{"user*"}, []string{"*_log"}, false},
+		{"user_log", nil, []string{"*_log"}, false},
+		{"schema_migrations", []string{"*"}, []string{"schema_migrations"}, false},
+	}
+	for _, tt := range tests {
+		if got := matchTable(tt.table, tt.include, tt.exclude); got != tt.want {
+			t.Errorf("matchTable(%s, %v, %v) = %v", tt.table, tt.include, tt.exclude, got)
+		}
+	}
+}
+
+func TestColumnOpts(t *testing.T) {
+	columns := map[string]string{
+		"status":       "int",
+		"user.status":  "uint8",
+		"order.amount": "decimal.Decimal",
+	}
+	if n := len(columnOpts("user", columns)); n != 2 {
+		t.Fatalf("user opts %d", n)
+	}
+	if n := len(columnOpts("order", columns)); n != 2 {
+		t.Fatalf("order opts %d", n)
+	}
+	if n := len(columnOpts("item", columns)); n != 1 {
+		t.Fatalf("item opts %d", n)
+	}
+}
+
+func TestDiffSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("dao/user.gen.go", "old")
+	write("dao/same.gen.go", "same")
+	write("dao/order.gen.go", "order")
+	write("dao/custom.go", "custom")
+	before, err := snapshot(filepath.Join(dir, "dao"), filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := map[string][]byte{
+		filepath.Join(dir, "dao/user.gen.go"):     []byte("new"),
+		filepath.Join(dir, "dao/same.gen.go"):     []byte("same"),
+		filepath.Join(dir, "dao/sub/item.gen.go"): []byte("item"),
+	}
+	// order不再生成 custom.go不是生成的文件
+	changes := diffSnapshot(before, after)
+	if len(changes) != 3 ||
+		changes[0].Path != filepath.Join(dir, "dao/order.gen.go") || string(changes[0].Old) != "order" || changes[0].New != nil ||
+		changes[1].Path != filepath.Join(dir, "dao/sub/item.gen.go") || changes[1].Old != nil ||
+		changes[2].Path != filepath.Join(dir, "dao/user.gen.go") || string(changes[2].Old) != "old" || string(changes[2].New) != "new" {
+		t.Fatalf("changes %+v", changes)
+	}
+	if err := applyChanges(changes); err != nil {
+		t.Fatal(err)
+	}
+	got, err := snapshot(filepath.Join(dir, "dao"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 || string(got[filepath.Join(dir, "dao/user.gen.go")]) != "new" || string(got[filepath.Join(dir, "dao/custom.go")]) != "custom" {
+		t.Fatalf("applied %v", got)
+	}
+	if _, is := got[filepath.Join(dir, "dao/order.gen.go")]; is {
+		t.Fatal("obsolete file should be removed")
+	}
+}
+
+func TestGenDryRun(t *testing.T) {
+	gdb := newSQLite(t)
+	if err := gdb.Exec("CREATE TABLE users (id integer primary key, name text)").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := gdb.Exec("CREATE TABLE orders (id integer primary key, amount integer)").Error; err != nil {
+		t.Fatal(err)
+	}
+	dbs["gen"] = gdb
+	t.Cleanup(func() { delete(dbs, "gen") })
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("go.mod", []byte("module example.com/app\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfgs := map[string]GenConfig{"gen": {}}
+	opts := []GenOption{WithGenModuleGenOption("example.com/app/models")}
+	dirs := []string{"models"}
+
+	// 目录不存在时dry-run不创建文件
+	changes, err := Gen(cfgs, true, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) == 0 {
+		t.Fatal("expected new files")
+	}
+	if _, err := os.Stat("models"); !os.IsNotExist(err) {
+		t.Fatalf("dry-run wrote the target %v", err)
+	}
+
+	if _, err := Gen(cfgs, false, opts...); err != nil {
+		t.Fatal(err)
+	}
+	before, err := snapshot(dirs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != len(changes) {
+		t.Fatalf("generated %d files, dry-run reported %d", len(before), len(changes))
+	}
+	if b := before[filepath.Join("models", "gen", "dao", "users.gen.go")]; !bytes.Contains(b, []byte(`"example.com/app/models/gen"`)) {
+		t.Fatalf("query imports the model package from the module\n%s", b)
+	}
+	if changes, err := Gen(cfgs, true, opts...); err != nil || len(changes) != 0 {
+		t.Fatalf("up to date changes %+v err %v", changes, err)
+	}
+
+	// 排除orders后 dry-run返回不再生成的文件 不修改现有文件
+	changes, err = Gen(map[string]GenConfig{"gen": {Exclude: []string{"orders"}}}, true, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var obsolete []string
+	for _, c := range changes {
+		if c.New == nil {
+			obsolete = append(obsolete, c.Path)
+		}
+	}
+	want := []string{filepath.Join("models", "gen", "dao", "orders.gen.go"), filepath.Join("models", "gen", "orders.gen.go")}
+	if !slices.Equal(obsolete, want) {
+		t.Fatalf("obsolete %v", obsolete)
+	}
+	after, err := snapshot(dirs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.EqualFunc(before, after, bytes.Equal) {
+		t.Fatal("dry-run modified the target")
+	}
+}
@@ -2,12 +2,18 @@ package dbx
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
 	"fmt"
 	logs "log"
+	"maps"
 	"os"
+	"reflect"
 	"time"
 
+	clickhousego "github.com/ClickHouse/clickhouse-go/v2"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/wjoj/tool/v2/health"
 	"github.com/wjoj/tool/v2/log"
 	"github.com/wjoj/tool/v2/utils"
 	"gorm.io/driver/clickhouse"
@@ -56,22 +62,104 @@ const (
 )
 
 type Config struct {
-	Driver          DriverType    `yaml:"driver" json:"driver"`
-	Host            string        `yaml:"host" json:"host"`
-	Port            int           `yaml:"port" json:"port"`
-	User            string        `yaml:"user" json:"user"`
-	Pass            string        `yaml:"password" json:"password"`
-	DbName          string        `yaml:"dbname" json:"dbname"`
-	Debug           bool          `yaml:"debug" json:"debug"`
-	Prefix          string        `yaml:"prefix" json:"prefix"`
-	Charset         string        `yaml:"charset" json:"charset"`
-	MaxIdleConns    int           `yaml:"maxIdleConns" json:"maxIdleConns"`
-	MaxOpenConns    int           `yaml:"maxOpenConns" json:"maxOpenConns"`
-	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime" json:"connMaxLifetime"`
-	ConnMaxIdleTime time.Duration `yaml:"connMaxIdleTime" json:"connMaxIdleTime"`
-	TimeOut         int           `json:"timeout" yaml:"timeout"`
-	LogLevel        LogLevelType  `yaml:"logLevel" json:"logLevel"`
-	LogName         string        `yaml:"logName" json:"logName"`
+	Driver          DriverType        `yaml:"driver" json:"driver"`
+	Host            string            `yaml:"host" json:"host"`
+	Port            int               `yaml:"port" json:"port"`
+	User            string            `yaml:"user" json:"user"`
+	Pass            string            `yaml:"password" json:"password"`
+	DbName          string            `yaml:"dbname" json:"dbname"`
+	Debug           bool              `yaml:"debug" json:"debug"`
+	Prefix          string            `yaml:"prefix" json:"prefix"`
+	Charset         string            `yaml:"charset" json:"charset"`
+	MaxIdleConns    int               `yaml:"maxIdleConns" json:"maxIdleConns"`
+	MaxOpenConns    int               `yaml:"maxOpenConns" json:"maxOpenConns"`
+	ConnMaxLifetime time.Duration     `yaml:"connMaxLifetime" json:"connMaxLifetime"`
+	ConnMaxIdleTime time.Duration     `yaml:"connMaxIdleTime" json:"connMaxIdleTime"`
+	TimeOut         int               `json:"timeout" yaml:"timeout"`
+	LogLevel        LogLevelType      `yaml:"logLevel" json:"logLevel"`
+	LogName         string            `yaml:"logName" json:"logName"`
+	DSN             string            `yaml:"dsn" json:"dsn"`       //驱动的原始DSN 设置后忽略host port user password dbname params tls
+	Params          map[string]string `yaml:"params" json:"params"` //连接的额外参数 覆盖默认的参数
+	TLS             *TLSConfig        `yaml:"tls" json:"tls"`
+
+	SlowThreshold        time.Duration `yaml:"slowThreshold" json:"slowThreshold"`               //慢查询的阈值 超过时记录Warn 默认1s 小于0时不记录
+	IgnoreRecordNotFound bool          `yaml:"ignoreRecordNotFound" json:"ignoreRecordNotFound"` //不记录ErrRecordNotFound错误
+	LogContextKeys       []string      `yaml:"logContextKeys" json:"logContextKeys"`             //从ctx中读取的日志字段 默认request_id trace_id
+	RedactColumns        []string      `yaml:"redactColumns" json:"redactColumns"`               //日志中参数脱敏的列 默认password
+
+	Replicas             []ReplicaConfig `yaml:"replicas" json:"replicas"`                         //只读副本 查询自动分配到副本 写入和事务使用主库
+	Policy               PolicyType      `yaml:"policy" json:"policy"`                             //副本的选择策略 random roundRobin 默认random
+	ReplicaCheckInterval time.Duration   `yaml:"replicaCheckInterval" json:"replicaCheckInterval"` //副本健康检查间隔 默认5s
+
+	Tenant *TenantConfig `yaml:"tenant" json:"tenant"` //多租户 column按租户列过滤 database每个租户一个数据库 Get返回的db只用于SkipTenant的操作
+}
+
+// Validate 校验配置 返回所有错误
+func (c *Config) Validate() error {
+	var errs utils.FieldErrors
+	switch c.Driver {
+	case "", DriverSQLite, DriverMySQL, DriverPostGres, DriverMsSQL, DriverSQLServer, DriverClickHouse:
+	default:
+		errs.Add("driver", fmt.Sprintf("unknown driver %q", c.Driver))
+	}
+	switch {
+	case len(c.DSN) != 0:
+	case c.Driver == "" || c.Driver == DriverSQLite:
+		if len(c.DbName) == 0 {
+			errs.Add("dbname", "is required")
+		}
+	default:
+		if len(c.User) == 0 {
+			errs.Add("user", "is required")
+		}
+		if len(c.Pass) == 0 {
+			errs.Add("password", "is required")
+		}
+		if len(c.DbName) == 0 {
+			errs.Add("dbname", "is required")
+		}
+	}
+	if c.TLS != nil {
+		errs.Join("tls", c.TLS.validate(c.Driver))
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		errs.Add("port", "must be 1-65535")
+	}
+	if c.MaxIdleConns < 0 {
+		errs.Add("maxIdleConns", "must be >= 0")
+	}
+	if c.MaxOpenConns < 0 {
+		errs.Add("maxOpenConns", "must be >= 0")
+	}
+	if c.ConnMaxLifetime < 0 {
+		errs.Add("connMaxLifetime", "must be >= 0")
+	}
+	if c.ConnMaxIdleTime < 0 {
+		errs.Add("connMaxIdleTime", "must be >= 0")
+	}
+	if c.TimeOut < 0 {
+		errs.Add("timeout", "must be >= 0")
+	}
+	switch c.LogLevel {
+	case "", LogLevelError, LogLevelWarn, LogLevelInfo, LogLevelSilent:
+	default:
+		errs.Add("logLevel", fmt.Sprintf("unknown level %q", c.LogLevel))
+	}
+	switch c.Policy {
+	case "", PolicyRandom, PolicyRoundRobin:
+	default:
+		errs.Add("policy", fmt.Sprintf("must be %s or %s", PolicyRandom, PolicyRoundRobin))
+	}
+	if c.ReplicaCheckInterval < 0 {
+		errs.Add("replicaCheckInterval", "must be >= 0")
+	}
+	for i, r := range c.Replicas {
+		errs.Join(fmt.Sprintf("replicas[%d]", i), r.validate(c))
+	}
+	if c.Tenant != nil {
+		errs.Join("tenant", c.Tenant.validate())
+	}
+	return errs.Err()
 }
 
 func New(cfg *Config) (*gorm.DB, error) {
@@ -81,87 +169,9 @@ func New(cfg *Config) (*gorm.DB, error) {
 	if len(cfg.LogName) == 0 {
 		cfg.LogName = utils.DefaultKey.DefaultKey
 	}
-	var dbDSN gorm.Dialector
-	switch cfg.Driver {
-	case DriverMySQL:
-		if len(cfg.User) == 0 || len(cfg.Pass) == 0 {
-			return nil, fmt.Errorf("数据库链接错误: 数据库链接的账号或密码不能为空")
-		}
-		if len(cfg.DbName) == 0 {
-			return nil, fmt.Errorf("数据库链接错误: 数据库链接的数据库名不能为空")
-		}
-		if len(cfg.Charset) == 0 {
-			cfg.Charset = "utf8mb4"
-		}
-		if len(cfg.Host) == 0 {
-			cfg.Host = "127.0.0.1"
-		}
-		if cfg.Port == 0 {
-			cfg.Port = 3306
-		}
-		dbDSN = mysql.New(mysql.Config{
-			DSN: fmt.Sprintf("%v:%v@tcp(%v:%v)/%v?charset=%s&parseTime=True&loc=Local&allowNativePasswords=true",
-				cfg.User,
-				cfg.Pass,
-				cfg.Host,
-				cfg.Port,
-				cfg.DbName,
-				cfg.Charset,
-			),
-		})
-	case DriverSQLite:
-		dbDSN = sqlite.Open(fmt.Sprintf("%v.db", cfg.DbName))
-	case DriverPostGres:
-		if len(cfg.User) == 0 || len(cfg.Pass) == 0 {
-			return nil, fmt.Errorf("数据库链接错误: 数据库链接的账号或密码不能为空")
-		}
-		if len(cfg.DbName) == 0 {
-			return nil, fmt.Errorf("数据库链接错误: 数据库链接的数据库名不能为空")
-		}
-		if len(cfg.Host) == 0 {
-			cfg.Host = "127.0.0.1"
-		}
-		if cfg.Port == 0 {
-			cfg.Port = 5432
-		}
-		dbDSN = postgres.Open(fmt.Sprintf("host=%s user=%s dbname=%s sslmode=disable password=%s port=%d",
-			cfg.Host, cfg.User, cfg.DbName, cfg.Pass, cfg.Port))
-	case DriverMsSQL:
-	case DriverSQLServer:
-		if len(cfg.User) == 0 || len(cfg.Pass) == 0 {
-			return nil, fmt.Errorf("数据库链接错误: 数据库链接的账号或密码不能为空")
-		}
-		if len(cfg.DbName) == 0 {
-			return nil, fmt.Errorf("数据库链接错误: 数据库链接的数据库名不能为空")
-		}
-		if len(cfg.Host) == 0 {
-			cfg.Host = "127.0.0.1"
-		}
-		if cfg.Port == 0 {
-			cfg.Port = 1433
-		}
-		dbDSN = sqlserver.Open(fmt.Sprintf("sqlserver://%v:%v@%v:%v?database=%v",
-			cfg.User, cfg.Pass, cfg.Host, cfg.Port, cfg.DbName))
-	case DriverClickHouse:
-		if len(cfg.User) == 0 || len(cfg.Pass) == 0 {
-			return nil, fmt.Errorf("数据库链接错误: 数据库链接的账号或密码不能为空")
-		}
-		if len(cfg.DbName) == 0 {
-			return nil, fmt.Errorf("数据库链接错误: 数据库链接的数据库名不能为空")
-		}
-		if len(cfg.Host) == 0 {
-			cfg.Host = "127.0.0.1"
-		}
-		if cfg.Port == 0 {
-			cfg.Port = 9000
-		}
-		dbDSN = clickhouse.Open(fmt.Sprintf("clickhouse://%s:%s@%s:%d/%s?read_timeout=%d",
-			cfg.User, cfg.Pass, cfg.Host, cfg.Port, cfg.DbName, cfg.TimeOut))
-	default:
-		if len(cfg.DbName) == 0 {
-			return nil, fmt.Errorf("数据库链接错误: 数据库链接的数据库名不能为空")
-		}
-		dbDSN = sqlite.Open(fmt.Sprintf("%v.db", cfg.DbName))
+	dbDSN, err := dialector(cfg)
+	if err != nil {
+		return nil, err
 	}
 	sch := schema.NamingStrategy{
 		SingularTable: true,
@@ -175,18 +185,22 @@ func New(cfg *Config) (*gorm.DB, error) {
 	if len(cfg.LogName) != 0 {
 		var out logger.Writer
 		if cfg.LogName == "--" {
+			slow := cfg.SlowThreshold
+			if slow == 0 {
+				slow = defaultSlowThreshold
+			}
 			out = logs.New(os.Stdout, "\r\n", logs.LstdFlags)
 			dbConfig.Logger = logger.New(
 				out, // io writer
 				logger.Config{
-					SlowThreshold:             time.Second,                    // Slow SQL threshold
+					SlowThreshold:             max(slow, 0),                   // Slow SQL threshold
 					LogLevel:                  cfg.LogLevel.GormLoggerLevel(), // Log level
-					IgnoreRecordNotFoundError: true,                           // Ignore ErrRecordNotFound error for logger
+					IgnoreRecordNotFoundError: cfg.IgnoreRecordNotFound,       // Ignore ErrRecordNotFound error for logger
 					Colorful:                  true,                           // Disable color
 				},
 			)
 		} else {
-			dbConfig.Logger = &zapLogger{log.GetLogger(cfg.LogName)}
+			dbConfig.Logger = newZapLogger(log.GetLogger(cfg.LogName), cfg)
 		}
 
 	}
@@ -201,6 +215,74 @@ func New(cfg *Config) (*gorm.DB, error) {
 	if err != nil {
 		return nil, err
 	}
+	setPool(dc, cfg)
+	if err := dc.Ping(); err != nil {
+		return nil, err
+	}
+	if len(cfg.Replicas) != 0 {
+		if err := useReplicas(db, cfg); err != nil {
+			dc.Close()
+			return nil, err
+		}
+	}
+	if cfg.Tenant != nil {
+		var plugin gorm.Plugin = &tenantGuardPlugin{}
+		if cfg.Tenant.Mode == TenantModeColumn {
+			column := cfg.Tenant.Column
+			if len(column) == 0 {
+				column = "tenant_id"
+			}
+			plugin = &tenantPlugin{column: column}
+		}
+		if err := db.Use(plugin); err != nil {
+			dc.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// dialector 根据驱动创建gorm的Dialector 会补充默认的host和port
+func dialector(cfg *Config) (gorm.Dialector, error) {
+	dsn, err := buildDSN(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var tlsCfg *tls.Config
+	if cfg.TLS != nil && len(cfg.DSN) == 0 {
+		if tlsCfg, err = cfg.TLS.config(cfg.Host); err != nil {
+			return nil, fmt.Errorf("数据库链接错误: %w", err)
+		}
+	}
+	switch cfg.Driver {
+	case DriverMySQL:
+		if tlsCfg != nil {
+			if err := mysqldriver.RegisterTLSConfig(mysqlTLSName(cfg), tlsCfg); err != nil {
+				return nil, err
+			}
+		}
+		return mysql.Open(dsn), nil
+	case DriverPostGres:
+		return postgres.Open(dsn), nil
+	case DriverMsSQL, DriverSQLServer:
+		return sqlserver.Open(dsn), nil
+	case DriverClickHouse:
+		if tlsCfg == nil {
+			return clickhouse.Open(dsn), nil
+		}
+		// DSN不支持证书文件 使用Options设置TLS
+		opts, err := clickhousego.ParseDSN(dsn)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLS = tlsCfg
+		return clickhouse.New(clickhouse.Config{Conn: clickhousego.OpenDB(opts)}), nil
+	}
+	return sqlite.Open(dsn), nil
+}
+
+// setPool 设置连接池
+func setPool(dc *sql.DB, cfg *Config) {
 	if cfg.MaxIdleConns != 0 {
 		dc.SetMaxIdleConns(cfg.MaxIdleConns)
 	}
@@ -213,10 +295,37 @@ func New(cfg *Config) (*gorm.DB, error) {
 	if cfg.ConnMaxIdleTime != 0 {
 		dc.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
 	}
-	if err := dc.Ping(); err != nil {
-		return nil, err
+}
+
+// Reload 配置修改时调用 只有连接池配置可以动态调整 其他修改需要重启
+func Reload(key string, old, new *Config) error {
+	if old == nil || new == nil {
+		return utils.ErrRestartRequired
 	}
-	return db, nil
+	cli, is := dbs[key]
+	if !is {
+		return utils.ErrRestartRequired
+	}
+	oldc, newc := *old, *new
+	oldc.MaxIdleConns, newc.MaxIdleConns = 0, 0
+	oldc.MaxOpenConns, newc.MaxOpenConns = 0, 0
+	oldc.ConnMaxLifetime, newc.ConnMaxLifetime = 0, 0
+	oldc.ConnMaxIdleTime, newc.ConnMaxIdleTime = 0, 0
+	if !reflect.DeepEqual(oldc, newc) {
+		return utils.ErrRestartRequired
+	}
+	dc, err := cli.DB()
+	if err != nil {
+		return err
+	}
+	setPool(dc, new)
+	replicaMu.Lock()
+	rs, is := replicaSets[cli]
+	replicaMu.Unlock()
+	if is {
+		rs.setPool(new)
+	}
+	return nil
 }
 
 var dbs = map[string]*DB{} //全局
@@ -228,6 +337,7 @@ func Init(cfgs map[string]Config, options ...Option) error {
 	opt := applyOptions(options...)
 	defaultKey = opt.defKey.DefaultKey
 	dbs = make(map[string]*DB)
+	resetTenants()
 	if len(opt.defKey.Keys) != 0 {
 		opt.defKey.Keys = append(opt.defKey.Keys, opt.defKey.DefaultKey)
 		for _, key := range opt.defKey.Keys {
@@ -246,6 +356,10 @@ func Init(cfgs map[string]Config, options ...Option) error {
 				return err
 			}
 			dbs[key] = cli
+			registerHealth(key, cli)
+			if cfg.Tenant != nil && cfg.Tenant.Mode == TenantModeDatabase {
+				useTenantPool(key, &cfg)
+			}
 			if key == defaultKey {
 				db = cli
 			}
@@ -260,6 +374,10 @@ func Init(cfgs map[string]Config, options ...Option) error {
 			return err
 		}
 		dbs[name] = cli
+		registerHealth(name, cli)
+		if cfg.Tenant != nil && cfg.Tenant.Mode == TenantModeDatabase {
+			useTenantPool(name, &cfg)
+		}
 		if name == defaultKey {
 			db = cli
 		}
@@ -267,6 +385,18 @@ func Init(cfgs map[string]Config, options ...Option) error {
 	log.Info("init db success")
 	return nil
 }
+
+// registerHealth 注册健康检查
+func registerHealth(key string, cli *DB) {
+	health.Register("db:"+key, func(ctx context.Context) error {
+		dc, err := cli.DB()
+		if err != nil {
+			return err
+		}
+		return dc.PingContext(ctx)
+	})
+}
+
 func InitGlobal(cfg *Config) error {
 	var err error
 	db, err = New(cfg)
@@ -287,11 +417,17 @@ func Get(key ...string) *DB {
 	return dbc
 }
 
+// All 所有初始化的db key为配置的名称 返回的map可以修改
+func All() map[string]*DB {
+	return maps.Clone(dbs)
+}
+
 // Client
 func Client() *DB {
 	return db
 }
 func Close() error {
+	closeReplicas(db)
 	dc, err := db.DB()
 	if err != nil {
 		return err
@@ -300,7 +436,10 @@ func Close() error {
 }
 
 func CloseAll() error {
-	for _, cli := range dbs {
+	for key, cli := range dbs {
+		health.Unregister("db:" + key)
+		closeReplicas(cli)
+		closeTenants(key)
 		dc, err := cli.DB()
 		if err != nil {
 			continue
@@ -512,5 +651,5 @@ func Unscoped() (tx *DB) {
 }
 
 func AutoMigrate(models ...any) (err error) {
-	return db.AutoMigrate(models...)
+	return db.WithContext(SkipTenant(context.Background())).AutoMigrate(models...)
 }
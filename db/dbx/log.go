@@ -1,58 +1,290 @@
 package dbx
 
 import (
-	// ... existing imports ...
 	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
+	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
+const (
+	defaultSlowThreshold = time.Second
+	redactedValue        = "***"
+)
+
+// 默认从ctx中读取的日志字段
+var defaultLogContextKeys = []string{"request_id", "trace_id"}
+
+// 默认脱敏的列
+var defaultRedactColumns = []string{"password"}
+
+type logFieldsKey struct{}
+
+// WithLogFields ctx中加入日志字段 gorm日志会带上这些字段
+func WithLogFields(ctx context.Context, fields ...zap.Field) context.Context {
+	old, _ := ctx.Value(logFieldsKey{}).([]zap.Field)
+	return context.WithValue(ctx, logFieldsKey{}, append(append([]zap.Field{}, old...), fields...))
+}
+
 type zapLogger struct {
-	*zap.SugaredLogger
+	log            *zap.Logger
+	level          logger.LogLevel
+	slowThreshold  time.Duration
+	ignoreNotFound bool
+	contextKeys    []string
+	redact         map[string]struct{}
 }
 
-func (l *zapLogger) LogMode(level logger.LogLevel) logger.Interface {
-	if level == logger.Silent {
-		l.SugaredLogger = l.WithOptions(zap.IncreaseLevel(zapcore.Level(zapcore.InvalidLevel)))
-	} else if level == logger.Info {
-		l.SugaredLogger = l.WithOptions(zap.IncreaseLevel(zapcore.Level(zapcore.InfoLevel)))
-	} else if level == logger.Warn {
-		l.SugaredLogger = l.WithOptions(zap.IncreaseLevel(zapcore.Level(zapcore.WarnLevel)))
-	} else if level == logger.Error {
-		l.SugaredLogger = l.WithOptions(zap.IncreaseLevel(zapcore.Level(zapcore.ErrorLevel)))
+func newZapLogger(l *zap.SugaredLogger, cfg *Config) *zapLogger {
+	zl := &zapLogger{
+		log:            l.Desugar(),
+		level:          cfg.LogLevel.GormLoggerLevel(),
+		slowThreshold:  cfg.SlowThreshold,
+		ignoreNotFound: cfg.IgnoreRecordNotFound,
+		contextKeys:    cfg.LogContextKeys,
+		redact:         map[string]struct{}{},
+	}
+	if zl.slowThreshold == 0 {
+		zl.slowThreshold = defaultSlowThreshold
+	}
+	if zl.contextKeys == nil {
+		zl.contextKeys = defaultLogContextKeys
+	}
+	columns := cfg.RedactColumns
+	if columns == nil {
+		columns = defaultRedactColumns
+	}
+	for _, column := range columns {
+		zl.redact[strings.ToLower(column)] = struct{}{}
 	}
-	return l
+	return zl
+}
+
+// LogMode 返回新的logger 不修改原来的级别
+func (l *zapLogger) LogMode(level logger.LogLevel) logger.Interface {
+	nl := *l
+	nl.level = level
+	return &nl
 }
 
 func (l *zapLogger) Info(ctx context.Context, msg string, data ...any) {
-	l.SugaredLogger.Infof(msg, data...)
+	if l.level >= logger.Info {
+		l.log.With(l.fields(ctx)...).Info(fmt.Sprintf(msg, data...))
+	}
 }
 
 func (l *zapLogger) Warn(ctx context.Context, msg string, data ...any) {
-	l.SugaredLogger.Warnf(msg, data...)
+	if l.level >= logger.Warn {
+		l.log.With(l.fields(ctx)...).Warn(fmt.Sprintf(msg, data...))
+	}
 }
 
 func (l *zapLogger) Error(ctx context.Context, msg string, data ...any) {
-	l.SugaredLogger.Errorf(msg, data...)
+	if l.level >= logger.Error {
+		l.log.With(l.fields(ctx)...).Error(fmt.Sprintf(msg, data...))
+	}
 }
 
+// Trace 出错时Error 超过SlowThreshold时Warn 其他语句在Info级别时记录为Debug
 func (l *zapLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	failed := err != nil && l.level >= logger.Error && !(l.ignoreNotFound && errors.Is(err, gorm.ErrRecordNotFound))
+	slow := l.slowThreshold > 0 && elapsed > l.slowThreshold && l.level >= logger.Warn
+	if !failed && !slow && l.level < logger.Info {
+		return
+	}
 	sql, rows := fc()
-	l.Desugar().Debug("gorm trace",
+	fields := append(l.fields(ctx),
 		zap.String("sql", sql),
 		zap.Int64("rows", rows),
-		zap.Error(err),
-		zap.Duration("duration", time.Since(begin)),
+		zap.Duration("duration", elapsed),
 	)
+	switch {
+	case failed:
+		l.log.Error("gorm error", append(fields, zap.String("caller", callerLine()), zap.Error(err))...)
+	case slow:
+		l.log.Warn("gorm slow query", append(fields, zap.String("caller", callerLine()), zap.Duration("threshold", l.slowThreshold))...)
+	default:
+		l.log.Debug("gorm trace", fields...)
+	}
+}
+
+// fields ctx中的日志字段
+func (l *zapLogger) fields(ctx context.Context) []zap.Field {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(logFieldsKey{}).([]zap.Field)
+	fields = append([]zap.Field{}, fields...)
+	for _, key := range l.contextKeys {
+		if v := ctx.Value(key); v != nil {
+			fields = append(fields, zap.Any(key, v))
+		}
+	}
+	return fields
+}
+
+// ParamsFilter 替换脱敏列的参数 实现gorm.ParamsFilter
+func (l *zapLogger) ParamsFilter(ctx context.Context, sql string, params ...any) (string, []any) {
+	if len(l.redact) == 0 || len(params) == 0 {
+		return sql, params
+	}
+	var out []any
+	for i, column := range placeholderColumns(sql, len(params)) {
+		if _, is := l.redact[strings.ToLower(column)]; !is {
+			continue
+		}
+		if out == nil {
+			out = append([]any{}, params...)
+		}
+		out[i] = redactedValue
+	}
+	if out == nil {
+		return sql, params
+	}
+	return sql, out
+}
+
+func (l *zapLogger) Printf(f string, msg ...any) {
+	l.log.Sugar().Infof(f, msg...)
+}
+
+// WithLogger 使用zap记录gorm日志
+func WithLogger(l *zap.SugaredLogger) logger.Interface {
+	return newZapLogger(l, &Config{LogLevel: LogLevelInfo})
+}
+
+var dbxSourceDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file) + string(filepath.Separator)
+}()
+
+// callerLine 调用gorm和dbx的代码位置
+func callerLine() string {
+	pcs := [16]uintptr{}
+	n := runtime.Callers(3, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		internal := strings.Contains(frame.File, "gorm.io/") ||
+			(strings.HasPrefix(frame.File, dbxSourceDir) && !strings.HasSuffix(frame.File, "_test.go"))
+		if !internal && len(frame.File) != 0 {
+			return frame.File + ":" + strconv.Itoa(frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// placeholderColumns sql中每个参数对应的列名 无法确定时为空
+// 参数使用之前最近的列名 INSERT的VALUES按列的位置对应 占位符为 ? $n @pn
+func placeholderColumns(sql string, n int) []string {
+	columns := make([]string, n)
+	var insertCols []string
+	lastIdent := ""
+	seq, depth, valuesDepth, tuple := 0, 0, -1, -1
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			j := strings.IndexByte(sql[i+1:], c)
+			if j < 0 {
+				return columns
+			}
+			if c != '\'' {
+				lastIdent = sql[i+1 : i+1+j]
+			}
+			i += j + 1
+		case c == '(':
+			depth++
+			if valuesDepth >= 0 && depth == valuesDepth+1 {
+				tuple = 0
+			} else if insertCols == nil && strings.HasPrefix(strings.ToUpper(strings.TrimSpace(sql[:i])), "INSERT INTO") {
+				insertCols = parseColumnList(sql[i+1:])
+			}
+		case c == ')':
+			depth--
+		case c == ',' && tuple >= 0 && depth == valuesDepth+1:
+			tuple++
+		case c == '?' || ((c == '$' || c == '@') && i+1 < len(sql) && (isDigit(sql[i+1]) || sql[i+1] == 'p')):
+			idx := seq
+			seq++
+			if c != '?' {
+				j := i + 1
+				if sql[j] == 'p' {
+					j++
+				}
+				k := j
+				for k < len(sql) && isDigit(sql[k]) {
+					k++
+				}
+				num, err := strconv.Atoi(sql[j:k])
+				if err != nil {
+					continue
+				}
+				idx, i = num-1, k-1
+			}
+			if idx < 0 || idx >= n {
+				continue
+			}
+			if tuple >= 0 && depth == valuesDepth+1 && tuple < len(insertCols) {
+				columns[idx] = insertCols[tuple]
+			} else {
+				columns[idx] = lastIdent
+			}
+		case isIdentChar(c):
+			j := i
+			for j < len(sql) && isIdentChar(sql[j]) {
+				j++
+			}
+			word := sql[i:j]
+			switch strings.ToUpper(word) {
+			case "VALUES":
+				if insertCols != nil {
+					valuesDepth = depth
+				}
+			case "IN", "LIKE", "NOT", "IS", "BETWEEN", "AND":
+			default:
+				if !isDigit(word[0]) {
+					lastIdent = word[strings.LastIndexByte(word, '.')+1:]
+				}
+			}
+			i = j - 1
+		}
+	}
+	return columns
+}
+
+// parseColumnList INSERT的列名 s为(之后的内容
+func parseColumnList(s string) []string {
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return []string{}
+	}
+	cols := strings.Split(s[:end], ",")
+	for i, col := range cols {
+		col = strings.TrimSpace(col)
+		cols[i] = strings.Trim(col, "`\"")
+	}
+	return cols
 }
 
-func (l *zapLogger) Printf(f string, msg ...interface{}) {
-	l.SugaredLogger.Logf(zap.InfoLevel, f, msg...)
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
 }
 
-func WithLogger(logger *zap.SugaredLogger) logger.Interface {
-	return &zapLogger{logger}
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '.' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
 }
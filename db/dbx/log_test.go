@@ -0,0 +1,94 @@
+package dbx
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type logUser struct {
+	ID       int
+	Name     string
+	Password string
+}
+
+func TestZapLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	zl := newZapLogger(zap.New(core).Sugar(), &Config{LogLevel: LogLevelInfo, SlowThreshold: time.Nanosecond, IgnoreRecordNotFound: true})
+	gdb, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "log.db")), &gorm.Config{Logger: zl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gdb.AutoMigrate(&logUser{})
+	logs.TakeAll()
+
+	ctx := WithLogFields(context.WithValue(context.Background(), "request_id", "r1"), zap.String("user", "u1"))
+	gdb.WithContext(ctx).Create(&logUser{Name: "a", Password: "secret"})
+	entries := logs.TakeAll()
+	if len(entries) != 1 || entries[0].Level != zapcore.WarnLevel || entries[0].Message != "gorm slow query" {
+		t.Fatalf("entries %+v", entries)
+	}
+	fields := entries[0].ContextMap()
+	if fields["request_id"] != "r1" || fields["user"] != "u1" {
+		t.Fatalf("fields %v", fields)
+	}
+	if sql := fields["sql"].(string); strings.Contains(sql, "secret") || !strings.Contains(sql, `"***"`) || !strings.Contains(sql, `"a"`) {
+		t.Fatalf("redact %s", sql)
+	}
+	if caller := fields["caller"].(string); !strings.Contains(caller, "log_test.go:") {
+		t.Fatalf("caller %s", caller)
+	}
+
+	// 降低级别后只记录错误 不影响原来的logger
+	quiet := gdb.Session(&gorm.Session{Logger: zl.LogMode(logger.Error)})
+	quiet.First(&logUser{}, 99)
+	quiet.Exec("SELECT * FROM missing")
+	entries = logs.TakeAll()
+	if len(entries) != 1 || entries[0].Level != zapcore.ErrorLevel {
+		t.Fatalf("quiet %+v", entries)
+	}
+	if zl.level != logger.Info {
+		t.Fatal("LogMode should not change the logger")
+	}
+	var u logUser
+	if err := gdb.Where("password = ?", "secret").First(&u).Error; err != nil {
+		t.Fatal(err)
+	}
+	if sql := logs.TakeAll()[0].ContextMap()["sql"].(string); strings.Contains(sql, "secret") {
+		t.Fatalf("redact where %s", sql)
+	}
+	if err := gdb.First(&u, 99).Error; !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatal(err)
+	}
+	if entries := logs.TakeAll(); len(entries) != 1 || entries[0].Level != zapcore.WarnLevel {
+		t.Fatalf("not found should be ignored %+v", entries)
+	}
+}
+
+func TestPlaceholderColumns(t *testing.T) {
+	tests := []struct {
+		sql  string
+		n    int
+		want string
+	}{
+		{"INSERT INTO `user` (`name`,`password`) VALUES (?,?),(?,?)", 4, "name,password,name,password"},
+		{`UPDATE "user" SET "password"=$2,"name"=$1 WHERE "user"."id" = $3`, 3, "name,password,id"},
+		{"SELECT * FROM user WHERE user.password = @p1 AND id IN (@p2,@p3) LIMIT 1", 3, "password,id,id"},
+		{"SELECT * FROM t WHERE name = 'a?' AND age BETWEEN ? AND ?", 2, "age,age"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(placeholderColumns(tt.sql, tt.n), ","); got != tt.want {
+			t.Errorf("%s\n got %s\nwant %s", tt.sql, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,550 @@
+package dbx
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wjoj/tool/v2/log"
+	"gorm.io/gorm"
+)
+
+// Migration 一个版本的迁移 Version递增 Down为nil时不能回滚
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// MigrationStatus 迁移的状态
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	Missing   bool //已执行但没有对应的迁移
+}
+
+var (
+	ErrMigrationLocked = errors.New("migration lock is held by another process")
+	ErrNoDown          = errors.New("migration has no down")
+)
+
+var (
+	migrationsMu sync.RWMutex
+	migrations   = map[string][]Migration{}
+)
+
+// RegisterMigrations 注册db的迁移 key为db的key
+func RegisterMigrations(key string, ms ...Migration) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	migrations[key] = append(migrations[key], ms...)
+}
+
+// Migrations 返回注册的所有迁移 key为db的key
+func Migrations() map[string][]Migration {
+	migrationsMu.RLock()
+	defer migrationsMu.RUnlock()
+	m := make(map[string][]Migration, len(migrations))
+	for key, ms := range migrations {
+		m[key] = slices.Clone(ms)
+	}
+	return m
+}
+
+var sqlFileRe = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// SQLMigrations 读取dir目录下的sql迁移文件 文件名为 版本_名称.up.sql 和 版本_名称.down.sql
+// 例: 20240101120000_create_users.up.sql 一个文件可包含多条以;分隔的语句
+// 引号 注释和postgres的$$ $tag$中的;不分隔 mysql和clickhouse的引号中支持\转义
+func SQLMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		match := sqlFileRe.FindStringSubmatch(e.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %v", e.Name(), err)
+		}
+		b, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, is := byVersion[version]
+		if !is {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has different names %s and %s", version, m.Name, match[2])
+		}
+		fn := execSQL(string(b))
+		if match[3] == "up" {
+			m.Up = fn
+		} else {
+			m.Down = fn
+		}
+	}
+	ms := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == nil {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		ms = append(ms, *m)
+	}
+	slices.SortFunc(ms, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return ms, nil
+}
+
+func execSQL(sql string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		// mysql和clickhouse的字符串中\为转义符
+		name := tx.Dialector.Name()
+		for _, stmt := range splitSQL(sql, name == "mysql" || name == "clickhouse") {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// splitSQL 按;分隔语句 忽略引号 注释和postgres的$$ $tag$中的;
+// backslash为true时引号中的\转义下一个字符
+func splitSQL(sql string, backslash bool) []string {
+	var (
+		stmts []string
+		sb    strings.Builder
+	)
+	flush := func() {
+		if stmt := strings.TrimSpace(sb.String()); len(stmt) != 0 {
+			stmts = append(stmts, stmt)
+		}
+		sb.Reset()
+	}
+	// skip 写入从i开始到end(包含end)的内容 找不到end时写入剩余的内容
+	skip := func(i int, end string, write bool) int {
+		n := strings.Index(sql[i+len(end):], end)
+		j := len(sql)
+		if n >= 0 {
+			j = i + len(end) + n + len(end)
+		}
+		if write {
+			sb.WriteString(sql[i:j])
+		}
+		return j
+	}
+	// quote 写入引号中的内容 backslash时跳过\转义的字符
+	quote := func(i int) int {
+		q := sql[i]
+		j := i + 1
+		for ; j < len(sql); j++ {
+			if backslash && sql[j] == '\\' && q != '`' {
+				j++
+				continue
+			}
+			if sql[j] == q {
+				j++
+				break
+			}
+		}
+		j = min(j, len(sql))
+		sb.WriteString(sql[i:j])
+		return j
+	}
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			i = quote(i)
+		case c == '$':
+			if tag := dollarTag(sql[i:]); len(tag) != 0 {
+				i = skip(i, tag, true)
+				continue
+			}
+			sb.WriteByte(c)
+			i++
+		case strings.HasPrefix(sql[i:], "--"):
+			i = skip(i, "\n", false)
+		case strings.HasPrefix(sql[i:], "/*"):
+			i = skip(i, "*/", false)
+		case c == ';':
+			flush()
+			i++
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	flush()
+	return stmts
+}
+
+// dollarTag s开头的postgres美元引号 $$或$tag$ 不是时返回空
+func dollarTag(s string) string {
+	for j := 1; j < len(s); j++ {
+		c := s[j]
+		switch {
+		case c == '$':
+			return s[:j+1]
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (j > 1 && c >= '0' && c <= '9'):
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+type migrateOptions struct {
+	table       string
+	lockTimeout time.Duration
+}
+
+type MigrateOption func(o *migrateOptions)
+
+// WithTableMigrateOption 记录迁移的表 默认schema_migrations
+func WithTableMigrateOption(table string) MigrateOption {
+	return func(o *migrateOptions) {
+		o.table = table
+	}
+}
+
+// WithLockTimeoutMigrateOption 等待迁移锁的时间 默认1分钟
+func WithLockTimeoutMigrateOption(timeout time.Duration) MigrateOption {
+	return func(o *migrateOptions) {
+		o.lockTimeout = timeout
+	}
+}
+
+// Migrator 执行一个db的迁移
+// 迁移在同一个连接上执行 执行期间持有迁移锁 只有一个实例可以执行迁移:
+// mysql GET_LOCK, postgres pg_advisory_lock, sqlserver sp_getapplock, sqlite 锁表; clickhouse 不支持锁
+// postgres sqlite sqlserver 每个迁移在事务中执行, mysql的DDL会隐式提交, clickhouse不支持事务
+type Migrator struct {
+	db         *DB
+	migrations []Migration
+	opt        migrateOptions
+}
+
+// NewMigrator 创建迁移 版本不能重复
+func NewMigrator(db *DB, ms []Migration, options ...MigrateOption) (*Migrator, error) {
+	opt := migrateOptions{
+		table:       "schema_migrations",
+		lockTimeout: time.Minute,
+	}
+	for _, option := range options {
+		if option != nil {
+			option(&opt)
+		}
+	}
+	ms = slices.Clone(ms)
+	slices.SortFunc(ms, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	for i, m := range ms {
+		if m.Up == nil {
+			return nil, fmt.Errorf("migration %d_%s has no up", m.Version, m.Name)
+		}
+		if i > 0 && ms[i-1].Version == m.Version {
+			return nil, fmt.Errorf("duplicate migration version %d", m.Version)
+		}
+	}
+	return &Migrator{db: db, migrations: ms, opt: opt}, nil
+}
+
+type schemaMigration struct {
+	Version   int64     `gorm:"column:version"`
+	Name      string    `gorm:"column:name"`
+	AppliedAt time.Time `gorm:"column:applied_at"`
+}
+
+// Status 返回所有迁移的状态 按版本排序
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var status []MigrationStatus
+	err := m.conn(ctx, func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		status = m.status(applied)
+		return nil
+	})
+	return status, err
+}
+
+// Up 执行所有未执行的迁移 返回执行的迁移
+func (m *Migrator) Up(ctx context.Context) ([]MigrationStatus, error) {
+	return m.To(ctx, -1)
+}
+
+// Down 回滚最后执行的steps个迁移 返回回滚的迁移
+func (m *Migrator) Down(ctx context.Context, steps int) ([]MigrationStatus, error) {
+	var done []MigrationStatus
+	err := m.locked(ctx, func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		versions := sortedVersions(applied)
+		for i := len(versions) - 1; i >= 0 && len(done) < steps; i-- {
+			st, err := m.down(conn, versions[i], applied[versions[i]])
+			if err != nil {
+				return err
+			}
+			done = append(done, st)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// To 迁移到version 大于version的已执行迁移回滚 小于等于version的未执行迁移执行 version为-1时执行所有
+func (m *Migrator) To(ctx context.Context, version int64) ([]MigrationStatus, error) {
+	var done []MigrationStatus
+	err := m.locked(ctx, func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		versions := sortedVersions(applied)
+		for i := len(versions) - 1; i >= 0 && version >= 0 && versions[i] > version; i-- {
+			st, err := m.down(conn, versions[i], applied[versions[i]])
+			if err != nil {
+				return err
+			}
+			done = append(done, st)
+		}
+		for _, mg := range m.migrations {
+			if version >= 0 && mg.Version > version {
+				break
+			}
+			if _, is := applied[mg.Version]; is {
+				continue
+			}
+			if err := m.run(conn, mg, mg.Up, func(tx *gorm.DB) error {
+				return tx.Table(m.opt.table).Create(map[string]any{
+					"version":    mg.Version,
+					"name":       mg.Name,
+					"applied_at": time.Now().UTC(),
+				}).Error
+			}); err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", mg.Version, mg.Name, err)
+			}
+			log.Infof("migration %d_%s up", mg.Version, mg.Name)
+			done = append(done, MigrationStatus{Version: mg.Version, Name: mg.Name, Applied: true})
+		}
+		return nil
+	})
+	return done, err
+}
+
+func (m *Migrator) down(conn *gorm.DB, version int64, sm schemaMigration) (MigrationStatus, error) {
+	idx := slices.IndexFunc(m.migrations, func(mg Migration) bool { return mg.Version == version })
+	if idx < 0 {
+		return MigrationStatus{}, fmt.Errorf("migration %d_%s down: migration not found", version, sm.Name)
+	}
+	mg := m.migrations[idx]
+	if mg.Down == nil {
+		return MigrationStatus{}, fmt.Errorf("migration %d_%s down: %w", mg.Version, mg.Name, ErrNoDown)
+	}
+	if err := m.run(conn, mg, mg.Down, func(tx *gorm.DB) error {
+		return tx.Exec("DELETE FROM "+tx.Statement.Quote(m.opt.table)+" WHERE version = ?", mg.Version).Error
+	}); err != nil {
+		return MigrationStatus{}, fmt.Errorf("migration %d_%s down: %w", mg.Version, mg.Name, err)
+	}
+	log.Infof("migration %d_%s down", mg.Version, mg.Name)
+	return MigrationStatus{Version: mg.Version, Name: mg.Name}, nil
+}
+
+// run 执行迁移并记录 支持事务的db在同一事务中执行
+func (m *Migrator) run(conn *gorm.DB, mg Migration, fn, record func(tx *gorm.DB) error) error {
+	if conn.Dialector.Name() == "clickhouse" {
+		if err := fn(conn); err != nil {
+			return err
+		}
+		return record(conn)
+	}
+	return conn.Transaction(func(tx *gorm.DB) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return record(tx)
+	})
+}
+
+func (m *Migrator) status(applied map[int64]schemaMigration) []MigrationStatus {
+	var status []MigrationStatus
+	for _, mg := range m.migrations {
+		st := MigrationStatus{Version: mg.Version, Name: mg.Name}
+		if sm, is := applied[mg.Version]; is {
+			st.Applied, st.AppliedAt = true, sm.AppliedAt
+		}
+		status = append(status, st)
+	}
+	for _, version := range sortedVersions(applied) {
+		if !slices.ContainsFunc(m.migrations, func(mg Migration) bool { return mg.Version == version }) {
+			sm := applied[version]
+			status = append(status, MigrationStatus{
+				Version: version, Name: sm.Name, Applied: true, AppliedAt: sm.AppliedAt, Missing: true,
+			})
+		}
+	}
+	slices.SortFunc(status, func(a, b MigrationStatus) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return status
+}
+
+func (m *Migrator) applied(conn *gorm.DB) (map[int64]schemaMigration, error) {
+	if err := conn.Exec(m.createTableSQL(conn.Dialector.Name())).Error; err != nil {
+		return nil, fmt.Errorf("create %s: %v", m.opt.table, err)
+	}
+	var rows []schemaMigration
+	if err := conn.Table(m.opt.table).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]schemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+func (m *Migrator) createTableSQL(dialect string) string {
+	table := m.opt.table
+	switch dialect {
+	case "sqlserver":
+		return fmt.Sprintf("IF OBJECT_ID(N'%s', N'U') IS NULL CREATE TABLE %s (version BIGINT NOT NULL PRIMARY KEY, name NVARCHAR(255) NOT NULL, applied_at DATETIME2 NOT NULL)", table, table)
+	case "clickhouse":
+		return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version Int64, name String, applied_at DateTime) ENGINE = MergeTree ORDER BY version", table)
+	case "postgres":
+		return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version BIGINT NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMP NOT NULL)", table)
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version BIGINT NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at DATETIME NOT NULL)", table)
+}
+
+// conn 在同一个连接上执行fn 会话级的锁需要在同一个连接上获取和释放
+func (m *Migrator) conn(ctx context.Context, fn func(conn *gorm.DB) error) error {
+	return m.db.WithContext(ctx).Connection(fn)
+}
+
+// locked 持有迁移锁时执行fn
+func (m *Migrator) locked(ctx context.Context, fn func(conn *gorm.DB) error) error {
+	return m.conn(ctx, func(conn *gorm.DB) error {
+		unlock, err := m.lock(ctx, conn)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := unlock(); err != nil {
+				log.Errorf("migration unlock error: %v", err)
+			}
+		}()
+		return fn(conn)
+	})
+}
+
+// lock 获取迁移锁 超时返回ErrMigrationLocked
+func (m *Migrator) lock(ctx context.Context, conn *gorm.DB) (unlock func() error, err error) {
+	name := "migrate:" + m.opt.table
+	var try func() (bool, error)
+	// ctx取消后也要释放锁 否则连接带着会话锁回到连接池
+	release := conn.WithContext(context.WithoutCancel(ctx))
+	switch conn.Dialector.Name() {
+	case "mysql":
+		try = func() (bool, error) {
+			var got int
+			err := conn.Raw("SELECT GET_LOCK(?, 0)", name).Scan(&got).Error
+			return got == 1, err
+		}
+		unlock = func() error {
+			return release.Exec("SELECT RELEASE_LOCK(?)", name).Error
+		}
+	case "postgres":
+		h := fnv.New64a()
+		h.Write([]byte(name))
+		key := int64(h.Sum64())
+		try = func() (bool, error) {
+			var got bool
+			err := conn.Raw("SELECT pg_try_advisory_lock(?)", key).Scan(&got).Error
+			return got, err
+		}
+		unlock = func() error {
+			return release.Exec("SELECT pg_advisory_unlock(?)", key).Error
+		}
+	case "sqlserver":
+		try = func() (bool, error) {
+			var got int
+			err := conn.Raw("DECLARE @r int; EXEC @r = sp_getapplock @Resource = ?, @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = 0; SELECT @r", name).Scan(&got).Error
+			return got >= 0, err
+		}
+		unlock = func() error {
+			return release.Exec("EXEC sp_releaseapplock @Resource = ?, @LockOwner = 'Session'", name).Error
+		}
+	case "clickhouse":
+		log.Warnf("migration lock is not supported by clickhouse, make sure only one process migrates")
+		return func() error { return nil }, nil
+	default:
+		// sqlite 主键冲突表示锁已被持有 进程异常退出时需要手动删除锁表中的记录
+		lockTable := m.opt.table + "_lock"
+		if err := conn.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id INTEGER NOT NULL PRIMARY KEY, locked_at DATETIME NOT NULL)", lockTable)).Error; err != nil {
+			return nil, err
+		}
+		try = func() (bool, error) {
+			res := conn.Exec(fmt.Sprintf("INSERT OR IGNORE INTO %s (id, locked_at) VALUES (1, ?)", lockTable), time.Now().UTC())
+			return res.RowsAffected == 1, res.Error
+		}
+		unlock = func() error {
+			return release.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = 1", lockTable)).Error
+		}
+	}
+	deadline := time.Now().Add(m.opt.lockTimeout)
+	for {
+		got, err := try()
+		if err != nil {
+			return nil, fmt.Errorf("migration lock: %v", err)
+		}
+		if got {
+			return unlock, nil
+		}
+		if !time.Now().Before(deadline) {
+			return nil, ErrMigrationLocked
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(min(200*time.Millisecond, time.Until(deadline))):
+		}
+	}
+}
+
+func sortedVersions(applied map[int64]schemaMigration) []int64 {
+	versions := make([]int64, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	slices.Sort(versions)
+	return versions
+}
@@ -0,0 +1,180 @@
+package dbx
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/wjoj/tool/v2/log"
+	"gorm.io/gorm"
+)
+
+func TestMain(m *testing.M) {
+	log.NewGlobal(log.Config{Level: "info"})
+	os.Exit(m.Run())
+}
+
+func newSQLite(t *testing.T) *DB {
+	t.Helper()
+	gdb, err := New(&Config{Driver: DriverSQLite, DbName: filepath.Join(t.TempDir(), "test"), LogName: "--", LogLevel: LogLevelSilent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if dc, err := gdb.DB(); err == nil {
+			dc.Close()
+		}
+	})
+	return gdb
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	gdb := newSQLite(t)
+	fsys := fstest.MapFS{
+		"migrations/1_create_users.up.sql": {Data: []byte(`
+-- 用户表; 注释中的分号
+CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL DEFAULT 'a;b');
+CREATE INDEX idx_users_name ON users (name);
+`)},
+		"migrations/1_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"migrations/2_add_email.up.sql":      {Data: []byte("ALTER TABLE users ADD COLUMN email TEXT;")},
+		"migrations/2_add_email.down.sql":    {Data: []byte("ALTER TABLE users DROP COLUMN email;")},
+		"migrations/README.md":               {Data: []byte("ignored")},
+	}
+	ms, err := SQLMigrations(fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms = append(ms, Migration{
+		Version: 3,
+		Name:    "seed",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("INSERT INTO users (name, email) VALUES (?, ?)", "admin", "admin@example.com").Error
+		},
+	})
+	m, err := NewMigrator(gdb, ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 3 {
+		t.Fatalf("up %v", done)
+	}
+	var name string
+	if err := gdb.Raw("SELECT name FROM users WHERE email = ?", "admin@example.com").Scan(&name).Error; err != nil || name != "admin" {
+		t.Fatalf("seed %q %v", name, err)
+	}
+	if done, err := m.Up(ctx); err != nil || len(done) != 0 {
+		t.Fatalf("second up %v %v", done, err)
+	}
+
+	// 3没有down 不能回滚
+	if _, err := m.Down(ctx, 1); !errors.Is(err, ErrNoDown) {
+		t.Fatalf("down without down: %v", err)
+	}
+	if err := gdb.Exec("DELETE FROM users").Error; err != nil {
+		t.Fatal(err)
+	}
+	m, _ = NewMigrator(gdb, slices.Insert(ms[:2:2], 2, Migration{
+		Version: 3,
+		Name:    "seed",
+		Up:      ms[2].Up,
+		Down:    func(tx *gorm.DB) error { return nil },
+	}))
+	done, err = m.To(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 2 || done[0].Version != 3 || done[1].Version != 2 {
+		t.Fatalf("to 1 %v", done)
+	}
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := []bool{}
+	for _, st := range status {
+		applied = append(applied, st.Applied)
+	}
+	if !slices.Equal(applied, []bool{true, false, false}) {
+		t.Fatalf("status %v", status)
+	}
+	if err := gdb.Exec("INSERT INTO users (name, email) VALUES ('x', 'y')").Error; err == nil {
+		t.Fatal("email column should be dropped")
+	}
+
+	// 没有对应迁移的已执行版本
+	if err := gdb.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (9, 'gone', ?)", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+	status, _ = m.Status(ctx)
+	if last := status[len(status)-1]; last.Version != 9 || !last.Missing {
+		t.Fatalf("missing %v", status)
+	}
+}
+
+func TestMigratorLock(t *testing.T) {
+	ctx := context.Background()
+	gdb := newSQLite(t)
+	m, err := NewMigrator(gdb, nil, WithLockTimeoutMigrateOption(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.locked(ctx, func(conn *gorm.DB) error {
+		_, err := m.Up(ctx)
+		return err
+	})
+	if !errors.Is(err, ErrMigrationLocked) {
+		t.Fatalf("lock %v", err)
+	}
+	// 释放后可以再次获取
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// 迁移中ctx取消时也要释放锁
+	cctx, cancel := context.WithCancel(ctx)
+	m.locked(cctx, func(conn *gorm.DB) error {
+		cancel()
+		return cctx.Err()
+	})
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("lock after cancel %v", err)
+	}
+}
+
+func TestSplitSQL(t *testing.T) {
+	got := splitSQL("a 'x;y'; /* c; */ b \"q;\";\n-- d;\nCREATE FUNCTION f() AS $$ BEGIN; END $$; ;", false)
+	want := []string{"a 'x;y'", "b \"q;\"", "CREATE FUNCTION f() AS $$ BEGIN; END $$"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("%q", got)
+	}
+
+	// mysql的\转义
+	got = splitSQL(`INSERT INTO t VALUES ('a\';b', "c\";d"); SELECT 'it''s;'`, true)
+	want = []string{`INSERT INTO t VALUES ('a\';b', "c\";d")`, `SELECT 'it''s;'`}
+	if !slices.Equal(got, want) {
+		t.Fatalf("backslash %q", got)
+	}
+	// postgres中\不转义
+	got = splitSQL(`SELECT 'C:\'; SELECT 1`, false)
+	if len(got) != 2 {
+		t.Fatalf("standard strings %q", got)
+	}
+	// 带tag的美元引号 $1参数不是引号
+	got = splitSQL("CREATE FUNCTION f() AS $body$ BEGIN; RETURN $1; END $body$ LANGUAGE plpgsql; SELECT $1, $2", false)
+	want = []string{"CREATE FUNCTION f() AS $body$ BEGIN; RETURN $1; END $body$ LANGUAGE plpgsql", "SELECT $1, $2"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("dollar tag %q", got)
+	}
+}
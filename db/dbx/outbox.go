@@ -0,0 +1,535 @@
+package dbx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/wjoj/tool/v2/log"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrOutboxNoTx Enqueue时ctx中没有InTx的事务
+var ErrOutboxNoTx = errors.New("outbox enqueue requires a transaction, use InTx")
+
+// OutboxStatus 消息的状态
+type OutboxStatus string
+
+const (
+	OutboxPending OutboxStatus = "pending" // 等待发送
+	OutboxSent    OutboxStatus = "sent"    // 已发送
+	OutboxFailed  OutboxStatus = "failed"  // 超过最大次数 不再发送
+)
+
+// OutboxMessage 发件箱的消息 默认为outbox_messages表
+type OutboxMessage struct {
+	ID        uint64            `gorm:"primaryKey" json:"id"`
+	Topic     string            `gorm:"size:128" json:"topic"`
+	Key       string            `gorm:"size:128" json:"key"` //消息的key 由发布者使用
+	Payload   []byte            `json:"payload"`
+	Headers   map[string]string `gorm:"serializer:json" json:"headers"`
+	Status    OutboxStatus      `gorm:"size:16;index:idx_outbox_pending,priority:1" json:"status"`
+	NextAt    time.Time         `gorm:"index:idx_outbox_pending,priority:2" json:"nextAt"` //下次发送的时间 领取后为租约到期时间
+	Attempts  int               `json:"attempts"`
+	LastError string            `gorm:"size:512" json:"lastError"`
+	CreatedAt time.Time         `json:"createdAt"`
+	SentAt    *time.Time        `json:"sentAt"`
+}
+
+func (OutboxMessage) TableName() string {
+	return "outbox_messages"
+}
+
+// outboxNow 统一使用UTC sqlite按字符串比较时间
+func outboxNow() time.Time {
+	return time.Now().UTC()
+}
+
+type enqueueOptions struct {
+	dbKey   string
+	key     string
+	headers map[string]string
+	delay   time.Duration
+}
+
+type EnqueueOption func(o *enqueueOptions)
+
+// WithDBKeyEnqueueOption 写入的db的key 默认为默认key
+func WithDBKeyEnqueueOption(key string) EnqueueOption {
+	return func(o *enqueueOptions) {
+		o.dbKey = key
+	}
+}
+
+// WithKeyEnqueueOption 消息的key
+func WithKeyEnqueueOption(key string) EnqueueOption {
+	return func(o *enqueueOptions) {
+		o.key = key
+	}
+}
+
+// WithHeadersEnqueueOption 消息的头
+func WithHeadersEnqueueOption(headers map[string]string) EnqueueOption {
+	return func(o *enqueueOptions) {
+		o.headers = headers
+	}
+}
+
+// WithDelayEnqueueOption 延迟发送
+func WithDelayEnqueueOption(delay time.Duration) EnqueueOption {
+	return func(o *enqueueOptions) {
+		o.delay = delay
+	}
+}
+
+// Enqueue 写入发件箱 和ctx中InTx的事务一起提交 由OutboxRelay发送 没有事务时返回ErrOutboxNoTx
+// payload为[]byte和string时直接使用 其他类型使用json
+func Enqueue(ctx context.Context, topic string, payload any, options ...EnqueueOption) error {
+	opt := enqueueOptions{}
+	for _, option := range options {
+		if option != nil {
+			option(&opt)
+		}
+	}
+	if len(opt.dbKey) == 0 {
+		opt.dbKey = defaultKey
+	}
+	tx, is := ctx.Value(txKey{opt.dbKey}).(*gorm.DB)
+	if !is {
+		return ErrOutboxNoTx
+	}
+	var data []byte
+	switch v := payload.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("outbox payload: %w", err)
+		}
+		data = b
+	}
+	now := outboxNow()
+	msg := &OutboxMessage{
+		Topic:     topic,
+		Key:       opt.key,
+		Payload:   data,
+		Headers:   opt.headers,
+		Status:    OutboxPending,
+		NextAt:    now.Add(opt.delay),
+		CreatedAt: now,
+	}
+	return tx.WithContext(ctx).Create(msg).Error
+}
+
+// OutboxPublisher 发送发件箱的消息 返回错误时按退避时间重试 需要支持重复的消息
+type OutboxPublisher interface {
+	Publish(ctx context.Context, msg *OutboxMessage) error
+}
+
+// RedisOutboxPublisher 发送到redis stream 字段为id topic key payload和headers
+type RedisOutboxPublisher struct {
+	Client redis.Cmdable //例如redisx.GetClient()
+	Stream string        //stream名称 默认为消息的topic
+	MaxLen int64         //stream的近似最大长度 0不限制
+}
+
+func (p RedisOutboxPublisher) Publish(ctx context.Context, msg *OutboxMessage) error {
+	if p.Client == nil {
+		return errors.New("redis outbox publisher: client is required")
+	}
+	stream := p.Stream
+	if len(stream) == 0 {
+		stream = msg.Topic
+	}
+	values := map[string]any{
+		"id":      strconv.FormatUint(msg.ID, 10),
+		"topic":   msg.Topic,
+		"key":     msg.Key,
+		"payload": msg.Payload,
+	}
+	if len(msg.Headers) != 0 {
+		headers, err := json.Marshal(msg.Headers)
+		if err != nil {
+			return err
+		}
+		values["headers"] = headers
+	}
+	return p.Client.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		MaxLen: p.MaxLen,
+		Approx: p.MaxLen > 0,
+		Values: values,
+	}).Err()
+}
+
+// WebhookOutboxPublisher POST消息的payload到URL 返回2xx为成功
+// 请求头带上消息的headers和X-Outbox-Id X-Outbox-Topic X-Outbox-Key
+type WebhookOutboxPublisher struct {
+	URL         string
+	ContentType string            //默认application/json
+	Headers     map[string]string //额外的请求头
+	Client      *http.Client      //默认http.DefaultClient
+}
+
+func (p WebhookOutboxPublisher) Publish(ctx context.Context, msg *OutboxMessage) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(msg.Payload))
+	if err != nil {
+		return err
+	}
+	contentType := p.ContentType
+	if len(contentType) == 0 {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range p.Headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range msg.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("X-Outbox-Id", strconv.FormatUint(msg.ID, 10))
+	req.Header.Set("X-Outbox-Topic", msg.Topic)
+	if len(msg.Key) != 0 {
+		req.Header.Set("X-Outbox-Key", msg.Key)
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("webhook status %d: %s", resp.StatusCode, body)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// LogOutboxPublisher 写入日志
+type LogOutboxPublisher struct{}
+
+func (LogOutboxPublisher) Publish(_ context.Context, msg *OutboxMessage) error {
+	log.Infow("db outbox", "id", msg.ID, "topic", msg.Topic, "key", msg.Key, "payload", string(msg.Payload))
+	return nil
+}
+
+type outboxRelayOptions struct {
+	name        string
+	dbKey       string
+	deps        []string
+	batchSize   int
+	interval    time.Duration
+	lease       time.Duration
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	retention   time.Duration
+}
+
+type OutboxRelayOption func(o *outboxRelayOptions)
+
+// WithNameOutboxRelayOption 组件名称和指标的relay标签 默认outbox
+func WithNameOutboxRelayOption(name string) OutboxRelayOption {
+	return func(o *outboxRelayOptions) {
+		o.name = name
+	}
+}
+
+// WithDBKeyOutboxRelayOption 发件箱所在db的key 默认为默认key
+func WithDBKeyOutboxRelayOption(key string) OutboxRelayOption {
+	return func(o *outboxRelayOptions) {
+		o.dbKey = key
+	}
+}
+
+// WithDependenciesOutboxRelayOption 作为组件时依赖的组件 默认gorm 发送到redis时加上redis
+func WithDependenciesOutboxRelayOption(deps ...string) OutboxRelayOption {
+	return func(o *outboxRelayOptions) {
+		o.deps = deps
+	}
+}
+
+// WithBatchSizeOutboxRelayOption 每次领取的消息数 默认100
+func WithBatchSizeOutboxRelayOption(size int) OutboxRelayOption {
+	return func(o *outboxRelayOptions) {
+		o.batchSize = size
+	}
+}
+
+// WithIntervalOutboxRelayOption 没有消息时的轮询间隔 默认1s
+func WithIntervalOutboxRelayOption(interval time.Duration) OutboxRelayOption {
+	return func(o *outboxRelayOptions) {
+		o.interval = interval
+	}
+}
+
+// WithLeaseOutboxRelayOption 领取消息的租约 超过时间没有发送完成时其他relay可以重新领取 默认30s
+func WithLeaseOutboxRelayOption(lease time.Duration) OutboxRelayOption {
+	return func(o *outboxRelayOptions) {
+		o.lease = lease
+	}
+}
+
+// WithRetryOutboxRelayOption 最大发送次数和退避时间 退避时间每次翻倍 不超过maxBackoff 默认10次 1s 5m
+func WithRetryOutboxRelayOption(maxAttempts int, backoff, maxBackoff time.Duration) OutboxRelayOption {
+	return func(o *outboxRelayOptions) {
+		o.maxAttempts = maxAttempts
+		o.backoff = backoff
+		o.maxBackoff = maxBackoff
+	}
+}
+
+// WithRetentionOutboxRelayOption 删除发送时间超过retention的消息 默认0不删除
+func WithRetentionOutboxRelayOption(retention time.Duration) OutboxRelayOption {
+	return func(o *outboxRelayOptions) {
+		o.retention = retention
+	}
+}
+
+// OutboxStats 发件箱的状态
+type OutboxStats struct {
+	Pending   int64         //等待发送的消息数
+	Failed    int64         //不再发送的消息数
+	Lag       time.Duration //最早等待发送的消息已等待的时间
+	Published uint64        //本relay发送成功的次数
+	Errors    uint64        //本relay发送失败的次数
+}
+
+// OutboxRelay 领取发件箱的消息发送到OutboxPublisher 实现tool.Component
+// 消息至少发送一次 不保证顺序 多个relay通过行锁和租约领取不同的消息
+// sqlite没有行锁 多个relay可能领取相同的消息 只能运行一个relay
+type OutboxRelay struct {
+	opt       outboxRelayOptions
+	publisher OutboxPublisher
+	published atomic.Uint64
+	errors    atomic.Uint64
+	mu        sync.Mutex
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+func NewOutboxRelay(publisher OutboxPublisher, options ...OutboxRelayOption) *OutboxRelay {
+	opt := outboxRelayOptions{
+		name:        "outbox",
+		deps:        []string{"gorm"},
+		batchSize:   100,
+		interval:    time.Second,
+		lease:       30 * time.Second,
+		maxAttempts: 10,
+		backoff:     time.Second,
+		maxBackoff:  5 * time.Minute,
+	}
+	for _, option := range options {
+		if option != nil {
+			option(&opt)
+		}
+	}
+	return &OutboxRelay{opt: opt, publisher: publisher}
+}
+
+func (r *OutboxRelay) Name() string {
+	return r.opt.name
+}
+
+func (r *OutboxRelay) Dependencies() []string {
+	return r.opt.deps
+}
+
+// Start 启动轮询 不阻塞
+func (r *OutboxRelay) Start(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel != nil {
+		return fmt.Errorf("outbox relay %s already started", r.opt.name)
+	}
+	ctx, r.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	r.done = make(chan struct{})
+	go r.run(ctx)
+	log.Infof("outbox relay %s start", r.opt.name)
+	return nil
+}
+
+// Stop 停止轮询 等待发送中的消息完成
+func (r *OutboxRelay) Stop(ctx context.Context) error {
+	r.mu.Lock()
+	cancel, done := r.cancel, r.done
+	r.cancel = nil
+	r.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	select {
+	case <-done:
+		log.Infof("outbox relay %s stopped", r.opt.name)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *OutboxRelay) run(ctx context.Context) {
+	defer close(r.done)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	lastClean := time.Time{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		n, err := r.RelayOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Errorf("outbox relay %s error: %v", r.opt.name, err)
+		}
+		if r.opt.retention > 0 && time.Since(lastClean) > r.opt.retention/10 {
+			lastClean = time.Now()
+			if err := r.clean(ctx); err != nil && ctx.Err() == nil {
+				log.Errorf("outbox relay %s clean error: %v", r.opt.name, err)
+			}
+		}
+		// 领取满一批时继续发送
+		wait := r.opt.interval
+		if err == nil && n >= r.opt.batchSize {
+			wait = 0
+		}
+		timer.Reset(wait)
+	}
+}
+
+func (r *OutboxRelay) db(ctx context.Context) *gorm.DB {
+	if len(r.opt.dbKey) == 0 {
+		return Get().WithContext(ctx)
+	}
+	return Get(r.opt.dbKey).WithContext(ctx)
+}
+
+// claim 领取到期的消息 设置租约后提交 发送期间其他relay不会领取
+func (r *OutboxRelay) claim(ctx context.Context) ([]*OutboxMessage, error) {
+	var msgs []*OutboxMessage
+	err := r.db(ctx).Transaction(func(tx *gorm.DB) error {
+		now := outboxNow()
+		if err := r.claimQuery(tx, now).Find(&msgs).Error; err != nil || len(msgs) == 0 {
+			return err
+		}
+		ids := make([]uint64, len(msgs))
+		for i, msg := range msgs {
+			ids[i] = msg.ID
+		}
+		return tx.Model(&OutboxMessage{}).Where("id IN ?", ids).Update("next_at", now.Add(r.opt.lease)).Error
+	})
+	return msgs, err
+}
+
+// claimQuery 查询到期的消息并锁定 跳过其他relay已锁定的行
+func (r *OutboxRelay) claimQuery(tx *gorm.DB, now time.Time) *gorm.DB {
+	switch tx.Dialector.Name() {
+	case "sqlite":
+	case "sqlserver":
+		tx = tx.Table(OutboxMessage{}.TableName() + " WITH (UPDLOCK, READPAST, ROWLOCK)")
+	default:
+		tx = tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate, Options: clause.LockingOptionsSkipLocked})
+	}
+	return tx.Where("status = ? AND next_at <= ?", OutboxPending, now).Order("next_at, id").Limit(r.opt.batchSize)
+}
+
+// RelayOnce 领取并发送一批消息 返回领取的消息数
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	msgs, err := r.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var errs []error
+	for _, msg := range msgs {
+		if ctx.Err() != nil {
+			// 未发送的消息在租约到期后重新领取
+			break
+		}
+		if err := r.publish(ctx, msg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return len(msgs), errors.Join(errs...)
+}
+
+// publish 发送消息并更新状态 返回发送和更新状态的错误
+func (r *OutboxRelay) publish(ctx context.Context, msg *OutboxMessage) error {
+	perr := r.publisher.Publish(ctx, msg)
+	now := outboxNow()
+	updates := map[string]any{"attempts": gorm.Expr("attempts + 1")}
+	if perr == nil {
+		r.published.Add(1)
+		updates["status"], updates["sent_at"], updates["last_error"] = OutboxSent, now, ""
+	} else {
+		r.errors.Add(1)
+		attempts := msg.Attempts + 1
+		text := perr.Error()
+		if len(text) > 512 {
+			text = text[:512]
+		}
+		updates["last_error"] = text
+		if attempts >= r.opt.maxAttempts {
+			updates["status"] = OutboxFailed
+			log.Errorf("outbox relay %s message %d failed after %d attempts: %v", r.opt.name, msg.ID, attempts, perr)
+		} else {
+			updates["next_at"] = now.Add(r.backoff(attempts))
+		}
+	}
+	// ctx取消时也要记录发送结果 避免重复发送
+	err := r.db(context.WithoutCancel(ctx)).Model(&OutboxMessage{}).Where("id = ?", msg.ID).Updates(updates).Error
+	if perr != nil {
+		perr = fmt.Errorf("message %d: %w", msg.ID, perr)
+	}
+	return errors.Join(perr, err)
+}
+
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	d := r.opt.backoff
+	for i := 1; i < attempts && d < r.opt.maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, r.opt.maxBackoff)
+}
+
+func (r *OutboxRelay) clean(ctx context.Context) error {
+	return r.db(ctx).Where("status = ? AND sent_at < ?", OutboxSent, outboxNow().Add(-r.opt.retention)).Delete(&OutboxMessage{}).Error
+}
+
+// Stats 发件箱的消息数和延迟 每次调用查询数据库
+func (r *OutboxRelay) Stats(ctx context.Context) (OutboxStats, error) {
+	st := OutboxStats{Published: r.published.Load(), Errors: r.errors.Load()}
+	db := r.db(ctx)
+	if err := db.Model(&OutboxMessage{}).Where("status = ?", OutboxPending).Count(&st.Pending).Error; err != nil {
+		return st, err
+	}
+	if err := db.Model(&OutboxMessage{}).Where("status = ?", OutboxFailed).Count(&st.Failed).Error; err != nil {
+		return st, err
+	}
+	if st.Pending == 0 {
+		return st, nil
+	}
+	var oldest OutboxMessage
+	if err := db.Select("created_at").Where("status = ?", OutboxPending).Order("created_at").Take(&oldest).Error; err != nil {
+		return st, err
+	}
+	st.Lag = max(outboxNow().Sub(oldest.CreatedAt), 0)
+	return st, nil
+}
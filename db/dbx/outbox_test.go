@@ -0,0 +1,126 @@
+package dbx
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlserver"
+	"gorm.io/gorm"
+)
+
+type outboxPublisherFunc func(ctx context.Context, msg *OutboxMessage) error
+
+func (f outboxPublisherFunc) Publish(ctx context.Context, msg *OutboxMessage) error {
+	return f(ctx, msg)
+}
+
+func TestOutbox(t *testing.T) {
+	gdb := newSQLite(t)
+	oldDbs, oldKey := dbs, defaultKey
+	dbs, defaultKey = map[string]*DB{"outbox": gdb}, "outbox"
+	t.Cleanup(func() { dbs, defaultKey = oldDbs, oldKey })
+	if err := gdb.AutoMigrate(&OutboxMessage{}, &txItem{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	errFail := errors.New("fail")
+	err := InTx(ctx, func(ctx context.Context) error {
+		if err := Enqueue(ctx, "orders", map[string]int{"id": 1}); err != nil {
+			return err
+		}
+		return errFail
+	})
+	if !errors.Is(err, errFail) {
+		t.Fatal(err)
+	}
+	err = InTx(ctx, func(ctx context.Context) error {
+		if err := FromContext(ctx).Create(&txItem{ID: 1}).Error; err != nil {
+			return err
+		}
+		if err := Enqueue(ctx, "orders", map[string]int{"id": 1}, WithKeyEnqueueOption("1")); err != nil {
+			return err
+		}
+		return Enqueue(ctx, "orders", "later", WithDelayEnqueueOption(time.Hour))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	calls := 0
+	relay := NewOutboxRelay(outboxPublisherFunc(func(ctx context.Context, msg *OutboxMessage) error {
+		calls++
+		if calls == 1 {
+			return errFail
+		}
+		got = append(got, msg.Key+":"+string(msg.Payload))
+		return nil
+	}), WithRetryOutboxRelayOption(3, time.Millisecond, time.Millisecond))
+
+	if n, err := relay.RelayOnce(ctx); n != 1 || !errors.Is(err, errFail) {
+		t.Fatalf("first relay %d %v", n, err)
+	}
+	st, err := relay.Stats(ctx)
+	if err != nil || st.Pending != 2 || st.Errors != 1 {
+		t.Fatalf("stats %+v %v", st, err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if n, err := relay.RelayOnce(ctx); n != 1 || err != nil {
+		t.Fatalf("retry relay %d %v", n, err)
+	}
+	if len(got) != 1 || got[0] != `1:{"id":1}` {
+		t.Fatalf("published %v", got)
+	}
+	var msg OutboxMessage
+	gdb.Where("key = ?", "1").Take(&msg)
+	if msg.Status != OutboxSent || msg.Attempts != 2 || msg.SentAt == nil {
+		t.Fatalf("message %+v", msg)
+	}
+
+	// 作为组件启动和停止
+	if err := relay.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := relay.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := relay.Stats(ctx); st.Pending != 1 || st.Published != 1 {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+func TestOutboxEnqueueNoTx(t *testing.T) {
+	gdb := newSQLite(t)
+	oldDbs, oldKey := dbs, defaultKey
+	dbs, defaultKey = map[string]*DB{"outbox": gdb}, "outbox"
+	t.Cleanup(func() { dbs, defaultKey = oldDbs, oldKey })
+	if err := gdb.AutoMigrate(&OutboxMessage{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Enqueue(context.Background(), "orders", "x"); !errors.Is(err, ErrOutboxNoTx) {
+		t.Fatalf("enqueue without tx %v", err)
+	}
+	var n int64
+	gdb.Model(&OutboxMessage{}).Count(&n)
+	if n != 0 {
+		t.Fatalf("messages %d", n)
+	}
+}
+
+func TestOutboxClaimQuery(t *testing.T) {
+	gdb, err := gorm.Open(sqlserver.Open("sqlserver://sa:p@127.0.0.1:1433?database=d"), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay := NewOutboxRelay(LogOutboxPublisher{}, WithBatchSizeOutboxRelayOption(10))
+	sql := gdb.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		return relay.claimQuery(tx, time.Time{}).Find(&[]*OutboxMessage{})
+	})
+	if !strings.Contains(sql, "FROM outbox_messages WITH (UPDLOCK, READPAST, ROWLOCK) WHERE") {
+		t.Fatalf("sqlserver claim %s", sql)
+	}
+}
@@ -0,0 +1,140 @@
+package dbx
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+
+	"github.com/wjoj/tool/v2/pagination"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Paginate 页码分页的scope
+func Paginate(req pagination.Request) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Offset(req.Offset()).Limit(req.Limit())
+	}
+}
+
+// Keyset 游标分页的scope values为上一页最后一条记录排序列的值 为空时为第一页
+// 条件展开为 (a > ?) OR (a = ? AND b > ?) 兼容不支持行比较的数据库
+func Keyset(orders []pagination.Order, values []any) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		for _, o := range orders {
+			db = db.Order(clause.OrderByColumn{Column: clause.Column{Name: o.Column}, Desc: o.Desc})
+		}
+		if len(values) == 0 {
+			return db
+		}
+		var ors []clause.Expression
+		for i, o := range orders {
+			var ands []clause.Expression
+			for j := range i {
+				ands = append(ands, clause.Eq{Column: clause.Column{Name: orders[j].Column}, Value: values[j]})
+			}
+			col := clause.Column{Name: o.Column}
+			if o.Desc {
+				ands = append(ands, clause.Lt{Column: col, Value: values[i]})
+			} else {
+				ands = append(ands, clause.Gt{Column: col, Value: values[i]})
+			}
+			ors = append(ors, clause.And(ands...))
+		}
+		return db.Where(clause.Or(ors...))
+	}
+}
+
+// FindPage 分页查询 orders为空时使用页码分页 否则使用游标分页
+// req.Total为true时返回总数(不包含游标的条件)
+func FindPage[T any](db *gorm.DB, req pagination.Request, orders ...pagination.Order) (pagination.Response[T], error) {
+	var total *int64
+	if req.Total {
+		var n int64
+		if err := db.Session(&gorm.Session{}).Model(new(T)).Count(&n).Error; err != nil {
+			return pagination.Response[T]{}, err
+		}
+		total = &n
+	}
+	res, err := findPage[T](db, req, orders)
+	if err != nil {
+		return pagination.Response[T]{}, err
+	}
+	res.Total = total
+	return res, nil
+}
+
+func findPage[T any](db *gorm.DB, req pagination.Request, orders []pagination.Order) (pagination.Response[T], error) {
+	var items []T
+	limit := req.Limit()
+	if len(orders) == 0 {
+		if len(req.Cursor) != 0 {
+			return pagination.Response[T]{}, fmt.Errorf("%w: cursor requires order columns", pagination.ErrInvalidCursor)
+		}
+		// 多查一条判断是否有下一页
+		if err := db.Offset(req.Offset()).Limit(limit + 1).Find(&items).Error; err != nil {
+			return pagination.Response[T]{}, err
+		}
+		res := pagination.NewResponse(req, items)
+		if len(res.Items) > limit {
+			res.Items, res.HasMore = res.Items[:limit], true
+		}
+		return res, nil
+	}
+
+	var values []any
+	if len(req.Cursor) != 0 {
+		var err error
+		if values, err = pagination.DecodeCursor(req.Cursor); err != nil {
+			return pagination.Response[T]{}, err
+		}
+		if len(values) != len(orders) {
+			return pagination.Response[T]{}, pagination.ErrInvalidCursor
+		}
+	}
+	if err := db.Scopes(Keyset(orders, values)).Limit(limit + 1).Find(&items).Error; err != nil {
+		return pagination.Response[T]{}, err
+	}
+	res := pagination.NewResponse(req, items)
+	if len(res.Items) <= limit {
+		return res, nil
+	}
+	res.Items, res.HasMore = res.Items[:limit], true
+	cursor, err := keysetCursor(db, &res.Items[limit-1], orders)
+	if err != nil {
+		return pagination.Response[T]{}, err
+	}
+	res.NextCursor = cursor
+	return res, nil
+}
+
+// keysetCursor 记录排序列的值编码为游标
+func keysetCursor(db *gorm.DB, item any, orders []pagination.Order) (string, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(item); err != nil {
+		return "", err
+	}
+	rv := reflect.ValueOf(item).Elem()
+	values := make([]any, len(orders))
+	for i, o := range orders {
+		field := stmt.Schema.LookUpField(o.Column)
+		if field == nil {
+			return "", fmt.Errorf("order column %s not found in %s", o.Column, stmt.Schema.Name)
+		}
+		v, _ := field.ValueOf(db.Statement.Context, rv)
+		if valuer, is := v.(driver.Valuer); is {
+			dv, err := valuer.Value()
+			if err != nil {
+				return "", err
+			}
+			v = dv
+		} else if pv := reflect.ValueOf(v); pv.Kind() == reflect.Ptr {
+			v = nil
+			if !pv.IsNil() {
+				v = pv.Elem().Interface()
+			}
+		}
+		values[i] = v
+	}
+	return pagination.EncodeCursor(values...)
+}
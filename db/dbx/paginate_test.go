@@ -0,0 +1,67 @@
+package dbx
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/wjoj/tool/v2/pagination"
+)
+
+type pageItem struct {
+	ID        int
+	Score     int
+	CreatedAt time.Time
+}
+
+func TestFindPage(t *testing.T) {
+	gdb := newSQLite(t)
+	if err := gdb.AutoMigrate(&pageItem{}); err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= 7; i++ {
+		gdb.Create(&pageItem{ID: i, Score: i % 3, CreatedAt: base.Add(time.Duration(i) * time.Hour)})
+	}
+
+	res, err := FindPage[pageItem](gdb.Order("id"), pagination.Request{Page: 3, Size: 3, Total: true})
+	if err != nil || len(res.Items) != 1 || res.Items[0].ID != 7 || res.HasMore || *res.Total != 7 || res.Page != 3 {
+		t.Fatalf("offset %+v %v", res, err)
+	}
+
+	// 按score倒序 id正序翻页
+	orders := []pagination.Order{{Column: "score", Desc: true}, {Column: "id"}}
+	var ids []int
+	req := pagination.Request{Size: 3}
+	for range 5 {
+		res, err := FindPage[pageItem](gdb.Where("id <> ?", 4), req, orders...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range res.Items {
+			ids = append(ids, item.ID)
+		}
+		if !res.HasMore {
+			break
+		}
+		req.Cursor = res.NextCursor
+	}
+	if fmt.Sprint(ids) != "[2 5 1 7 3 6]" {
+		t.Fatalf("keyset ids %v", ids)
+	}
+
+	// 时间列作为游标
+	res, err = FindPage[pageItem](gdb.Model(&pageItem{}), pagination.Request{Size: 4}, pagination.Order{Column: "created_at", Desc: true})
+	if err != nil || !res.HasMore {
+		t.Fatalf("time %+v %v", res, err)
+	}
+	res, err = FindPage[pageItem](gdb.Model(&pageItem{}), pagination.Request{Size: 4, Cursor: res.NextCursor}, pagination.Order{Column: "created_at", Desc: true})
+	if err != nil || len(res.Items) != 3 || res.Items[0].ID != 3 || res.HasMore {
+		t.Fatalf("time next %+v %v", res, err)
+	}
+
+	if _, err := FindPage[pageItem](gdb, pagination.Request{Cursor: res.NextCursor + "x"}, orders...); !errors.Is(err, pagination.ErrInvalidCursor) {
+		t.Fatalf("invalid cursor %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package dbx
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"regexp"
@@ -256,6 +257,11 @@ func analysisQueryConditionFuncNames(fs []string, fc *funcName) {
 // 例子5  field type `query:"field=?;or;order field desc;group field"`
 // 例子6  field type `query:"field;or;order field desc;group field"`
 // 例子7  field type `query:"order field desc;joins:left join table1 on table1.xx=?"`
+// 例子8  field type `query:"op=between;col=created_at"` 值为[2]time.Time 一端为零值时为>=或<=
+// 例子9  field type `query:"op=like"` 值中的%和_自动转义 op见queryOps
+// 例子10 field []int `query:"col=status"` 切片使用in
+// 例子11 field type `query:"op=prefix;col=name;group=kw"` 和 `query:"id;or;group=kw"` 相同group的条件组合为 (name LIKE ? OR id = ?) 整组以and加入
+// tag错误时返回db.Error 启动时可使用ValidateQueryConditions检查
 // item: 对象 字段的tag=query(默认)加入查询条件 值为0或空字符串不加入查询(要使用0或空字符串使用指针)
 // item: 对象定义的方法 结构为func(*gorm.DB) *gorm.DB,方法名任意 加入查询条件(注意方法定义推荐不使用指针)
 // item: 对象的字段对应的类型,存在Value方法(必须有一个返回值), 这个字段的值为Value方法返回的值
@@ -323,6 +329,7 @@ func QueryConditions(item any, opts ...QueryConditionOption) func(db *gorm.DB) *
 				}
 			}
 		}
+		var groups []*queryGroup
 		for i := range ty.NumField() {
 			field := ty.Field(i)
 			fieldty := field.Type
@@ -336,8 +343,12 @@ func QueryConditions(item any, opts ...QueryConditionOption) func(db *gorm.DB) *
 			if tagVal == "-" {
 				continue
 			}
-			switch fieldty.Kind() {
-			case reflect.Struct:
+			tagCon := analyzeQueryConditionsTagVal(tagVal, field.Name, opt.keyClause)
+			if tagCon.err != nil {
+				db.AddError(fmt.Errorf("query conditions %s.%s: %w", ty.Name(), field.Name, tagCon.err))
+				continue
+			}
+			if tagCon.isNested(fieldty) {
 				val := val.FieldByName(field.Name)
 				if !val.IsValid() || val.IsZero() {
 					continue
@@ -351,7 +362,6 @@ func QueryConditions(item any, opts ...QueryConditionOption) func(db *gorm.DB) *
 				)(db)
 				continue
 			}
-			tagCon := analyzeQueryConditionsTagVal(tagVal, field.Name, opt.keyClause)
 			db = dbSpliceSqlOrderGroup(db, tagCon)
 			val := val.FieldByName(field.Name)
 			if !val.IsValid() || val.IsZero() {
@@ -371,7 +381,10 @@ func QueryConditions(item any, opts ...QueryConditionOption) func(db *gorm.DB) *
 			if !val.IsValid() || val.IsZero() {
 				continue
 			}
-			db = dbSpliceSqlVal(db, tagCon, val.Interface())
+			db = dbSpliceSqlVal(db, tagCon, val, &groups)
+		}
+		for _, g := range groups {
+			db = db.Where(g.expr)
 		}
 		return db
 	}
@@ -385,16 +398,46 @@ type tagQueryConditions struct {
 	Having    string
 	Joins     string
 	FieldName string
+	Op        string //op=
+	Column    string //col= 默认为FieldName
+	GroupName string //group=
+	err       error
+}
+
+// isNested 结构体字段作为嵌套的查询条件 time.Time和使用了op或col的字段除外
+func (tag tagQueryConditions) isNested(ty reflect.Type) bool {
+	return ty.Kind() == reflect.Struct && ty != timeType && len(tag.Op) == 0 && len(tag.Column) == 0
 }
 
 func analyzeQueryConditionsTagVal(tagVal string, fieldName string, keyClause map[KeyClauseType]struct{}) (tag tagQueryConditions) {
 	tag = tagQueryConditions{}
 	tagVals := strings.Split(tagVal, ";")
 	tag.FieldName = strcase.ToSnake(fieldName)
+	for i := range tagVals {
+		m := regTagOption.FindStringSubmatch(tagVals[i])
+		if m == nil {
+			continue
+		}
+		switch strings.ToLower(m[1]) {
+		case "op":
+			tag.Op = strings.ToLower(m[2])
+			if !slices.Contains(queryOps, tag.Op) {
+				tag.err = fmt.Errorf("unknown op %s", m[2])
+			}
+		case "col":
+			tag.Column = m[2]
+			tag.FieldName = m[2]
+		case "group":
+			tag.GroupName = m[2]
+		}
+		tagVals[i] = ""
+	}
 	for i := range tagVals {
 		val := tagVals[i]
 		vall := strings.TrimSpace(strings.ToLower(val))
-		if _, is := keyClause[KeyClauseTypeWhere]; is && slices.Contains([]string{"or", "not", "and"}, vall) {
+		if len(vall) == 0 {
+			continue
+		} else if _, is := keyClause[KeyClauseTypeWhere]; is && slices.Contains([]string{"or", "not", "and"}, vall) {
 			tag.Ifc = vall
 		} else if _, is := keyClause[KeyClauseTypeOrderBy]; is && strings.HasPrefix(vall, "order") {
 			tag.Order = strings.Replace(strings.Replace(vall, "order", "", 1), " ", "", -1)
@@ -417,18 +460,31 @@ func analyzeQueryConditionsTagVal(tagVal string, fieldName string, keyClause map
 				tag.Having = tag.FieldName
 			}
 		} else if _, is := keyClause[KeyClauseTypeJoins]; is && strings.HasPrefix(vall, "joins") {
-			tag.Joins = strings.Replace(vall, "joins", "", 1)
+			tag.Joins = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(val)[len("joins"):], ":"))
 			if len(tag.Joins) == 0 {
-				log.Warn("joins statements cannot be empty")
+				tag.err = errors.New("joins statements cannot be empty")
 			}
 		} else if _, is := keyClause[KeyClauseTypeWhere]; is {
 			tag.Where = val
 		}
 	}
+	if len(tag.Column) != 0 && len(tag.Op) == 0 {
+		tag.Op = "eq"
+	}
+	if len(tag.Op) != 0 {
+		if len(tag.Where) != 0 {
+			tag.err = fmt.Errorf("op=%s cannot be used with the sql template %q", tag.Op, tag.Where)
+		}
+		tag.Column = tag.FieldName
+		return
+	}
 	if len(tag.Where) == 0 && ((len(tag.Having) == 0 && len(tag.Group) == 0 &&
 		len(tag.Joins) == 0 && len(tag.Order) == 0) || len(tag.Ifc) > 0) {
 		tag.Where = tag.FieldName
 	}
+	if len(tag.GroupName) != 0 && len(tag.Where) == 0 {
+		tag.err = fmt.Errorf("group=%s requires a where condition", tag.GroupName)
+	}
 	return
 }
 
@@ -442,32 +498,37 @@ func dbSpliceSqlOrderGroup(db *gorm.DB, tag tagQueryConditions) *gorm.DB {
 	return db
 }
 
-// dbSpliceSql 拼接sql
-func dbSpliceSqlVal(db *gorm.DB, tag tagQueryConditions, val any) *gorm.DB {
+// dbSpliceSql 拼接sql 使用group=的where条件加入groups 最后统一拼接
+func dbSpliceSqlVal(db *gorm.DB, tag tagQueryConditions, rval reflect.Value, groups *[]*queryGroup) *gorm.DB {
+	val := rval.Interface()
 	if len(tag.Joins) > 0 {
 		if num := strings.Count(tag.Joins, "?"); num > 0 {
-			db = db.Having(tag.Joins, slices.Repeat([]any{val}, num)...)
+			db = db.Joins(tag.Joins, slices.Repeat([]any{val}, num)...)
 		} else if nums := regfmt.FindAllStringIndex(tag.Joins, -1); len(nums) > 0 {
-			db = db.Having(fmt.Sprintf(tag.Joins, slices.Repeat([]any{val}, len(nums))...))
+			db = db.Joins(fmt.Sprintf(tag.Joins, slices.Repeat([]any{val}, len(nums))...))
 		} else {
-			db = db.Having(tag.Joins+" = ?", val)
+			db = db.Joins(tag.Joins)
 		}
 	}
-	if len(tag.Where) > 0 {
-		var wheref func(query any, args ...any) (tx *DB)
-		if tag.Ifc == "or" {
-			wheref = db.Or
-		} else if tag.Ifc == "not" {
-			wheref = db.Not
-		} else {
-			wheref = db.Where
-		}
-		if num := strings.Count(tag.Where, "?"); num > 0 {
-			db = wheref(tag.Where, slices.Repeat([]any{val}, num)...)
-		} else if nums := regfmt.FindAllStringIndex(tag.Where, -1); len(nums) > 0 {
-			db = wheref(fmt.Sprintf(tag.Where, slices.Repeat([]any{val}, len(nums))...))
-		} else {
-			db = wheref(tag.Where+" = ?", val)
+	if len(tag.Where) > 0 || len(tag.Op) > 0 {
+		expr, err := tag.whereExpr(rval)
+		if err != nil {
+			db.AddError(err)
+		} else if expr != nil && len(tag.GroupName) != 0 {
+			idx := slices.IndexFunc(*groups, func(g *queryGroup) bool { return g.name == tag.GroupName })
+			if idx < 0 {
+				*groups = append(*groups, &queryGroup{name: tag.GroupName})
+				idx = len(*groups) - 1
+			}
+			(*groups)[idx].add(tag.Ifc, expr)
+		} else if expr != nil {
+			if tag.Ifc == "or" {
+				db = db.Or(expr)
+			} else if tag.Ifc == "not" {
+				db = db.Not(expr)
+			} else {
+				db = db.Where(expr)
+			}
 		}
 	}
 
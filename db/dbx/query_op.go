@@ -0,0 +1,241 @@
+package dbx
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/wjoj/tool/v2/utils"
+	"gorm.io/gorm/clause"
+)
+
+// tag中op= col= group= 的选项 值只能为字母数字下划线和点
+var regTagOption = regexp.MustCompile(`^\s*(?i:(op|col|group))\s*=\s*([A-Za-z0-9_.]+)\s*$`)
+
+// 条件的操作符 tag为op=xxx
+//
+//	eq ne gt gte lt lte 比较 eq的值为切片时为in
+//	in notin 值为切片或数组
+//	between 值为长度2的切片或数组 一端为零值时为>=或<= 用于时间或数字的范围
+//	like prefix suffix 值为字符串 自动转义%和_
+//	isnull 值为bool true为IS NULL false为IS NOT NULL(false需要使用*bool)
+//	date 值为time.Time 匹配当天(值的时区)
+var queryOps = []string{"eq", "ne", "gt", "gte", "lt", "lte", "in", "notin", "between", "like", "prefix", "suffix", "isnull", "date"}
+
+// likeEscape LIKE使用的转义字符 使用!避免不同数据库对\的处理不同
+const likeEscape = '!'
+
+var likeReplacer = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// EscapeLike 转义LIKE中的通配符 配合 ESCAPE '!' 使用
+func EscapeLike(s string) string {
+	return likeReplacer.Replace(s)
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+func isQueryList(ty reflect.Type) bool {
+	return (ty.Kind() == reflect.Slice || ty.Kind() == reflect.Array) && ty.Elem().Kind() != reflect.Uint8
+}
+
+// checkOpType 检查操作符与字段类型是否匹配 ty为nil时不检查
+func checkOpType(op string, ty reflect.Type) error {
+	if ty == nil {
+		return nil
+	}
+	for ty.Kind() == reflect.Ptr {
+		ty = ty.Elem()
+	}
+	switch op {
+	case "in", "notin":
+		if !isQueryList(ty) {
+			return fmt.Errorf("op=%s requires a slice or array, got %s", op, ty)
+		}
+	case "between":
+		if !isQueryList(ty) || (ty.Kind() == reflect.Array && ty.Len() != 2) {
+			return fmt.Errorf("op=between requires a slice or array of 2 elements, got %s", ty)
+		}
+	case "like", "prefix", "suffix":
+		if ty.Kind() != reflect.String {
+			return fmt.Errorf("op=%s requires a string, got %s", op, ty)
+		}
+	case "isnull":
+		if ty.Kind() != reflect.Bool {
+			return fmt.Errorf("op=isnull requires a bool, got %s", ty)
+		}
+	case "date":
+		if ty != timeType {
+			return fmt.Errorf("op=date requires time.Time, got %s", ty)
+		}
+	}
+	return nil
+}
+
+// opExpr 操作符的条件 返回nil时不加入条件
+func (tag tagQueryConditions) opExpr(val reflect.Value) (clause.Expression, error) {
+	val = reflect.Indirect(val)
+	op := tag.Op
+	if len(op) == 0 || (op == "eq" && isQueryList(val.Type())) {
+		op = "in"
+	}
+	if err := checkOpType(op, val.Type()); err != nil {
+		return nil, fmt.Errorf("%s: %w", tag.FieldName, err)
+	}
+	col := clause.Column{Name: tag.Column}
+	switch op {
+	case "eq":
+		return clause.Eq{Column: col, Value: val.Interface()}, nil
+	case "ne":
+		return clause.Neq{Column: col, Value: val.Interface()}, nil
+	case "gt":
+		return clause.Gt{Column: col, Value: val.Interface()}, nil
+	case "gte":
+		return clause.Gte{Column: col, Value: val.Interface()}, nil
+	case "lt":
+		return clause.Lt{Column: col, Value: val.Interface()}, nil
+	case "lte":
+		return clause.Lte{Column: col, Value: val.Interface()}, nil
+	case "in", "notin":
+		if val.Len() == 0 {
+			return nil, nil
+		}
+		values := make([]any, val.Len())
+		for i := range values {
+			values[i] = val.Index(i).Interface()
+		}
+		if op == "notin" {
+			return clause.Not(clause.IN{Column: col, Values: values}), nil
+		}
+		return clause.IN{Column: col, Values: values}, nil
+	case "between":
+		if val.Len() != 2 {
+			return nil, fmt.Errorf("%s: op=between requires 2 values, got %d", tag.FieldName, val.Len())
+		}
+		lo, hi := reflect.Indirect(val.Index(0)), reflect.Indirect(val.Index(1))
+		switch {
+		case lo.IsValid() && !lo.IsZero() && hi.IsValid() && !hi.IsZero():
+			return clause.Expr{SQL: "? BETWEEN ? AND ?", Vars: []any{col, lo.Interface(), hi.Interface()}}, nil
+		case lo.IsValid() && !lo.IsZero():
+			return clause.Gte{Column: col, Value: lo.Interface()}, nil
+		case hi.IsValid() && !hi.IsZero():
+			return clause.Lte{Column: col, Value: hi.Interface()}, nil
+		}
+		return nil, nil
+	case "like", "prefix", "suffix":
+		pattern := EscapeLike(val.String())
+		switch op {
+		case "like":
+			pattern = "%" + pattern + "%"
+		case "prefix":
+			pattern += "%"
+		default:
+			pattern = "%" + pattern
+		}
+		return clause.Expr{SQL: "? LIKE ? ESCAPE '" + string(likeEscape) + "'", Vars: []any{col, pattern}}, nil
+	case "isnull":
+		if val.Bool() {
+			return clause.Expr{SQL: "? IS NULL", Vars: []any{col}}, nil
+		}
+		return clause.Expr{SQL: "? IS NOT NULL", Vars: []any{col}}, nil
+	case "date":
+		t := val.Interface().(time.Time)
+		start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		return clause.And(clause.Gte{Column: col, Value: start}, clause.Lt{Column: col, Value: start.AddDate(0, 0, 1)}), nil
+	}
+	return nil, fmt.Errorf("%s: unknown op %s", tag.FieldName, tag.Op)
+}
+
+// whereExpr where的条件 sql模板或操作符
+func (tag tagQueryConditions) whereExpr(val reflect.Value) (clause.Expression, error) {
+	for val.Kind() == reflect.Interface {
+		val = val.Elem()
+	}
+	if len(tag.Op) != 0 {
+		return tag.opExpr(val)
+	}
+	v := val.Interface()
+	if num := strings.Count(tag.Where, "?"); num > 0 {
+		return clause.Expr{SQL: tag.Where, Vars: slices.Repeat([]any{v}, num)}, nil
+	} else if nums := regfmt.FindAllStringIndex(tag.Where, -1); len(nums) > 0 {
+		return clause.Expr{SQL: fmt.Sprintf(tag.Where, slices.Repeat([]any{v}, len(nums))...)}, nil
+	} else if isQueryList(reflect.Indirect(val).Type()) {
+		// 没有模板时切片使用in
+		tag.Column = tag.Where
+		return tag.opExpr(val)
+	}
+	return clause.Expr{SQL: tag.Where + " = ?", Vars: []any{v}}, nil
+}
+
+// queryGroup group=xxx的条件 组内按字段的or/and/not组合 整组用括号包裹后以and加入
+type queryGroup struct {
+	name string
+	expr clause.Expression
+}
+
+func (g *queryGroup) add(ifc string, expr clause.Expression) {
+	expr = clause.Expr{SQL: "(?)", Vars: []any{expr}}
+	if ifc == "not" {
+		expr = clause.Not(expr)
+	}
+	switch {
+	case g.expr == nil:
+		g.expr = expr
+	case ifc == "or":
+		g.expr = clause.Or(g.expr, expr)
+	default:
+		g.expr = clause.And(g.expr, expr)
+	}
+}
+
+// ValidateQueryConditions 检查item的query tag 返回所有错误的字段 用于启动时检查
+// 检查操作符 操作符与字段类型 操作符与sql模板是否同时使用 以及group是否只用于where条件
+func ValidateQueryConditions(item any, opts ...QueryConditionOption) error {
+	opt := applyQueryConditionOptions(opts...)
+	ty := reflect.TypeOf(item)
+	for ty != nil && ty.Kind() == reflect.Ptr {
+		ty = ty.Elem()
+	}
+	if ty == nil || ty.Kind() != reflect.Struct {
+		return fmt.Errorf("query conditions must be a struct, got %v", reflect.TypeOf(item))
+	}
+	var errs utils.FieldErrors
+	validateQueryConditions(&errs, "", ty, opt)
+	return errs.Err()
+}
+
+func validateQueryConditions(errs *utils.FieldErrors, prefix string, ty reflect.Type, opt QueryConditionOptions) {
+	for i := range ty.NumField() {
+		field := ty.Field(i)
+		tagVal, is := field.Tag.Lookup(opt.tag)
+		if !is || tagVal == "-" {
+			continue
+		}
+		path := prefix + field.Name
+		fieldty := field.Type
+		if fieldty.Kind() == reflect.Ptr {
+			fieldty = fieldty.Elem()
+		}
+		tag := analyzeQueryConditionsTagVal(tagVal, field.Name, opt.keyClause)
+		if tag.err != nil {
+			errs.Add(path, tag.err.Error())
+			continue
+		}
+		if tag.isNested(fieldty) {
+			validateQueryConditions(errs, path+".", fieldty, opt)
+			continue
+		}
+		if len(tag.Op) == 0 {
+			continue
+		}
+		// 有Value方法时值的类型在运行时确定
+		if _, has := field.Type.MethodByName("Value"); has {
+			continue
+		}
+		if err := checkOpType(tag.Op, field.Type); err != nil {
+			errs.Add(path, err.Error())
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package dbx
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wjoj/tool/v2/utils"
+	"gorm.io/gorm"
+)
+
+type queryItem struct {
+	ID        int
+	Name      string
+	Status    int
+	DeletedAt *time.Time
+	CreatedAt time.Time
+}
+
+type queryFilter struct {
+	Name      string       `query:"op=like"`
+	Statuses  []int        `query:"col=status"`
+	IDs       []int        `query:"id"`
+	Created   [2]time.Time `query:"op=between;col=created_at"`
+	Day       time.Time    `query:"op=date;col=created_at"`
+	Deleted   *bool        `query:"op=isnull;col=deleted_at"`
+	Keyword   string       `query:"op=prefix;col=name;group=kw"`
+	KeywordID int          `query:"id;or;group=kw"`
+}
+
+func TestQueryConditions(t *testing.T) {
+	gdb := newSQLite(t)
+	sqlOf := func(filter any) string {
+		return gdb.ToSQL(func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&queryItem{}).Scopes(QueryConditions(filter)).Find(&[]queryItem{})
+		})
+	}
+	day := time.Date(2024, 5, 6, 13, 0, 0, 0, time.UTC)
+	deleted := false
+	got := sqlOf(&queryFilter{
+		Name: "50%_off", Statuses: []int{1, 2}, IDs: []int{3, 4}, Created: [2]time.Time{day, {}},
+		Day: day, Deleted: &deleted, Keyword: "ab", KeywordID: 7,
+	})
+	for _, want := range []string{
+		"`name` LIKE \"%50!%!_off%\" ESCAPE '!'",
+		"`status` IN (1,2)",
+		"`id` IN (3,4)",
+		"`created_at` >= \"2024-05-06 13:00:00\"",
+		"(`created_at` >= \"2024-05-06 00:00:00\" AND `created_at` < \"2024-05-07 00:00:00\")",
+		"`deleted_at` IS NOT NULL",
+		"AND ((`name` LIKE \"ab%\" ESCAPE '!') OR (id = 7))",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("missing %s in\n%s", want, got)
+		}
+	}
+	got = sqlOf(queryFilter{Statuses: []int{1}, Keyword: "ab"})
+	if !strings.HasSuffix(got, "WHERE `status` = 1 AND (`name` LIKE \"ab%\" ESCAPE '!')") {
+		t.Fatalf("single group %s", got)
+	}
+	type andGroup struct {
+		A int `query:"a;group=g"`
+		B int `query:"b;or;group=g"`
+		C int `query:"c;group=g"`
+		D int `query:"d"`
+	}
+	got = sqlOf(andGroup{A: 1, B: 2, C: 3, D: 4})
+	if !strings.HasSuffix(got, "WHERE d = 4 AND (((a = 1) OR (b = 2)) AND (c = 3))") {
+		t.Fatalf("and group %s", got)
+	}
+	got = sqlOf(queryFilter{Created: [2]time.Time{day, day.Add(time.Hour)}})
+	if !strings.Contains(got, "WHERE `created_at` BETWEEN \"2024-05-06 13:00:00\" AND \"2024-05-06 14:00:00\"") {
+		t.Fatalf("between %s", got)
+	}
+
+	// 转义后的LIKE按字面匹配
+	gdb.AutoMigrate(&queryItem{})
+	gdb.Create([]queryItem{{Name: "50%_off"}, {Name: "500 off"}})
+	var items []queryItem
+	gdb.Scopes(QueryConditions(queryFilter{Name: "0%_"})).Find(&items)
+	if len(items) != 1 || items[0].Name != "50%_off" {
+		t.Fatalf("like %+v", items)
+	}
+
+	type badFilter struct {
+		Name string `query:"op=contains"`
+	}
+	if err := gdb.Scopes(QueryConditions(badFilter{Name: "a"})).Find(&items).Error; err == nil {
+		t.Fatal("unknown op should fail")
+	}
+}
+
+// joins:的语句作为JOIN拼接 不是HAVING 保留原始大小写
+func TestQueryConditionsJoins(t *testing.T) {
+	gdb := newSQLite(t)
+	type joinFilter struct {
+		Status int  `query:"joins:LEFT JOIN orders ON orders.item_id = query_items.id AND orders.status = ?"`
+		Tagged bool `query:"joins:JOIN item_tags ON item_tags.item_id = query_items.id"`
+	}
+	got := gdb.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		return tx.Model(&queryItem{}).Scopes(QueryConditions(joinFilter{Status: 2, Tagged: true})).Find(&[]queryItem{})
+	})
+	for _, want := range []string{
+		"LEFT JOIN orders ON orders.item_id = query_items.id AND orders.status = 2",
+		"JOIN item_tags ON item_tags.item_id = query_items.id",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("missing %s in\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "HAVING") {
+		t.Fatalf("joins as having %s", got)
+	}
+}
+
+func TestValidateQueryConditions(t *testing.T) {
+	if err := ValidateQueryConditions(queryFilter{}); err != nil {
+		t.Fatal(err)
+	}
+	type nested struct {
+		Age string `query:"op=between"`
+	}
+	type badFilter struct {
+		Op     string `query:"op=contains"`
+		Tpl    string `query:"name = ?;op=eq"`
+		In     int    `query:"op=in"`
+		Null   string `query:"op=isnull"`
+		Group  int    `query:"order;group=g"`
+		Nested nested `query:""`
+	}
+	var errs utils.FieldErrors
+	if !errors.As(ValidateQueryConditions(&badFilter{}), &errs) {
+		t.Fatal("expected field errors")
+	}
+	var paths []string
+	for _, e := range errs {
+		paths = append(paths, e.Path)
+	}
+	if strings.Join(paths, ",") != "Op,Tpl,In,Null,Group,Nested.Age" {
+		t.Fatalf("paths %v\n%v", paths, errs)
+	}
+}
@@ -0,0 +1,305 @@
+package dbx
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wjoj/tool/v2/log"
+	"github.com/wjoj/tool/v2/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// PolicyType 副本的选择策略
+type PolicyType string
+
+const (
+	PolicyRandom     PolicyType = "random"     // 按权重随机
+	PolicyRoundRobin PolicyType = "roundRobin" // 按权重轮询
+)
+
+// ReplicaConfig 只读副本 未设置的字段使用主库的配置
+type ReplicaConfig struct {
+	DSN    string `yaml:"dsn" json:"dsn"` //主库使用DSN时必须设置
+	Host   string `yaml:"host" json:"host"`
+	Port   int    `yaml:"port" json:"port"`
+	User   string `yaml:"user" json:"user"`
+	Pass   string `yaml:"password" json:"password"`
+	DbName string `yaml:"dbname" json:"dbname"`
+	Weight int    `yaml:"weight" json:"weight"` //权重 默认1
+}
+
+func (r *ReplicaConfig) validate(primary *Config) error {
+	var errs utils.FieldErrors
+	switch {
+	case len(r.DSN) != 0:
+	case len(primary.DSN) != 0:
+		errs.Add("dsn", "is required when the primary uses dsn")
+	case primary.Driver == "" || primary.Driver == DriverSQLite:
+		if len(r.DbName) == 0 {
+			errs.Add("dbname", "is required")
+		}
+	default:
+		if len(r.Host) == 0 {
+			errs.Add("host", "is required")
+		}
+	}
+	if r.Port < 0 || r.Port > 65535 {
+		errs.Add("port", "must be 1-65535")
+	}
+	if r.Weight < 0 {
+		errs.Add("weight", "must be >= 0")
+	}
+	return errs.Err()
+}
+
+// config 副本的连接配置
+func (r *ReplicaConfig) config(primary *Config) Config {
+	cfg := *primary
+	cfg.Replicas = nil
+	if len(r.DSN) != 0 {
+		cfg.DSN = r.DSN
+	}
+	if len(r.Host) != 0 {
+		cfg.Host, cfg.Port = r.Host, 0
+	}
+	if r.Port != 0 {
+		cfg.Port = r.Port
+	}
+	if len(r.User) != 0 {
+		cfg.User = r.User
+	}
+	if len(r.Pass) != 0 {
+		cfg.Pass = r.Pass
+	}
+	if len(r.DbName) != 0 {
+		cfg.DbName = r.DbName
+	}
+	return cfg
+}
+
+type primaryKey struct{}
+
+// Primary 使用返回的ctx的查询在主库执行 如写入后需要立即读取
+// 例: dbx.Get().WithContext(dbx.Primary(ctx)).First(&user)
+func Primary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func isPrimary(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	is, _ := ctx.Value(primaryKey{}).(bool)
+	return is
+}
+
+type replica struct {
+	name    string
+	pool    *sql.DB
+	weight  int
+	current int //平滑加权轮询的当前权重
+	healthy atomic.Bool
+}
+
+// replicaSet 主库的查询分配到健康的副本 没有健康的副本时使用主库
+// 写入 事务 Connection 和 SELECT ... FOR UPDATE 使用主库
+type replicaSet struct {
+	primary  gorm.ConnPool
+	policy   PolicyType
+	replicas []*replica
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+}
+
+var (
+	replicaMu   sync.Mutex
+	replicaSets = map[*DB]*replicaSet{}
+)
+
+// useReplicas 打开副本 注册查询的路由和副本的健康检查
+func useReplicas(db *DB, cfg *Config) error {
+	rs := &replicaSet{
+		primary: db.ConnPool,
+		policy:  cfg.Policy,
+	}
+	for i, rc := range cfg.Replicas {
+		c := rc.config(cfg)
+		d, err := dialector(&c)
+		if err != nil {
+			rs.close()
+			return err
+		}
+		rdb, err := gorm.Open(d, &gorm.Config{Logger: logger.Discard, DisableAutomaticPing: true})
+		if err != nil {
+			rs.close()
+			return fmt.Errorf("数据库副本链接错误: %v", err)
+		}
+		pool, err := rdb.DB()
+		if err != nil {
+			rs.close()
+			return err
+		}
+		setPool(pool, cfg)
+		r := &replica{
+			name:   fmt.Sprintf("%s:%d/%s", c.Host, c.Port, c.DbName),
+			pool:   pool,
+			weight: max(rc.Weight, 1),
+		}
+		if c.Driver == "" || c.Driver == DriverSQLite {
+			r.name = fmt.Sprintf("replicas[%d]:%s", i, c.DbName)
+		}
+		rs.replicas = append(rs.replicas, r)
+	}
+	interval := cfg.ReplicaCheckInterval
+	if interval == 0 {
+		interval = 5 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	rs.cancel = cancel
+	rs.check(ctx, interval)
+	go rs.watch(ctx, interval)
+
+	if err := db.Callback().Query().Before("gorm:query").Register("dbx:replica", rs.route); err != nil {
+		rs.close()
+		return err
+	}
+	if err := db.Callback().Row().Before("gorm:row").Register("dbx:replica", rs.route); err != nil {
+		rs.close()
+		return err
+	}
+	replicaMu.Lock()
+	replicaSets[db] = rs
+	replicaMu.Unlock()
+	return nil
+}
+
+// route 在gorm:query和gorm:row之前执行 修改查询使用的连接池
+func (rs *replicaSet) route(db *gorm.DB) {
+	stmt := db.Statement
+	// ConnPool不是主库的连接池时为事务或指定的连接
+	if db.Error != nil || stmt.ConnPool != rs.primary || isPrimary(stmt.Context) {
+		return
+	}
+	if _, is := stmt.Clauses["FOR"]; is {
+		return
+	}
+	// Raw的sql已生成 只有SELECT分配到副本
+	if sql := strings.TrimSpace(stmt.SQL.String()); len(sql) != 0 && !strings.EqualFold(firstWord(sql), "select") {
+		return
+	}
+	if r := rs.pick(); r != nil {
+		stmt.ConnPool = r.pool
+	}
+}
+
+func firstWord(s string) string {
+	if i := strings.IndexAny(s, " \t\r\n("); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// pick 按策略选择健康的副本 没有时返回nil
+func (rs *replicaSet) pick() *replica {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	total := 0
+	var best *replica
+	for _, r := range rs.replicas {
+		if !r.healthy.Load() {
+			continue
+		}
+		total += r.weight
+		if rs.policy == PolicyRoundRobin {
+			r.current += r.weight
+			if best == nil || r.current > best.current {
+				best = r
+			}
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	if rs.policy == PolicyRoundRobin {
+		best.current -= total
+		return best
+	}
+	n := rand.IntN(total)
+	for _, r := range rs.replicas {
+		if !r.healthy.Load() {
+			continue
+		}
+		if n < r.weight {
+			return r
+		}
+		n -= r.weight
+	}
+	return nil
+}
+
+func (rs *replicaSet) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rs.check(ctx, interval)
+		}
+	}
+}
+
+// check ping所有副本 失败的副本不再分配查询 直到恢复
+func (rs *replicaSet) check(ctx context.Context, timeout time.Duration) {
+	for _, r := range rs.replicas {
+		pctx, cancel := context.WithTimeout(ctx, timeout)
+		err := r.pool.PingContext(pctx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		healthy := err == nil
+		if r.healthy.Swap(healthy) == healthy {
+			continue
+		}
+		if healthy {
+			log.Infof("db replica %s recovered", r.name)
+		} else {
+			log.Warnf("db replica %s removed: %v", r.name, err)
+		}
+	}
+}
+
+func (rs *replicaSet) setPool(cfg *Config) {
+	for _, r := range rs.replicas {
+		setPool(r.pool, cfg)
+	}
+}
+
+func (rs *replicaSet) close() {
+	if rs.cancel != nil {
+		rs.cancel()
+	}
+	for _, r := range rs.replicas {
+		r.pool.Close()
+	}
+}
+
+// closeReplicas 关闭db的副本
+func closeReplicas(db *DB) {
+	replicaMu.Lock()
+	rs, is := replicaSets[db]
+	delete(replicaSets, db)
+	replicaMu.Unlock()
+	if is {
+		rs.close()
+	}
+}
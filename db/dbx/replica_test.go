@@ -0,0 +1,118 @@
+package dbx
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type replicaUser struct {
+	ID   int
+	Name string
+}
+
+func TestReplicas(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{
+		Driver:               DriverSQLite,
+		DbName:               filepath.Join(dir, "primary"),
+		LogName:              "--",
+		LogLevel:             LogLevelSilent,
+		Policy:               PolicyRoundRobin,
+		ReplicaCheckInterval: 20 * time.Millisecond,
+	}
+	// 每个库的数据不同 用于判断查询使用的库
+	for _, name := range []string{"primary", "r1", "r2"} {
+		c := *cfg
+		c.DbName = filepath.Join(dir, name)
+		c.Replicas = nil
+		gdb, err := New(&c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gdb.AutoMigrate(&replicaUser{})
+		gdb.Create(&replicaUser{ID: 1, Name: name})
+		dc, _ := gdb.DB()
+		dc.Close()
+	}
+	cfg.Replicas = []ReplicaConfig{
+		{DbName: filepath.Join(dir, "r1"), Weight: 2},
+		{DbName: filepath.Join(dir, "r2")},
+	}
+	gdb, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		closeReplicas(gdb)
+		dc, _ := gdb.DB()
+		dc.Close()
+	}()
+	name := func(tx *gorm.DB) string {
+		var u replicaUser
+		if err := tx.First(&u, 1).Error; err != nil {
+			t.Fatal(err)
+		}
+		return u.Name
+	}
+
+	counts := map[string]int{}
+	for range 6 {
+		counts[name(gdb)]++
+	}
+	if counts["r1"] != 4 || counts["r2"] != 2 {
+		t.Fatalf("round robin %v", counts)
+	}
+	var raw string
+	gdb.Raw("SELECT name FROM replica_user WHERE id = 1").Scan(&raw)
+	if raw == "primary" {
+		t.Fatal("raw select should use a replica")
+	}
+	if n := name(gdb.WithContext(Primary(context.Background()))); n != "primary" {
+		t.Fatalf("Primary(ctx) used %s", n)
+	}
+	gdb.Transaction(func(tx *gorm.DB) error {
+		if n := name(tx); n != "primary" {
+			t.Fatalf("transaction used %s", n)
+		}
+		return nil
+	})
+	// 写入使用主库
+	if err := gdb.Create(&replicaUser{ID: 2, Name: "new"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	var cnt int64
+	gdb.WithContext(Primary(context.Background())).Model(&replicaUser{}).Count(&cnt)
+	if cnt != 2 {
+		t.Fatalf("primary count %d", cnt)
+	}
+
+	// 副本不可用时不再分配查询 全部不可用时使用主库
+	rs := replicaSets[gdb]
+	rs.replicas[0].pool.Close()
+	waitReplica(t, func() bool { return !rs.replicas[0].healthy.Load() })
+	for range 3 {
+		if n := name(gdb); n != "r2" {
+			t.Fatalf("unhealthy replica used: %s", n)
+		}
+	}
+	rs.replicas[1].pool.Close()
+	waitReplica(t, func() bool { return !rs.replicas[1].healthy.Load() })
+	if n := name(gdb); n != "primary" {
+		t.Fatalf("fallback used %s", n)
+	}
+}
+
+func waitReplica(t *testing.T, fn func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !fn() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for replica check")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
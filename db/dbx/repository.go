@@ -0,0 +1,234 @@
+package dbx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/wjoj/tool/v2/pagination"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// ErrVersionConflict 乐观锁的版本不一致 记录已被其他请求修改
+var ErrVersionConflict = errors.New("version conflict")
+
+type repositoryOptions struct {
+	key     string
+	version string
+	opts    []QueryConditionOption
+	orders  []pagination.Order
+}
+
+type RepositoryOption func(o *repositoryOptions)
+
+// WithKeysetRepositoryOption List使用游标分页 orders为排序的列 组合需要唯一
+func WithKeysetRepositoryOption(orders ...pagination.Order) RepositoryOption {
+	return func(o *repositoryOptions) {
+		o.orders = orders
+	}
+}
+
+// WithKeyRepositoryOption 使用的db 默认为默认key
+func WithKeyRepositoryOption(key string) RepositoryOption {
+	return func(o *repositoryOptions) {
+		o.key = key
+	}
+}
+
+// WithVersionRepositoryOption 乐观锁的字段 字段名或列名 默认Version 模型没有该字段时不使用乐观锁
+func WithVersionRepositoryOption(field string) RepositoryOption {
+	return func(o *repositoryOptions) {
+		o.version = field
+	}
+}
+
+// WithQueryConditionsRepositoryOption List Count Exists的过滤条件使用的QueryConditions选项
+func WithQueryConditionsRepositoryOption(opts ...QueryConditionOption) RepositoryOption {
+	return func(o *repositoryOptions) {
+		o.opts = opts
+	}
+}
+
+// Repository 模型T的增删改查 ctx中有InTx的事务时在事务中执行
+// 过滤条件filter为QueryConditions使用的结构体
+type Repository[T any] struct {
+	opt repositoryOptions
+}
+
+// NewRepository 创建模型T的Repository
+func NewRepository[T any](options ...RepositoryOption) *Repository[T] {
+	opt := repositoryOptions{
+		version: "Version",
+	}
+	for _, option := range options {
+		if option != nil {
+			option(&opt)
+		}
+	}
+	return &Repository[T]{opt: opt}
+}
+
+// DB 返回模型T的查询 用于Repository没有提供的操作
+func (r *Repository[T]) DB(ctx context.Context) *DB {
+	return FromContext(ctx, r.opt.key).Model(new(T))
+}
+
+func (r *Repository[T]) filter(filter any, opts []QueryConditionOption) func(*gorm.DB) *gorm.DB {
+	if filter == nil {
+		return func(db *gorm.DB) *gorm.DB { return db }
+	}
+	return QueryConditions(filter, append(append([]QueryConditionOption{}, r.opt.opts...), opts...)...)
+}
+
+// Get 按主键查询 不存在时返回gorm.ErrRecordNotFound
+func (r *Repository[T]) Get(ctx context.Context, id any) (*T, error) {
+	var item T
+	err := r.DB(ctx).Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).First(&item).Error
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// List 按过滤条件分页查询 设置了WithKeysetRepositoryOption时使用游标分页
+func (r *Repository[T]) List(ctx context.Context, filter any, req pagination.Request, opts ...QueryConditionOption) (pagination.Response[T], error) {
+	return FindPage[T](r.DB(ctx).Scopes(r.filter(filter, opts)), req, r.opt.orders...)
+}
+
+// Count 按过滤条件计数
+func (r *Repository[T]) Count(ctx context.Context, filter any, opts ...QueryConditionOption) (int64, error) {
+	var total int64
+	err := r.DB(ctx).Scopes(r.filter(filter, opts)).Count(&total).Error
+	return total, err
+}
+
+// Exists 是否存在满足过滤条件的记录
+func (r *Repository[T]) Exists(ctx context.Context, filter any, opts ...QueryConditionOption) (bool, error) {
+	var ones []int
+	err := r.DB(ctx).Scopes(r.filter(filter, opts)).Select("1").Limit(1).Find(&ones).Error
+	return len(ones) != 0, err
+}
+
+// Create 创建记录 使用乐观锁时版本为0的记录版本设为1
+func (r *Repository[T]) Create(ctx context.Context, item *T) error {
+	tx := r.DB(ctx)
+	vf, err := r.versionField(tx, item)
+	if err != nil {
+		return err
+	}
+	if vf != nil {
+		rv := reflect.ValueOf(item).Elem()
+		if _, zero := vf.ValueOf(ctx, rv); zero {
+			if err := vf.Set(ctx, rv, 1); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Create(item).Error
+}
+
+// Upsert 创建记录 columns冲突时更新所有字段 columns为空时使用主键 不检查乐观锁的版本
+func (r *Repository[T]) Upsert(ctx context.Context, item *T, columns ...string) error {
+	onConflict := clause.OnConflict{UpdateAll: true}
+	for _, column := range columns {
+		onConflict.Columns = append(onConflict.Columns, clause.Column{Name: column})
+	}
+	return r.DB(ctx).Clauses(onConflict).Create(item).Error
+}
+
+// Update 按item的主键更新partial中的字段 partial为map[string]any或结构体(只更新非零值的字段)
+// 使用乐观锁时只更新版本与item相同的记录并增加版本 版本不一致时返回ErrVersionConflict
+func (r *Repository[T]) Update(ctx context.Context, item *T, partial any) error {
+	tx := FromContext(ctx, r.opt.key).Model(item)
+	vf, err := r.versionField(tx, item)
+	if err != nil {
+		return err
+	}
+	values, err := updateValues(ctx, tx, partial)
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	if vf == nil {
+		return tx.Updates(values).Error
+	}
+	rv := reflect.ValueOf(item).Elem()
+	version, _ := vf.ValueOf(ctx, rv)
+	delete(values, vf.Name)
+	values[vf.DBName] = gorm.Expr("? + 1", clause.Column{Name: vf.DBName})
+	res := tx.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: vf.DBName}, Value: version}).Updates(values)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrVersionConflict
+	}
+	next := reflect.ValueOf(version).Convert(reflect.TypeFor[int64]()).Int() + 1
+	return vf.Set(ctx, rv, next)
+}
+
+// Delete 按主键删除 不存在时返回gorm.ErrRecordNotFound
+func (r *Repository[T]) Delete(ctx context.Context, id any) error {
+	res := r.DB(ctx).Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).Delete(new(T))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// versionField 乐观锁的字段 模型没有该字段时返回nil
+func (r *Repository[T]) versionField(tx *gorm.DB, item *T) (*schema.Field, error) {
+	if len(r.opt.version) == 0 {
+		return nil, nil
+	}
+	if err := tx.Statement.Parse(item); err != nil {
+		return nil, err
+	}
+	field := tx.Statement.Schema.LookUpField(r.opt.version)
+	if field == nil {
+		return nil, nil
+	}
+	switch field.FieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return field, nil
+	}
+	return nil, fmt.Errorf("version field %s must be an integer", field.Name)
+}
+
+var partialSchemas sync.Map
+
+// updateValues partial转换为列名对应的值 结构体只包含非零值的字段
+func updateValues(ctx context.Context, tx *gorm.DB, partial any) (map[string]any, error) {
+	if m, is := partial.(map[string]any); is {
+		values := make(map[string]any, len(m))
+		for k, v := range m {
+			values[k] = v
+		}
+		return values, nil
+	}
+	s, err := schema.Parse(partial, &partialSchemas, tx.NamingStrategy)
+	if err != nil {
+		return nil, fmt.Errorf("update partial: %w", err)
+	}
+	rv := reflect.Indirect(reflect.ValueOf(partial))
+	values := map[string]any{}
+	for _, field := range s.Fields {
+		if len(field.DBName) == 0 || field.PrimaryKey || !field.Updatable {
+			continue
+		}
+		if v, zero := field.ValueOf(ctx, rv); !zero {
+			values[field.DBName] = v
+		}
+	}
+	return values, nil
+}
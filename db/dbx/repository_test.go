@@ -0,0 +1,105 @@
+package dbx
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/wjoj/tool/v2/pagination"
+	"gorm.io/gorm"
+)
+
+type repoUser struct {
+	ID      int
+	Name    string
+	Age     int
+	Version int
+}
+
+type repoUserFilter struct {
+	Name string `query:"name like '%%%v%%'"`
+	Age  int    `query:"age >= ?;order desc"`
+}
+
+func TestRepository(t *testing.T) {
+	gdb := newSQLite(t)
+	oldDbs := dbs
+	dbs = map[string]*DB{"repo": gdb}
+	t.Cleanup(func() { dbs = oldDbs })
+	if err := gdb.AutoMigrate(&repoUser{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	repo := NewRepository[repoUser](WithKeyRepositoryOption("repo"))
+
+	for i, name := range []string{"alice", "bob", "alina", "carl"} {
+		if err := repo.Create(ctx, &repoUser{Name: name, Age: 20 + i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	u, err := repo.Get(ctx, 1)
+	if err != nil || u.Name != "alice" || u.Version != 1 {
+		t.Fatalf("get %+v %v", u, err)
+	}
+	if _, err := repo.Get(ctx, 99); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("get missing %v", err)
+	}
+
+	res, err := repo.List(ctx, &repoUserFilter{Name: "al"}, pagination.Request{Page: 1, Size: 1, Total: true})
+	if err != nil || *res.Total != 2 || len(res.Items) != 1 || res.Items[0].Name != "alina" || !res.HasMore {
+		t.Fatalf("list %+v %v", res, err)
+	}
+	if res, _ = repo.List(ctx, nil, pagination.Request{}); len(res.Items) != 4 {
+		t.Fatalf("list all %+v", res)
+	}
+	if n, _ := repo.Count(ctx, repoUserFilter{Age: 22}); n != 2 {
+		t.Fatalf("count %d", n)
+	}
+	if is, _ := repo.Exists(ctx, repoUserFilter{Name: "zed"}); is {
+		t.Fatal("exists zed")
+	}
+	if is, _ := repo.Exists(ctx, repoUserFilter{Name: "bo"}); !is {
+		t.Fatal("not exists bob")
+	}
+
+	// 乐观锁
+	stale := *u
+	if err := repo.Update(ctx, u, map[string]any{"name": "alice2"}); err != nil {
+		t.Fatal(err)
+	}
+	if u.Version != 2 {
+		t.Fatalf("version %d", u.Version)
+	}
+	if err := repo.Update(ctx, &stale, repoUser{Age: 50}); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("stale update %v", err)
+	}
+	if err := repo.Update(ctx, u, repoUser{Age: 50}); err != nil {
+		t.Fatal(err)
+	}
+	u, _ = repo.Get(ctx, 1)
+	if u.Name != "alice2" || u.Age != 50 || u.Version != 3 {
+		t.Fatalf("updated %+v", u)
+	}
+
+	// 事务中回滚
+	InTx(ctx, func(ctx context.Context) error {
+		repo.Create(ctx, &repoUser{ID: 10, Name: "tx"})
+		return errors.New("rollback")
+	}, WithKeyTxOption("repo"))
+	if _, err := repo.Get(ctx, 10); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("tx rollback %v", err)
+	}
+
+	if err := repo.Upsert(ctx, &repoUser{ID: 2, Name: "bobby", Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := repo.Get(ctx, 2); u.Name != "bobby" {
+		t.Fatalf("upsert %+v", u)
+	}
+	if err := repo.Delete(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Delete(ctx, 2); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("delete missing %v", err)
+	}
+}
@@ -0,0 +1,531 @@
+package dbx
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wjoj/tool/v2/log"
+	"github.com/wjoj/tool/v2/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// ErrNoTenant ctx中没有租户 且没有使用SkipTenant
+var ErrNoTenant = errors.New("tenant not found in context")
+
+// ErrUnscopedRaw column模式下Raw和Exec的sql使用了租户表但没有租户列的条件
+var ErrUnscopedRaw = errors.New("raw sql on tenant table is not scoped by the tenant column")
+
+// TenantMode 租户的隔离方式
+type TenantMode string
+
+const (
+	TenantModeColumn   TenantMode = "column"   // 共享表 按租户列过滤和写入
+	TenantModeDatabase TenantMode = "database" // 每个租户一个数据库
+)
+
+// TenantConfig 多租户配置
+type TenantConfig struct {
+	Mode     TenantMode `yaml:"mode" json:"mode"`
+	Column   string     `yaml:"column" json:"column"`     //column模式的租户列 默认tenant_id
+	DbName   string     `yaml:"dbname" json:"dbname"`     //database模式没有注册TenantResolver时租户的库名 {tenant}替换为租户 如app_{tenant}
+	MaxPools int        `yaml:"maxPools" json:"maxPools"` //database模式最多保持的租户连接池 超过时淘汰最久未使用的 淘汰的连接池空闲后关闭 默认16
+}
+
+func (c *TenantConfig) validate() error {
+	var errs utils.FieldErrors
+	switch c.Mode {
+	case TenantModeColumn:
+	case TenantModeDatabase:
+		if len(c.DbName) != 0 && !strings.Contains(c.DbName, "{tenant}") {
+			errs.Add("dbname", "must contain {tenant}")
+		}
+	default:
+		errs.Add("mode", fmt.Sprintf("must be %s or %s", TenantModeColumn, TenantModeDatabase))
+	}
+	if c.MaxPools < 0 {
+		errs.Add("maxPools", "must be >= 0")
+	}
+	return errs.Err()
+}
+
+type tenantKey struct{}
+type skipTenantKey struct{}
+
+// WithTenant ctx中设置租户
+func WithTenant(ctx context.Context, tenant any) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom ctx中的租户
+func TenantFrom(ctx context.Context) (any, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	tenant := ctx.Value(tenantKey{})
+	return tenant, tenant != nil
+}
+
+// SkipTenant 不检查租户 用于后台任务和跨租户的查询
+func SkipTenant(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipTenantKey{}, true)
+}
+
+func isSkipTenant(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	skip, _ := ctx.Value(skipTenantKey{}).(bool)
+	return skip
+}
+
+// tenantPlugin column模式的gorm插件 模型有租户列时查询 更新 删除加上租户条件 创建时写入租户
+// Raw和Exec的sql不会加上条件 sql使用了租户表但没有租户列时返回ErrUnscopedRaw
+// 租户表为Model的表和已经通过模型使用过的有租户列的表 跨租户的sql使用SkipTenant
+type tenantPlugin struct {
+	column   string
+	columnRe *regexp.Regexp
+	tables   sync.Map //有租户列的表 值为匹配表名的正则
+}
+
+// sqlIdent 匹配sql中的表名或列名 不匹配其他名称的一部分
+func sqlIdent(name string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)(^|[^\w$])` + regexp.QuoteMeta(name) + `($|[^\w$])`)
+}
+
+func (p *tenantPlugin) Name() string {
+	return "dbx:tenant"
+}
+
+func (p *tenantPlugin) Initialize(db *gorm.DB) error {
+	p.columnRe = sqlIdent(p.column)
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().Before("gorm:create").Register("dbx:tenant", p.create),
+		cb.Query().Before("gorm:query").Register("dbx:tenant", p.where),
+		cb.Row().Before("gorm:row").Register("dbx:tenant", p.where),
+		cb.Raw().Before("gorm:raw").Register("dbx:tenant", p.raw),
+		cb.Update().Before("gorm:update").Register("dbx:tenant", p.where),
+		cb.Delete().Before("gorm:delete").Register("dbx:tenant", p.where),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// field 模型的租户列 没有时不处理
+func (p *tenantPlugin) field(db *gorm.DB) *schema.Field {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return nil
+	}
+	field := db.Statement.Schema.LookUpField(p.column)
+	if field != nil {
+		table := db.Statement.Schema.Table
+		if _, is := p.tables.Load(table); !is {
+			p.tables.Store(table, sqlIdent(table))
+		}
+	}
+	return field
+}
+
+func (p *tenantPlugin) tenant(db *gorm.DB, table string) (any, bool) {
+	ctx := db.Statement.Context
+	if isSkipTenant(ctx) {
+		return nil, false
+	}
+	tenant, is := TenantFrom(ctx)
+	if !is {
+		db.AddError(fmt.Errorf("%w: %s", ErrNoTenant, table))
+	}
+	return tenant, is
+}
+
+// raw Raw和Exec的sql使用了租户表时需要包含租户列
+func (p *tenantPlugin) raw(db *gorm.DB) {
+	if db.Error != nil || isSkipTenant(db.Statement.Context) {
+		return
+	}
+	p.field(db)
+	sql := db.Statement.SQL.String()
+	var table string
+	p.tables.Range(func(key, value any) bool {
+		if value.(*regexp.Regexp).MatchString(sql) {
+			table = key.(string)
+			return false
+		}
+		return true
+	})
+	if len(table) == 0 {
+		return
+	}
+	if _, is := p.tenant(db, table); !is {
+		return
+	}
+	if !p.columnRe.MatchString(sql) {
+		db.AddError(fmt.Errorf("%w: %s", ErrUnscopedRaw, table))
+	}
+}
+
+func (p *tenantPlugin) where(db *gorm.DB) {
+	if db.Statement.SQL.Len() != 0 {
+		// db.Raw().Scan()等
+		p.raw(db)
+		return
+	}
+	field := p.field(db)
+	if field == nil {
+		return
+	}
+	if tenant, is := p.tenant(db, db.Statement.Schema.Table); is {
+		db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+			clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: tenant},
+		}})
+	}
+}
+
+func (p *tenantPlugin) create(db *gorm.DB) {
+	field := p.field(db)
+	if field == nil {
+		return
+	}
+	tenant, is := p.tenant(db, db.Statement.Schema.Table)
+	if !is {
+		return
+	}
+	ctx, rv := db.Statement.Context, db.Statement.ReflectValue
+	set := func(rv reflect.Value) {
+		if v, zero := field.ValueOf(ctx, rv); !zero && fmt.Sprint(v) != fmt.Sprint(tenant) {
+			db.AddError(fmt.Errorf("tenant mismatch: %v is not %v", v, tenant))
+			return
+		}
+		if err := field.Set(ctx, rv, tenant); err != nil {
+			db.AddError(err)
+		}
+	}
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := range rv.Len() {
+			set(reflect.Indirect(rv.Index(i)))
+		}
+	case reflect.Struct:
+		set(rv)
+	}
+}
+
+// tenantGuardPlugin database模式的key的连接池只用于SkipTenant的操作
+// 没有通过FromContext和InTx选择租户的连接池时返回ErrNoTenant
+type tenantGuardPlugin struct{}
+
+func (p *tenantGuardPlugin) Name() string {
+	return "dbx:tenant_guard"
+}
+
+func (p *tenantGuardPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().Before("*").Register("dbx:tenant_guard", p.check),
+		cb.Query().Before("*").Register("dbx:tenant_guard", p.check),
+		cb.Row().Before("*").Register("dbx:tenant_guard", p.check),
+		cb.Raw().Before("*").Register("dbx:tenant_guard", p.check),
+		cb.Update().Before("*").Register("dbx:tenant_guard", p.check),
+		cb.Delete().Before("*").Register("dbx:tenant_guard", p.check),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *tenantGuardPlugin) check(db *gorm.DB) {
+	if !isSkipTenant(db.Statement.Context) {
+		db.AddError(fmt.Errorf("%w: use FromContext or InTx to select the tenant db", ErrNoTenant))
+	}
+}
+
+// TenantResolver 返回租户的数据库配置 用于database模式
+type TenantResolver func(ctx context.Context, tenant string) (Config, error)
+
+var (
+	tenantMu    sync.Mutex
+	tenantPools = map[string]*tenantPool{}
+)
+
+// RegisterTenantResolver 注册key对应的database模式的租户配置 没有注册时使用TenantConfig.DbName
+func RegisterTenantResolver(key string, resolver TenantResolver) {
+	tenantMu.Lock()
+	defer tenantMu.Unlock()
+	if pool, is := tenantPools[key]; is {
+		pool.mu.Lock()
+		pool.resolver = resolver
+		pool.mu.Unlock()
+		return
+	}
+	tenantPools[key] = &tenantPool{resolver: resolver}
+}
+
+// tenantPool database模式的租户连接池 按最近使用淘汰
+type tenantPool struct {
+	mu       sync.Mutex
+	base     Config
+	dbName   string
+	max      int
+	resolver TenantResolver
+	lru      *list.List
+	items    map[string]*list.Element
+	evicted  []*tenantEntry //淘汰后还没有关闭的连接池
+	reaper   *time.Timer
+}
+
+type tenantEntry struct {
+	tenant string
+	db     *DB
+	refs   int       //InTx中正在使用的数量
+	used   time.Time //最后一次获取的时间
+}
+
+// tenantIdleWait 淘汰的连接池最后一次获取后至少等待的时间 FromContext返回的db没有引用计数 等待期间可以继续使用
+var tenantIdleWait = time.Minute
+
+// idle 没有引用 超过等待时间并且没有使用中的连接 调用方持有mu
+func (e *tenantEntry) idle() bool {
+	if e.refs > 0 || time.Since(e.used) < tenantIdleWait {
+		return false
+	}
+	dc, err := e.db.DB()
+	return err != nil || dc.Stats().InUse == 0
+}
+
+var regTenantName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// useTenantPool Init时为database模式的key创建连接池
+func useTenantPool(key string, cfg *Config) {
+	tenantMu.Lock()
+	defer tenantMu.Unlock()
+	pool, is := tenantPools[key]
+	if !is {
+		pool = &tenantPool{}
+		tenantPools[key] = pool
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.base = *cfg
+	pool.base.Tenant, pool.base.Replicas = nil, nil
+	pool.dbName = cfg.Tenant.DbName
+	pool.max = cfg.Tenant.MaxPools
+	if pool.max == 0 {
+		pool.max = 16
+	}
+	pool.lru = list.New()
+	pool.items = map[string]*list.Element{}
+}
+
+func (p *tenantPool) active() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lru != nil
+}
+
+func (p *tenantPool) config(ctx context.Context, tenant string) (Config, error) {
+	p.mu.Lock()
+	resolver, base, dbName := p.resolver, p.base, p.dbName
+	p.mu.Unlock()
+	if resolver != nil {
+		cfg, err := resolver(ctx, tenant)
+		cfg.Tenant = nil
+		return cfg, err
+	}
+	if len(dbName) == 0 {
+		return Config{}, errors.New("tenant resolver is not registered")
+	}
+	if !regTenantName.MatchString(tenant) {
+		return Config{}, fmt.Errorf("invalid tenant %q", tenant)
+	}
+	base.DbName = strings.ReplaceAll(dbName, "{tenant}", tenant)
+	return base, nil
+}
+
+// acquire 返回租户的连接池 没有时创建 超过数量时淘汰最久未使用的
+// 使用完成后调用release 淘汰的连接池在release并且空闲后关闭
+func (p *tenantPool) acquire(ctx context.Context, tenant string) (*DB, func(), error) {
+	p.mu.Lock()
+	entry := p.lookup(tenant)
+	p.mu.Unlock()
+	if entry == nil {
+		cfg, err := p.config(ctx, tenant)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tenant %s: %w", tenant, err)
+		}
+		gdb, err := New(&cfg)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tenant %s: %w", tenant, err)
+		}
+		if entry, err = p.add(tenant, gdb); err != nil {
+			return nil, nil, err
+		}
+	}
+	return entry.db, func() {
+		p.mu.Lock()
+		entry.refs--
+		p.mu.Unlock()
+	}, nil
+}
+
+// lookup 使用中或淘汰后还没有关闭的连接池 调用方持有mu
+func (p *tenantPool) lookup(tenant string) *tenantEntry {
+	if e, is := p.items[tenant]; is {
+		p.lru.MoveToFront(e)
+		entry := e.Value.(*tenantEntry)
+		entry.refs++
+		entry.used = time.Now()
+		return entry
+	}
+	for i, entry := range p.evicted {
+		if entry.tenant == tenant {
+			p.evicted = append(p.evicted[:i], p.evicted[i+1:]...)
+			p.items[tenant] = p.lru.PushFront(entry)
+			entry.refs++
+			entry.used = time.Now()
+			p.evict()
+			return entry
+		}
+	}
+	return nil
+}
+
+func (p *tenantPool) add(tenant string, gdb *DB) (*tenantEntry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lru == nil {
+		closeDB(gdb)
+		return nil, fmt.Errorf("tenant %s: pools are closed", tenant)
+	}
+	if entry := p.lookup(tenant); entry != nil {
+		// 并发创建时使用先创建的
+		closeDB(gdb)
+		return entry, nil
+	}
+	entry := &tenantEntry{tenant: tenant, db: gdb, refs: 1, used: time.Now()}
+	p.items[tenant] = p.lru.PushFront(entry)
+	p.evict()
+	return entry, nil
+}
+
+// evict 超过数量时淘汰最久未使用的 淘汰的连接池可能还在使用 空闲后关闭 调用方持有mu
+func (p *tenantPool) evict() {
+	for p.lru.Len() > p.max {
+		entry := p.lru.Remove(p.lru.Back()).(*tenantEntry)
+		delete(p.items, entry.tenant)
+		p.evicted = append(p.evicted, entry)
+		log.Infof("db tenant %s pool evicted", entry.tenant)
+	}
+	if len(p.evicted) != 0 && p.reaper == nil {
+		p.reaper = time.AfterFunc(tenantIdleWait, p.reap)
+	}
+}
+
+// reap 关闭空闲的淘汰连接池 还有没关闭的时继续等待
+func (p *tenantPool) reap() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reaper = nil
+	evicted := p.evicted[:0]
+	for _, entry := range p.evicted {
+		if !entry.idle() {
+			evicted = append(evicted, entry)
+			continue
+		}
+		closeDB(entry.db)
+		log.Infof("db tenant %s pool closed", entry.tenant)
+	}
+	clear(p.evicted[len(evicted):])
+	p.evicted = evicted
+	if len(p.evicted) != 0 {
+		p.reaper = time.AfterFunc(tenantIdleWait, p.reap)
+	}
+}
+
+func (p *tenantPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.reaper != nil {
+		p.reaper.Stop()
+		p.reaper = nil
+	}
+	for _, entry := range p.evicted {
+		closeDB(entry.db)
+	}
+	p.evicted = nil
+	if p.lru == nil {
+		return
+	}
+	for e := p.lru.Front(); e != nil; e = e.Next() {
+		closeDB(e.Value.(*tenantEntry).db)
+	}
+	p.lru.Init()
+	p.items = map[string]*list.Element{}
+}
+
+func closeDB(gdb *DB) {
+	if dc, err := gdb.DB(); err == nil {
+		dc.Close()
+	}
+}
+
+// resetTenants 关闭所有租户连接池 保留注册的TenantResolver 在Init时调用
+func resetTenants() {
+	tenantMu.Lock()
+	defer tenantMu.Unlock()
+	for _, pool := range tenantPools {
+		pool.close()
+		pool.mu.Lock()
+		pool.lru = nil
+		pool.mu.Unlock()
+	}
+}
+
+// closeTenants 关闭key的所有租户连接池
+func closeTenants(key string) {
+	tenantMu.Lock()
+	pool, is := tenantPools[key]
+	tenantMu.Unlock()
+	if is {
+		pool.close()
+	}
+}
+
+// tenantDB key对应的db database模式时返回ctx中租户的db 没有租户时返回带ErrNoTenant的db
+// 使用完成后调用release
+func tenantDB(ctx context.Context, key string) (*DB, func()) {
+	base := Get(key)
+	tenantMu.Lock()
+	pool, is := tenantPools[key]
+	tenantMu.Unlock()
+	if !is || !pool.active() || isSkipTenant(ctx) {
+		return base.WithContext(ctx), func() {}
+	}
+	fail := func(err error) (*DB, func()) {
+		tx := base.WithContext(ctx)
+		tx.AddError(err)
+		return tx, func() {}
+	}
+	tenant, has := TenantFrom(ctx)
+	if !has {
+		return fail(ErrNoTenant)
+	}
+	gdb, release, err := pool.acquire(ctx, fmt.Sprint(tenant))
+	if err != nil {
+		return fail(err)
+	}
+	return gdb.WithContext(ctx), release
+}
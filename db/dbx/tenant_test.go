@@ -0,0 +1,222 @@
+package dbx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type tenantItem struct {
+	ID       int
+	TenantID string
+	Name     string
+}
+
+func TestTenantColumn(t *testing.T) {
+	gdb, err := New(&Config{
+		Driver:   DriverSQLite,
+		DbName:   filepath.Join(t.TempDir(), "tenant"),
+		LogName:  "--",
+		LogLevel: LogLevelSilent,
+		Tenant:   &TenantConfig{Mode: TenantModeColumn},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { closeDB(gdb) })
+	if err := gdb.AutoMigrate(&tenantItem{}); err != nil {
+		t.Fatal(err)
+	}
+	a, b := WithTenant(context.Background(), "a"), WithTenant(context.Background(), "b")
+	if err := gdb.WithContext(a).Create([]*tenantItem{{ID: 1, Name: "a1"}, {ID: 2, Name: "a2"}}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := gdb.WithContext(b).Create(&tenantItem{ID: 3, Name: "b1"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := gdb.WithContext(b).Create(&tenantItem{ID: 4, TenantID: "a"}).Error; err == nil {
+		t.Fatal("expected tenant mismatch")
+	}
+
+	var names []string
+	gdb.WithContext(a).Model(&tenantItem{}).Order("id").Pluck("name", &names)
+	if fmt.Sprint(names) != "[a1 a2]" {
+		t.Fatalf("tenant a %v", names)
+	}
+	if n := gdb.WithContext(b).Where("id > ?", 0).Delete(&tenantItem{}).RowsAffected; n != 1 {
+		t.Fatalf("deleted %d", n)
+	}
+	if err := gdb.WithContext(context.Background()).Find(&[]tenantItem{}).Error; !errors.Is(err, ErrNoTenant) {
+		t.Fatalf("no tenant %v", err)
+	}
+	var total int64
+	gdb.WithContext(SkipTenant(context.Background())).Model(&tenantItem{}).Count(&total)
+	if total != 2 {
+		t.Fatalf("skip tenant count %d", total)
+	}
+}
+
+func TestTenantDatabase(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		Driver:   DriverSQLite,
+		DbName:   filepath.Join(dir, "main"),
+		LogName:  "--",
+		LogLevel: LogLevelSilent,
+		Tenant:   &TenantConfig{Mode: TenantModeDatabase, DbName: filepath.Join(dir, "app_{tenant}"), MaxPools: 1},
+	}
+	oldDbs, oldKey := dbs, defaultKey
+	t.Cleanup(func() {
+		CloseAll()
+		resetTenants()
+		dbs, defaultKey = oldDbs, oldKey
+	})
+	if err := Init(map[string]Config{"tenant": cfg}, WithDefaultKeyOption("tenant")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tenant := range []string{"a", "b"} {
+		ctx := WithTenant(context.Background(), tenant)
+		err := InTx(ctx, func(ctx context.Context) error {
+			tx := FromContext(ctx)
+			if err := tx.AutoMigrate(&txItem{}); err != nil {
+				return err
+			}
+			return tx.Create(&txItem{ID: 1, Name: tenant}).Error
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	pool := tenantPools["tenant"]
+	if pool.lru.Len() != 1 {
+		t.Fatalf("pools %d", pool.lru.Len())
+	}
+	var name string
+	FromContext(WithTenant(context.Background(), "a")).Model(&txItem{}).Pluck("name", &name)
+	if name != "a" {
+		t.Fatalf("tenant a name %q", name)
+	}
+	if err := FromContext(context.Background()).Find(&[]txItem{}).Error; !errors.Is(err, ErrNoTenant) {
+		t.Fatalf("no tenant %v", err)
+	}
+	if err := FromContext(WithTenant(context.Background(), "../x")).Find(&[]txItem{}).Error; err == nil {
+		t.Fatal("expected invalid tenant")
+	}
+	// 没有选择租户的连接池时不能使用key的连接池
+	if err := Get("tenant").Find(&[]txItem{}).Error; !errors.Is(err, ErrNoTenant) {
+		t.Fatalf("base db %v", err)
+	}
+	if err := Get("tenant").WithContext(SkipTenant(context.Background())).Exec("SELECT 1").Error; err != nil {
+		t.Fatalf("skip tenant %v", err)
+	}
+}
+
+func TestTenantRaw(t *testing.T) {
+	gdb, err := New(&Config{
+		Driver:   DriverSQLite,
+		DbName:   filepath.Join(t.TempDir(), "tenant"),
+		LogName:  "--",
+		LogLevel: LogLevelSilent,
+		Tenant:   &TenantConfig{Mode: TenantModeColumn},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { closeDB(gdb) })
+	if err := gdb.AutoMigrate(&tenantItem{}); err != nil {
+		t.Fatal(err)
+	}
+	a := WithTenant(context.Background(), "a")
+	if err := gdb.WithContext(a).Create(&tenantItem{ID: 1, Name: "a1"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		ctx  context.Context
+		sql  string
+		err  error
+	}{
+		{"unscoped", a, "UPDATE tenant_item SET name = 'x'", ErrUnscopedRaw},
+		{"quoted", a, "DELETE FROM `tenant_item` WHERE id = 1", ErrUnscopedRaw},
+		{"scoped", a, "UPDATE tenant_item SET name = 'x' WHERE tenant_id = 'a'", nil},
+		{"other table", a, "SELECT 1 FROM tenant_item_log", nil},
+		{"no tenant", context.Background(), "UPDATE tenant_item SET name = 'x'", ErrNoTenant},
+		{"skip tenant", SkipTenant(context.Background()), "UPDATE tenant_item SET name = 'x'", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := gdb.WithContext(tt.ctx).Exec(tt.sql).Error
+			if tt.err == nil && err != nil && !strings.Contains(err.Error(), "no such table") {
+				t.Fatalf("exec %v", err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("exec %v want %v", err, tt.err)
+			}
+		})
+	}
+	var names []string
+	if err := gdb.WithContext(a).Raw("SELECT name FROM tenant_item").Scan(&names).Error; !errors.Is(err, ErrUnscopedRaw) {
+		t.Fatalf("raw scan %v", err)
+	}
+}
+
+func TestTenantPoolEvict(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		Driver:   DriverSQLite,
+		DbName:   filepath.Join(dir, "main"),
+		LogName:  "--",
+		LogLevel: LogLevelSilent,
+		Tenant:   &TenantConfig{Mode: TenantModeDatabase, DbName: filepath.Join(dir, "app_{tenant}"), MaxPools: 1},
+	}
+	oldDbs, oldKey, oldWait := dbs, defaultKey, tenantIdleWait
+	tenantIdleWait = 10 * time.Millisecond
+	t.Cleanup(func() {
+		CloseAll()
+		resetTenants()
+		dbs, defaultKey, tenantIdleWait = oldDbs, oldKey, oldWait
+	})
+	if err := Init(map[string]Config{"tenant": cfg}, WithDefaultKeyOption("tenant")); err != nil {
+		t.Fatal(err)
+	}
+	pool := tenantPools["tenant"]
+	evicted := func() int {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return len(pool.evicted)
+	}
+	a, b := WithTenant(context.Background(), "a"), WithTenant(context.Background(), "b")
+	err := InTx(a, func(ctx context.Context) error {
+		if err := FromContext(ctx).AutoMigrate(&txItem{}); err != nil {
+			return err
+		}
+		// 事务中租户a的连接池被淘汰 事务结束前不关闭
+		if err := FromContext(b).Exec("SELECT 1").Error; err != nil {
+			return err
+		}
+		time.Sleep(5 * tenantIdleWait)
+		if n := evicted(); n != 1 {
+			return fmt.Errorf("evicted %d", n)
+		}
+		return FromContext(ctx).Create(&txItem{ID: 1, Name: "a"}).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for evicted() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("evicted pool not closed")
+		}
+		time.Sleep(tenantIdleWait)
+	}
+	var name string
+	if err := FromContext(a).Model(&txItem{}).Pluck("name", &name).Error; err != nil || name != "a" {
+		t.Fatalf("tenant a name %q %v", name, err)
+	}
+}
@@ -0,0 +1,132 @@
+package dbx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+type txOptions struct {
+	key       string
+	isolation sql.IsolationLevel
+	readOnly  bool
+	retries   int
+	backoff   time.Duration
+}
+
+type TxOption func(o *txOptions)
+
+// WithKeyTxOption 事务使用的db 默认为默认key
+func WithKeyTxOption(key string) TxOption {
+	return func(o *txOptions) {
+		o.key = key
+	}
+}
+
+// WithIsolationTxOption 事务的隔离级别 嵌套的事务忽略
+func WithIsolationTxOption(level sql.IsolationLevel) TxOption {
+	return func(o *txOptions) {
+		o.isolation = level
+	}
+}
+
+// WithReadOnlyTxOption 只读事务
+func WithReadOnlyTxOption() TxOption {
+	return func(o *txOptions) {
+		o.readOnly = true
+	}
+}
+
+// WithRetryTxOption 死锁或序列化失败时的重试次数和间隔 默认重试3次 间隔20ms逐次增加 retries为0时不重试 backoff小于0时按0处理
+func WithRetryTxOption(retries int, backoff time.Duration) TxOption {
+	return func(o *txOptions) {
+		o.retries = retries
+		o.backoff = backoff
+	}
+}
+
+type txKey struct {
+	key string
+}
+
+// InTx 在事务中执行fn fn返回错误或panic时回滚 事务保存在传给fn的ctx中 通过FromContext获取
+// ctx中已有同一个db的事务时使用保存点 fn返回错误只回滚到保存点
+// mysql和postgres的死锁 序列化失败时重新执行整个事务 fn需要可以重复执行
+func InTx(ctx context.Context, fn func(ctx context.Context) error, options ...TxOption) error {
+	opt := txOptions{
+		key:     defaultKey,
+		retries: 3,
+		backoff: 20 * time.Millisecond,
+	}
+	for _, option := range options {
+		if option != nil {
+			option(&opt)
+		}
+	}
+	opt.backoff = max(opt.backoff, 0)
+	run := func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{opt.key}, tx))
+	}
+	if tx, is := ctx.Value(txKey{opt.key}).(*gorm.DB); is {
+		return tx.WithContext(ctx).Transaction(run)
+	}
+	var txOpts []*sql.TxOptions
+	if opt.isolation != sql.LevelDefault || opt.readOnly {
+		txOpts = append(txOpts, &sql.TxOptions{Isolation: opt.isolation, ReadOnly: opt.readOnly})
+	}
+	gdb, release := tenantDB(ctx, opt.key)
+	defer release()
+	if gdb.Error != nil {
+		return gdb.Error
+	}
+	for attempt := 0; ; attempt++ {
+		err := gdb.Transaction(run, txOpts...)
+		if err == nil || attempt >= opt.retries || !IsRetryable(err) {
+			return err
+		}
+		// 随机间隔避免冲突的事务同时重试
+		wait := opt.backoff*time.Duration(attempt+1) + rand.N(opt.backoff+1)
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
+
+// FromContext 返回ctx中的事务 没有时返回db的连接池 key为空时使用默认key
+// 多租户为database模式时返回ctx中租户的连接池 租户的连接池被淘汰后等待tenantIdleWait才关闭 不要长时间保存
+func FromContext(ctx context.Context, key ...string) *DB {
+	k := defaultKey
+	if len(key) != 0 && len(key[0]) != 0 {
+		k = key[0]
+	}
+	if tx, is := ctx.Value(txKey{k}).(*gorm.DB); is {
+		return tx.WithContext(ctx)
+	}
+	gdb, release := tenantDB(ctx, k)
+	release()
+	return gdb
+}
+
+// IsRetryable 是否为可以重试事务的错误 mysql的死锁和锁等待超时 postgres的死锁和序列化失败
+func IsRetryable(err error) bool {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number == 1213 || myErr.Number == 1205
+	}
+	// pgconn.PgError
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		switch pgErr.SQLState() {
+		case "40001", "40P01":
+			return true
+		}
+	}
+	return false
+}
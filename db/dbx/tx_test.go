@@ -0,0 +1,101 @@
+package dbx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+type txItem struct {
+	ID   int
+	Name string
+}
+
+func TestInTx(t *testing.T) {
+	gdb := newSQLite(t)
+	oldDbs, oldKey := dbs, defaultKey
+	dbs, defaultKey = map[string]*DB{"tx": gdb}, "tx"
+	t.Cleanup(func() { dbs, defaultKey = oldDbs, oldKey })
+	if err := gdb.AutoMigrate(&txItem{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	count := func() (n int64) {
+		gdb.Model(&txItem{}).Count(&n)
+		return
+	}
+
+	errFail := errors.New("fail")
+	err := InTx(ctx, func(ctx context.Context) error {
+		if err := FromContext(ctx).Create(&txItem{ID: 1, Name: "outer"}).Error; err != nil {
+			return err
+		}
+		// 嵌套事务失败只回滚到保存点
+		err := InTx(ctx, func(ctx context.Context) error {
+			FromContext(ctx).Create(&txItem{ID: 2, Name: "inner"})
+			return errFail
+		})
+		if !errors.Is(err, errFail) {
+			t.Fatalf("nested %v", err)
+		}
+		return InTx(ctx, func(ctx context.Context) error {
+			return FromContext(ctx).Create(&txItem{ID: 3, Name: "inner ok"}).Error
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	gdb.Model(&txItem{}).Order("id").Pluck("id", &ids)
+	if fmt.Sprint(ids) != "[1 3]" {
+		t.Fatalf("ids %v", ids)
+	}
+
+	err = InTx(ctx, func(ctx context.Context) error {
+		FromContext(ctx).Create(&txItem{ID: 4})
+		return errFail
+	})
+	if !errors.Is(err, errFail) || count() != 2 {
+		t.Fatalf("rollback %v %d", err, count())
+	}
+
+	// 死锁时重试整个事务
+	attempts := 0
+	err = InTx(ctx, func(ctx context.Context) error {
+		attempts++
+		if err := FromContext(ctx).Create(&txItem{ID: 5}).Error; err != nil {
+			return err
+		}
+		if attempts < 3 {
+			return &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}
+		}
+		return nil
+	}, WithRetryTxOption(3, 0))
+	if err != nil || attempts != 3 || count() != 3 {
+		t.Fatalf("retry %v attempts %d count %d", err, attempts, count())
+	}
+	attempts = 0
+	err = InTx(ctx, func(ctx context.Context) error {
+		attempts++
+		return &mysql.MySQLError{Number: 1213}
+	}, WithRetryTxOption(1, 0))
+	if !IsRetryable(err) || attempts != 2 {
+		t.Fatalf("retry limit %v attempts %d", err, attempts)
+	}
+	attempts = 0
+	err = InTx(ctx, func(ctx context.Context) error {
+		attempts++
+		return &mysql.MySQLError{Number: 1213}
+	}, WithRetryTxOption(1, -time.Second))
+	if !IsRetryable(err) || attempts != 2 {
+		t.Fatalf("negative backoff %v attempts %d", err, attempts)
+	}
+
+	if FromContext(ctx).Statement.ConnPool != gdb.ConnPool {
+		t.Fatal("FromContext without tx should use the pool")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/wjoj/tool/v2/health"
 	"github.com/wjoj/tool/v2/log"
 	"github.com/wjoj/tool/v2/utils"
 	"go.mongodb.org/mongo-driver/v2/bson"
@@ -28,6 +29,18 @@ type Mongo struct {
 	db  *mongo.Database
 }
 
+// Validate 校验配置 返回所有错误
+func (c *Config) Validate() error {
+	var errs utils.FieldErrors
+	if len(c.Url) == 0 {
+		errs.Add("url", "is required")
+	}
+	if c.MaxPoolSize != 0 && c.MinPoolSize > c.MaxPoolSize {
+		errs.Add("minPoolSize", "must be <= maxPoolSize")
+	}
+	return errs.Err()
+}
+
 func New(cfg *Config) (*Mongo, error) {
 	if len(cfg.Url) == 0 {
 		return nil, fmt.Errorf("mongo url is empty")
@@ -143,6 +156,7 @@ func Init(cfgs map[string]Config, options ...Option) error {
 				return err
 			}
 			dbs[key] = cli
+			registerHealth(key, cli)
 			if key == defaultKey {
 				db = cli
 			}
@@ -155,12 +169,21 @@ func Init(cfgs map[string]Config, options ...Option) error {
 			return err
 		}
 		dbs[name] = cli
+		registerHealth(name, cli)
 		if name == defaultKey {
 			db = cli
 		}
 	}
 	return nil
 }
+
+// registerHealth 注册健康检查
+func registerHealth(key string, cli *Mongo) {
+	health.Register("mongo:"+key, func(ctx context.Context) error {
+		return cli.cli.Ping(ctx, nil)
+	})
+}
+
 func InitGlobal(cfg *Config) error {
 	var err error
 	db, err = New(cfg)
@@ -194,7 +217,8 @@ func Close() error {
 }
 
 func CloseAll() error {
-	for _, cli := range dbs {
+	for key, cli := range dbs {
+		health.Unregister("mongo:" + key)
 		err := cli.Close()
 		if err != nil {
 			continue
@@ -0,0 +1,145 @@
+package mongox
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/wjoj/tool/v2/pagination"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// PageOptions 分页的查询选项 orders为空时使用页码分页 否则按orders排序
+// limit多取一条用于判断是否有下一页
+func PageOptions(req pagination.Request, orders ...pagination.Order) *options.FindOptionsBuilder {
+	opts := options.Find().SetLimit(int64(req.Limit() + 1))
+	if len(orders) == 0 {
+		return opts.SetSkip(int64(req.Offset()))
+	}
+	sort := make(bson.D, len(orders))
+	for i, o := range orders {
+		sort[i] = bson.E{Key: o.Column, Value: 1}
+		if o.Desc {
+			sort[i].Value = -1
+		}
+	}
+	return opts.SetSort(sort)
+}
+
+// Keyset 游标分页的过滤条件 values为上一页最后一条记录排序列的值 为空时为第一页
+func Keyset(filter bson.M, orders []pagination.Order, values []any) bson.M {
+	if len(values) == 0 {
+		return filter
+	}
+	ors := make(bson.A, len(orders))
+	for i, o := range orders {
+		cond := bson.M{}
+		for j := range i {
+			cond[orders[j].Column] = values[j]
+		}
+		op := "$gt"
+		if o.Desc {
+			op = "$lt"
+		}
+		cond[o.Column] = bson.M{op: values[i]}
+		ors[i] = cond
+	}
+	if len(filter) == 0 {
+		return bson.M{"$or": ors}
+	}
+	return bson.M{"$and": bson.A{filter, bson.M{"$or": ors}}}
+}
+
+// FindPage 分页查询 orders为空时使用页码分页 否则使用游标分页
+// 游标保存排序列的bson值 ObjectID和时间等类型不会丢失
+func FindPage[T any](ctx context.Context, c *Collection, filter bson.M, req pagination.Request, orders ...pagination.Order) (pagination.Response[T], error) {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	var total *int64
+	if req.Total {
+		n, err := c.col.CountDocuments(ctx, filter)
+		if err != nil {
+			return pagination.Response[T]{}, err
+		}
+		total = &n
+	}
+	var values []any
+	if len(req.Cursor) != 0 {
+		if len(orders) == 0 {
+			return pagination.Response[T]{}, fmt.Errorf("%w: cursor requires order columns", pagination.ErrInvalidCursor)
+		}
+		var err error
+		if values, err = decodeCursor(req.Cursor, len(orders)); err != nil {
+			return pagination.Response[T]{}, err
+		}
+	}
+	cur, err := c.col.Find(ctx, Keyset(filter, orders, values), PageOptions(req, orders...))
+	if err != nil {
+		return pagination.Response[T]{}, err
+	}
+	var raws []bson.Raw
+	if err := cur.All(ctx, &raws); err != nil {
+		return pagination.Response[T]{}, err
+	}
+	limit := req.Limit()
+	hasMore := len(raws) > limit
+	if hasMore {
+		raws = raws[:limit]
+	}
+	items := make([]T, len(raws))
+	for i, raw := range raws {
+		if err := bson.Unmarshal(raw, &items[i]); err != nil {
+			return pagination.Response[T]{}, err
+		}
+	}
+	res := pagination.NewResponse(req, items)
+	res.Total, res.HasMore = total, hasMore
+	if hasMore && len(orders) != 0 {
+		if res.NextCursor, err = encodeCursor(raws[limit-1], orders); err != nil {
+			return pagination.Response[T]{}, err
+		}
+	}
+	return res, nil
+}
+
+// encodeCursor 记录中排序列的值按顺序保存为bson文档
+func encodeCursor(raw bson.Raw, orders []pagination.Order) (string, error) {
+	doc := make(bson.D, len(orders))
+	for i, o := range orders {
+		v, err := raw.LookupErr(strings.Split(o.Column, ".")...)
+		if err != nil {
+			return "", fmt.Errorf("order column %s: %w", o.Column, err)
+		}
+		doc[i] = bson.E{Key: fmt.Sprint(i), Value: v}
+	}
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	return pagination.EncodeCursor(b)
+}
+
+func decodeCursor(cursor string, n int) ([]any, error) {
+	vals, err := pagination.DecodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	if len(vals) != 1 {
+		return nil, pagination.ErrInvalidCursor
+	}
+	b, is := vals[0].([]byte)
+	if !is {
+		return nil, pagination.ErrInvalidCursor
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(b, &doc); err != nil || len(doc) != n {
+		return nil, pagination.ErrInvalidCursor
+	}
+	values := make([]any, n)
+	for i, e := range doc {
+		values[i] = e.Value
+	}
+	return values, nil
+}
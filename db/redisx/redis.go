@@ -3,9 +3,11 @@ package redisx
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/wjoj/tool/v2/health"
 	"github.com/wjoj/tool/v2/log"
 	"github.com/wjoj/tool/v2/utils"
 )
@@ -38,6 +40,32 @@ type Clientx struct {
 	cfg *Config
 }
 
+// Validate 校验配置 返回所有错误
+func (c *Config) Validate() error {
+	var errs utils.FieldErrors
+	if len(c.Addrs) == 0 {
+		errs.Add("addrs", "is required")
+	}
+	for i, addr := range c.Addrs {
+		if len(strings.TrimSpace(addr)) == 0 {
+			errs.Add(fmt.Sprintf("addrs[%d]", i), "is empty")
+		}
+	}
+	if c.PoolSize < 0 {
+		errs.Add("poolSize", "must be >= 0")
+	}
+	if c.MinIdleConns < 0 {
+		errs.Add("minIdleConns", "must be >= 0")
+	}
+	if c.MaxIdleConns < 0 {
+		errs.Add("maxIdleConns", "must be >= 0")
+	}
+	if c.MaxActiveConns < 0 {
+		errs.Add("maxActiveConns", "must be >= 0")
+	}
+	return errs.Err()
+}
+
 func New(cfg *Config) (*Clientx, error) {
 	if len(cfg.Addrs) == 0 {
 		return nil, fmt.Errorf("redis adds can't be empty")
@@ -114,6 +142,7 @@ func Init(cfgs map[string]Config, options ...Option) error {
 				return err
 			}
 			rdMap[key] = cli
+			registerHealth(key, cli)
 			if key == defaultKey {
 				rd = cli
 			}
@@ -128,6 +157,7 @@ func Init(cfgs map[string]Config, options ...Option) error {
 			return err
 		}
 		rdMap[name] = cli
+		registerHealth(name, cli)
 		if name == defaultKey {
 			rd = cli
 		}
@@ -136,6 +166,13 @@ func Init(cfgs map[string]Config, options ...Option) error {
 	return nil
 }
 
+// registerHealth 注册健康检查
+func registerHealth(key string, cli *Clientx) {
+	health.Register("redis:"+key, func(ctx context.Context) error {
+		return cli.Ping(ctx).Err()
+	})
+}
+
 func InitGlobal(cfg *Config) error {
 	var err error
 	rd, err = New(cfg)
@@ -174,7 +211,8 @@ func Close() error {
 }
 
 func CloseAll() error {
-	for _, cli := range rdMap {
+	for key, cli := range rdMap {
+		health.Unregister("redis:" + key)
 		cli.Close()
 	}
 	return nil
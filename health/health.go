@@ -0,0 +1,160 @@
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type StatusType string
+
+const (
+	StatusUp   StatusType = "up"
+	StatusDown StatusType = "down"
+)
+
+// Checker 健康检查 返回nil表示正常
+type Checker func(ctx context.Context) error
+
+// Status 单个依赖的检查结果
+type Status struct {
+	Status    StatusType `json:"status"`
+	Latency   string     `json:"latency"`             //检查耗时
+	LastError string     `json:"lastError,omitempty"` //最近一次失败的错误
+	CheckedAt time.Time  `json:"checkedAt"`
+}
+
+// Report 所有依赖的检查结果
+type Report struct {
+	Status StatusType        `json:"status"`
+	Checks map[string]Status `json:"checks"`
+}
+
+var (
+	mu        sync.Mutex
+	checkers  = map[string]Checker{}
+	statuses  = map[string]Status{}
+	checkedAt time.Time
+	interval  = 5 * time.Second //缓存时间
+	timeout   = 3 * time.Second //单个检查的超时时间
+	shutdown  atomic.Bool
+)
+
+// Register 注册检查 名称相同时覆盖
+func Register(name string, fn Checker) {
+	mu.Lock()
+	defer mu.Unlock()
+	checkers[name] = fn
+	checkedAt = time.Time{}
+}
+
+// Unregister 删除检查
+func Unregister(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(checkers, name)
+	delete(statuses, name)
+}
+
+// Names 已注册的检查名称
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	names := make([]string, 0, len(checkers))
+	for name := range checkers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetInterval 设置检查结果的缓存时间 0:每次都检查
+func SetInterval(d time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	interval = d
+}
+
+// SetTimeout 设置单个检查的超时时间
+func SetTimeout(d time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	timeout = d
+}
+
+// SetShutdown 标记正在停止 停止期间Ready返回false
+func SetShutdown(is bool) {
+	shutdown.Store(is)
+}
+
+// IsShutdown 是否正在停止
+func IsShutdown() bool {
+	return shutdown.Load()
+}
+
+// Check 执行所有检查 在缓存时间内返回上次的结果
+func Check(ctx context.Context) Report {
+	mu.Lock()
+	defer mu.Unlock()
+	if checkedAt.IsZero() || time.Since(checkedAt) >= interval {
+		check(ctx)
+		checkedAt = time.Now()
+	}
+	report := Report{
+		Status: StatusUp,
+		Checks: make(map[string]Status, len(statuses)),
+	}
+	for name, st := range statuses {
+		report.Checks[name] = st
+		if st.Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+// check 并发执行所有检查 调用方持有mu
+func check(ctx context.Context) {
+	type result struct {
+		name string
+		st   Status
+	}
+	results := make(chan result, len(checkers))
+	for name, fn := range checkers {
+		lastErr := statuses[name].LastError
+		go func() {
+			cctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			err := fn(cctx)
+			st := Status{
+				Status:    StatusUp,
+				Latency:   time.Since(start).String(),
+				CheckedAt: start,
+			}
+			if err != nil {
+				st.Status = StatusDown
+				st.LastError = err.Error()
+			} else {
+				st.LastError = lastErr
+			}
+			results <- result{name: name, st: st}
+		}()
+	}
+	for range checkers {
+		res := <-results
+		statuses[res.name] = res.st
+	}
+}
+
+// Ready 是否可以接收流量 停止期间或任一检查失败时返回false
+func Ready(ctx context.Context) (bool, Report) {
+	report := Check(ctx)
+	if IsShutdown() {
+		report.Status = StatusDown
+		return false, report
+	}
+	return report.Status == StatusUp, report
+}
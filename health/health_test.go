@@ -0,0 +1,112 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// reset 清空检查并恢复默认设置
+func reset(t *testing.T) {
+	t.Helper()
+	clear := func() {
+		for _, name := range Names() {
+			Unregister(name)
+		}
+		SetInterval(5 * time.Second)
+		SetTimeout(3 * time.Second)
+		SetShutdown(false)
+	}
+	clear()
+	t.Cleanup(clear)
+}
+
+func TestRegistry(t *testing.T) {
+	reset(t)
+	ok := func(ctx context.Context) error { return nil }
+	Register("redis", ok)
+	Register("db", ok)
+	Register("db", ok)
+	if names := Names(); !slices.Equal(names, []string{"db", "redis"}) {
+		t.Fatalf("names %v", names)
+	}
+	Check(context.Background())
+	Unregister("redis")
+	report := Check(context.Background())
+	if _, is := report.Checks["redis"]; is || len(report.Checks) != 1 {
+		t.Fatalf("report %+v", report)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	reset(t)
+	var calls atomic.Int32
+	var fail atomic.Bool
+	Register("db", func(ctx context.Context) error {
+		calls.Add(1)
+		if fail.Load() {
+			return errors.New("db down")
+		}
+		return nil
+	})
+	Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	SetTimeout(20 * time.Millisecond)
+	report := Check(context.Background())
+	if report.Status != StatusDown || report.Checks["db"].Status != StatusUp || report.Checks["slow"].LastError != context.DeadlineExceeded.Error() {
+		t.Fatalf("report %+v", report)
+	}
+	Unregister("slow")
+
+	// 缓存时间内返回上次的结果
+	fail.Store(true)
+	if report := Check(context.Background()); report.Status != StatusUp || calls.Load() != 1 {
+		t.Fatalf("cached %+v calls %d", report, calls.Load())
+	}
+	SetInterval(0)
+	report = Check(context.Background())
+	if report.Status != StatusDown || report.Checks["db"].LastError != "db down" || calls.Load() != 2 {
+		t.Fatalf("report %+v calls %d", report, calls.Load())
+	}
+	// 恢复后保留最近一次失败的错误
+	fail.Store(false)
+	report = Check(context.Background())
+	if report.Status != StatusUp || report.Checks["db"].LastError != "db down" {
+		t.Fatalf("recovered %+v", report)
+	}
+}
+
+func TestReady(t *testing.T) {
+	reset(t)
+	SetInterval(0)
+	var fail atomic.Bool
+	Register("db", func(ctx context.Context) error {
+		if fail.Load() {
+			return errors.New("db down")
+		}
+		return nil
+	})
+	tests := []struct {
+		name     string
+		fail     bool
+		shutdown bool
+		want     bool
+	}{
+		{"ready", false, false, true},
+		{"check failed", true, false, false},
+		{"draining", false, true, false},
+	}
+	for _, tt := range tests {
+		fail.Store(tt.fail)
+		SetShutdown(tt.shutdown)
+		ready, report := Ready(context.Background())
+		if ready != tt.want || (report.Status == StatusUp) != tt.want {
+			t.Errorf("%s: ready %v report %+v", tt.name, ready, report)
+		}
+	}
+}
@@ -2,10 +2,14 @@ package httpx
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"reflect"
 	"slices"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -14,6 +18,7 @@ import (
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"github.com/wjoj/tool/v2/health"
 	"github.com/wjoj/tool/v2/log"
 	"github.com/wjoj/tool/v2/utils"
 	"go.uber.org/zap"
@@ -26,9 +31,11 @@ type Config struct {
 	Port                 int           `yaml:"port" json:"port"`
 	ShutdownCloseMaxWait time.Duration `yaml:"shutdownCloseMaxWait" json:"shutdownCloseMaxWait"` //
 	Ping                 bool          `yaml:"ping" json:"ping"`
-	Swagger              bool          `yaml:"swagger" json:"swagger"`         // 是否开启docs
-	RoutePrefix          string        `yaml:"routePrefix" json:"routePrefix"` // 路由前缀
-	Cors                 bool          `yaml:"cors" json:"cors"`               // 是否启用cors
+	Health               bool          `yaml:"health" json:"health"`                 // 是否开启 /healthz /readyz /livez
+	HealthInterval       time.Duration `yaml:"healthInterval" json:"healthInterval"` // 健康检查结果缓存时间 默认5s
+	Swagger              bool          `yaml:"swagger" json:"swagger"`               // 是否开启docs
+	RoutePrefix          string        `yaml:"routePrefix" json:"routePrefix"`       // 路由前缀
+	Cors                 bool          `yaml:"cors" json:"cors"`                     // 是否启用cors
 	CorsCfg              CorsConfig    `yaml:"corsCfg" json:"corsCfg"`
 }
 
@@ -44,10 +51,35 @@ type CorsConfig struct {
 type Http struct {
 	cfg *Config
 	*gin.Engine
-	srv  *http.Server
+	srv     atomic.Pointer[server]   //Run后设置 健康检查和关闭时并发读取
+	origins atomic.Pointer[[]string] //cors允许的来源 可动态修改
+}
+
+type server struct {
+	*http.Server
 	done chan struct{}
 }
 
+// Validate 校验配置 返回所有错误
+func (c *Config) Validate() error {
+	var errs utils.FieldErrors
+	if c.Port < 0 || c.Port > 65535 {
+		errs.Add("port", "must be 1-65535")
+	}
+	if c.ShutdownCloseMaxWait < 0 {
+		errs.Add("shutdownCloseMaxWait", "must be >= 0")
+	}
+	if c.HealthInterval < 0 {
+		errs.Add("healthInterval", "must be >= 0")
+	}
+	for i, origin := range c.CorsCfg.AllowOrigins {
+		if len(strings.TrimSpace(origin)) == 0 {
+			errs.Add(fmt.Sprintf("corsCfg.allowOrigins[%d]", i), "is empty")
+		}
+	}
+	return errs.Err()
+}
+
 func New(cfg *Config) (*Http, error) {
 	var err error
 	if cfg.Port == 0 {
@@ -67,6 +99,10 @@ func New(cfg *Config) (*Http, error) {
 	} else {
 		gin.SetMode(gin.ReleaseMode)
 	}
+	h := &Http{
+		cfg: cfg,
+	}
+	h.origins.Store(&cfg.CorsCfg.AllowOrigins)
 	var g *gin.Engine
 	if cfg.Debug {
 		g = gin.Default()
@@ -86,17 +122,14 @@ func New(cfg *Config) (*Http, error) {
 	}
 	if cfg.Cors {
 		corsConfig := cors.DefaultConfig()
-		if len(cfg.CorsCfg.AllowOrigins) > 0 {
-			corsConfig.AllowAllOrigins = false
-			corsConfig.AllowOrigins = cfg.CorsCfg.AllowOrigins
-		} else {
-			corsConfig.AllowAllOrigins = true // 允许所有来源
-			corsConfig.AllowOriginWithContextFunc = func(c *gin.Context, origin string) bool {
-				if len(cfg.CorsCfg.AllowOrigins) == 0 || len(origin) == 0 {
-					return true
-				}
-				return slices.Contains(cfg.CorsCfg.AllowOrigins, origin)
+		corsConfig.AllowAllOrigins = false
+		// 来源列表可通过SetCorsAllowOrigins动态修改 为空时允许所有来源
+		corsConfig.AllowOriginWithContextFunc = func(c *gin.Context, origin string) bool {
+			origins := *h.origins.Load()
+			if len(origins) == 0 || len(origin) == 0 {
+				return true
 			}
+			return slices.Contains(origins, origin) || slices.Contains(origins, "*")
 		}
 		if len(cfg.CorsCfg.AllowMethods) > 0 {
 			corsConfig.AllowMethods = cfg.CorsCfg.AllowMethods
@@ -127,34 +160,48 @@ func New(cfg *Config) (*Http, error) {
 			})
 		})
 	}
+	if cfg.Health {
+		if cfg.HealthInterval != 0 {
+			health.SetInterval(cfg.HealthInterval)
+		}
+		healthRoutes(g)
+	}
 	if cfg.Swagger {
 		g.RouterGroup.GET("docs/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 	}
-	return &Http{
-		cfg:    cfg,
-		Engine: g,
-	}, nil
+	h.Engine = g
+	return h, nil
+}
+
+// SetCorsAllowOrigins 动态修改cors允许的来源
+func (h *Http) SetCorsAllowOrigins(origins []string) {
+	h.origins.Store(&origins)
 }
 
 func (h *Http) Run(f func(eng *gin.Engine), fc func()) error {
 	if f != nil {
 		f(h.Engine)
 	}
-	h.srv = &http.Server{
-		Addr:    ":" + strconv.Itoa(h.cfg.Port),
-		Handler: h.Engine,
+	srv := &server{
+		Server: &http.Server{
+			Addr:    ":" + strconv.Itoa(h.cfg.Port),
+			Handler: h.Engine,
+		},
+		// 创建优雅关闭的channel
+		done: make(chan struct{}),
 	}
-	// 创建优雅关闭的channel
-	h.done = make(chan struct{})
+	h.srv.Store(srv)
 	go func() {
 		// 启动服务
-		fc()
-		if err := h.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if fc != nil {
+			fc()
+		}
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			if h.cfg.Log && h.cfg.LogName != "--" {
 				log.GetLogger(h.cfg.LogName).Errorf("listen: %s", err)
 			}
 		}
-		close(h.done)
+		close(srv.done)
 	}()
 
 	// // 监听系统信号
@@ -188,10 +235,33 @@ func (h *Http) Run(f func(eng *gin.Engine), fc func()) error {
 	return nil
 }
 
+// Check 健康检查 服务未启动或已退出时返回错误
+func (h *Http) Check(ctx context.Context) error {
+	srv := h.srv.Load()
+	if srv == nil {
+		return errors.New("http server not started")
+	}
+	select {
+	case <-srv.done:
+		return errors.New("http server stopped")
+	default:
+	}
+	return nil
+}
+
 func (h *Http) Shutdown() error {
-	ctx, cancel := context.WithTimeout(context.Background(), h.cfg.ShutdownCloseMaxWait)
+	return h.ShutdownContext(context.Background())
+}
+
+// ShutdownContext 优雅关闭 等待时间不超过ctx的期限和ShutdownCloseMaxWait
+func (h *Http) ShutdownContext(ctx context.Context) error {
+	srv := h.srv.Load()
+	if srv == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.ShutdownCloseMaxWait)
 	defer cancel()
-	if err := h.srv.Shutdown(ctx); err != nil {
+	if err := srv.Shutdown(ctx); err != nil {
 		if h.cfg.Log && h.cfg.LogName != "--" {
 			log.GetLogger(h.cfg.LogName).Errorf("server forced to shutdown: %v", err)
 		}
@@ -199,7 +269,7 @@ func (h *Http) Shutdown() error {
 	}
 	// 等待所有请求完成
 	select {
-	case <-h.done:
+	case <-srv.done:
 		if h.cfg.Log && h.cfg.LogName != "--" {
 			log.GetLogger(h.cfg.LogName).Info("server gracefully stopped")
 		}
@@ -248,6 +318,7 @@ func Init(cfgs map[string]Config, options ...Option) error {
 			log.Warnf("init http server %s not found engineFunc", key)
 		}
 		https[key] = cli
+		health.Register("http:"+key, cli.Check)
 		go func() {
 			if err := cli.Run(func(eng *gin.Engine) {
 				for i := range funs {
@@ -265,14 +336,56 @@ func Init(cfgs map[string]Config, options ...Option) error {
 	return nil
 }
 
+// Routes 按配置创建gin引擎并注册路由 不启动服务 返回每个key注册的路由
+func Routes(cfgs map[string]Config, options ...Option) (map[string]gin.RoutesInfo, error) {
+	opt := applyGenGormOptions(options...)
+	routes := make(map[string]gin.RoutesInfo, len(cfgs))
+	for key, cfg := range cfgs {
+		cli, err := New(&cfg)
+		if err != nil {
+			return nil, fmt.Errorf("http server %s: %v", key, err)
+		}
+		for _, f := range opt.engFuncs[key] {
+			f(cli.Engine)
+		}
+		routes[key] = cli.Engine.Routes()
+	}
+	return routes, nil
+}
+
+// Reload 配置修改时调用 cors允许的来源可以动态调整 其他修改需要重启
+func Reload(key string, old, new *Config) error {
+	if old == nil || new == nil {
+		return utils.ErrRestartRequired
+	}
+	cli, is := https[key]
+	if !is {
+		return utils.ErrRestartRequired
+	}
+	oldc, newc := *old, *new
+	oldc.CorsCfg.AllowOrigins, newc.CorsCfg.AllowOrigins = nil, nil
+	if !reflect.DeepEqual(oldc, newc) {
+		return utils.ErrRestartRequired
+	}
+	cli.SetCorsAllowOrigins(new.CorsCfg.AllowOrigins)
+	return nil
+}
+
 func ShutdownAll() error {
+	return ShutdownAllContext(context.Background())
+}
+
+// ShutdownAllContext 关闭所有http服务 返回所有关闭错误
+func ShutdownAllContext(ctx context.Context) error {
+	var errs []error
 	for key, cli := range https {
-		if err := cli.Shutdown(); err != nil {
+		if err := cli.ShutdownContext(ctx); err != nil {
 			log.Warnf("http server %s shutdown error: %v", key, err)
+			errs = append(errs, fmt.Errorf("http server %s shutdown error: %w", key, err))
 			continue
 		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 func zapLogger(logger *zap.Logger) gin.HandlerFunc {
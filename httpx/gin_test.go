@@ -0,0 +1,58 @@
+package httpx
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/wjoj/tool/v2/health"
+	"github.com/wjoj/tool/v2/log"
+)
+
+func TestMain(m *testing.M) {
+	log.NewGlobal(log.Config{Level: "info"})
+	os.Exit(m.Run())
+}
+
+// freePort 返回一个空闲端口
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// TestInitCheckWhileStarting 服务启动过程中执行健康检查 配合-race运行
+func TestInitCheckWhileStarting(t *testing.T) {
+	health.SetInterval(0)
+	t.Cleanup(func() {
+		ShutdownAll()
+		delete(https, "race")
+		health.Unregister("http:race")
+		health.SetInterval(5 * time.Second)
+	})
+	if err := Init(map[string]Config{"race": {Port: freePort(t)}}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		report := health.Check(context.Background())
+		if report.Checks["http:race"].Status == health.StatusUp {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("http:race not up: %+v", report.Checks["http:race"])
+		}
+	}
+	if err := https["race"].Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if report := health.Check(context.Background()); report.Checks["http:race"].Status != health.StatusDown {
+		t.Fatalf("http:race after shutdown: %+v", report.Checks["http:race"])
+	}
+}
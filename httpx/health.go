@@ -0,0 +1,32 @@
+package httpx
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wjoj/tool/v2/health"
+)
+
+// healthRoutes 注册 /healthz /readyz /livez
+func healthRoutes(g *gin.Engine) {
+	g.GET("/healthz", func(c *gin.Context) {
+		report := health.Check(c.Request.Context())
+		c.JSON(healthStatusCode(report.Status == health.StatusUp), report)
+	})
+	g.GET("/readyz", func(c *gin.Context) {
+		ready, report := health.Ready(c.Request.Context())
+		c.JSON(healthStatusCode(ready), report)
+	})
+	g.GET("/livez", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"status": health.StatusUp,
+		})
+	})
+}
+
+func healthStatusCode(is bool) int {
+	if is {
+		return http.StatusOK
+	}
+	return http.StatusServiceUnavailable
+}
@@ -0,0 +1,53 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wjoj/tool/v2/health"
+)
+
+func TestHealthRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	g := gin.New()
+	healthRoutes(g)
+	health.SetInterval(0)
+	t.Cleanup(func() {
+		health.Unregister("db")
+		health.SetInterval(5 * time.Second)
+		health.SetShutdown(false)
+	})
+	errDown := errors.New("db down")
+	tests := []struct {
+		name     string
+		path     string
+		err      error
+		shutdown bool
+		want     int
+	}{
+		{"healthz up", "/healthz", nil, false, http.StatusOK},
+		{"healthz down", "/healthz", errDown, false, http.StatusServiceUnavailable},
+		{"healthz draining", "/healthz", nil, true, http.StatusOK},
+		{"readyz up", "/readyz", nil, false, http.StatusOK},
+		{"readyz down", "/readyz", errDown, false, http.StatusServiceUnavailable},
+		{"readyz draining", "/readyz", nil, true, http.StatusServiceUnavailable},
+		{"livez down", "/livez", errDown, false, http.StatusOK},
+		{"livez draining", "/livez", nil, true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health.Register("db", func(ctx context.Context) error { return tt.err })
+			health.SetShutdown(tt.shutdown)
+			w := httptest.NewRecorder()
+			g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.want {
+				t.Fatalf("%s status %d body %s", tt.path, w.Code, w.Body)
+			}
+		})
+	}
+}
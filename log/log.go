@@ -2,6 +2,7 @@ package log
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"strings"
@@ -63,7 +64,47 @@ type Config struct {
 	Compress   bool          `json:"compress" yaml:"compress"`     // 是否压缩/归档旧文件
 }
 
+// Validate 校验配置 返回所有错误
+func (c *Config) Validate() error {
+	var errs utils.FieldErrors
+	if len(c.Level) != 0 {
+		if _, err := zapcore.ParseLevel(strings.ToLower(string(c.Level))); err != nil {
+			errs.Add("level", fmt.Sprintf("unknown level %q", c.Level))
+		}
+	}
+	switch c.Out {
+	case "", OutStdout:
+	case OutFile, OutFileStdout:
+		if len(c.Path) == 0 {
+			errs.Add("path", fmt.Sprintf("is required when out is %s", c.Out))
+		}
+	default:
+		errs.Add("out", fmt.Sprintf("unknown out %q", c.Out))
+	}
+	switch c.OutFormat {
+	case "", OutFormatConsole, OutFormatJson:
+	default:
+		errs.Add("outFormat", fmt.Sprintf("unknown format %q", c.OutFormat))
+	}
+	if c.MaxSize < 0 {
+		errs.Add("maxSize", "must be >= 0")
+	}
+	if c.MaxBackups < 0 {
+		errs.Add("maxBackups", "must be >= 0")
+	}
+	if c.MaxAge < 0 {
+		errs.Add("maxAge", "must be >= 0")
+	}
+	return errs.Err()
+}
+
 func New(cfg *Config) (*zap.Logger, error) {
+	ler, _, err := newLogger(cfg)
+	return ler, err
+}
+
+// newLogger 创建日志 返回可动态调整的日志等级
+func newLogger(cfg *Config) (*zap.Logger, zap.AtomicLevel, error) {
 	if cfg.MaxAge == 0 {
 		cfg.MaxAge = 7
 	}
@@ -83,7 +124,7 @@ func New(cfg *Config) (*zap.Logger, error) {
 		cfg.OutFormat = OutFormatConsole
 	}
 	if (cfg.Out == OutFile || cfg.Out == OutFileStdout) && len(cfg.Path) == 0 {
-		return nil, errors.New("path is empty")
+		return nil, zap.AtomicLevel{}, errors.New("path is empty")
 	}
 	enccfg := zap.NewProductionEncoderConfig()
 	enccfg.EncodeTime = zapcore.TimeEncoderOfLayout("2006-01-02 15:04:05") //zapcore.ISO8601TimeEncoder
@@ -115,22 +156,26 @@ func New(cfg *Config) (*zap.Logger, error) {
 	} else {
 		enc = zapcore.NewJSONEncoder(enccfg)
 	}
+	level := zap.NewAtomicLevelAt(cfg.Level.ZapLevel())
 	core := zapcore.NewCore(enc,
-		zapcore.AddSync(file), cfg.Level.ZapLevel())
+		zapcore.AddSync(file), level)
 	ler := zap.New(core, zap.AddCaller(), zap.Development(), zap.AddCallerSkip(1))
 	logsugared = ler.Sugar()
-	return ler, nil
+	return ler, level, nil
 }
 
-var logsugared *zap.SugaredLogger
+// logsugared 全局日志 Load和NewGlobal之前输出info以上的日志到stdout
+var logsugared = zap.New(zapcore.NewCore(zapcore.NewConsoleEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(os.Stdout), zapcore.InfoLevel)).Sugar()
 var logg *zap.Logger
 var logsugaredMap map[string]*zap.SugaredLogger
+var levelMap map[string]zap.AtomicLevel
 var defaultKey = utils.DefaultKey.DefaultKey
 
 func Load(logs map[string]Config, options ...Option) error {
 	opt := applyGenGormOptions(options...)
 	defaultKey = opt.defKey.DefaultKey
 	logsugaredMap = make(map[string]*zap.SugaredLogger)
+	levelMap = make(map[string]zap.AtomicLevel)
 	if len(opt.defKey.Keys) != 0 {
 		opt.defKey.Keys = append(opt.defKey.Keys, opt.defKey.DefaultKey)
 		for _, key := range opt.defKey.Keys {
@@ -142,11 +187,12 @@ func Load(logs map[string]Config, options ...Option) error {
 			if !is {
 				return errors.New(key + " log key not found")
 			}
-			zaplog, err := New(&cfg)
+			zaplog, level, err := newLogger(&cfg)
 			if err != nil {
 				return err
 			}
 			logsugaredMap[key] = zaplog.Sugar()
+			levelMap[key] = level
 			if key == opt.defKey.DefaultKey {
 				logg = zaplog
 				logsugared = zaplog.Sugar()
@@ -156,11 +202,12 @@ func Load(logs map[string]Config, options ...Option) error {
 	}
 	for name := range logs {
 		cfg := logs[name]
-		zaplog, err := New(&cfg)
+		zaplog, level, err := newLogger(&cfg)
 		if err != nil {
 			return err
 		}
 		logsugaredMap[name] = zaplog.Sugar()
+		levelMap[name] = level
 		if name == opt.defKey.DefaultKey {
 			logg = zaplog
 			logsugared = zaplog.Sugar()
@@ -181,6 +228,33 @@ func GetLogger(key ...string) *zap.SugaredLogger {
 	panic(k + "log key not found")
 }
 
+// SetLevel 动态调整日志等级
+func SetLevel(level LevelType, key ...string) error {
+	k := defaultKey
+	if len(key) != 0 {
+		k = key[0]
+	}
+	lv, is := levelMap[k]
+	if !is {
+		return errors.New(k + " log key not found")
+	}
+	lv.SetLevel(level.ZapLevel())
+	return nil
+}
+
+// Reload 配置修改时调用 只有等级可以动态调整 其他修改需要重启
+func Reload(key string, old, new *Config) error {
+	if old == nil || new == nil {
+		return utils.ErrRestartRequired
+	}
+	oldc, newc := *old, *new
+	oldc.Level, newc.Level = "", ""
+	if oldc != newc {
+		return utils.ErrRestartRequired
+	}
+	return SetLevel(new.Level, key)
+}
+
 func NewGlobal(cfg Config) error {
 	log, err := New(&cfg)
 	if err != nil {
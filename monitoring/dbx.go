@@ -0,0 +1,152 @@
+package monitoring
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wjoj/tool/v2/db/dbx"
+	"gorm.io/gorm"
+)
+
+const dbMetricsStartKey = "monitoring:db_start"
+
+// DBMetrics dbx的查询指标 按db的key 操作和表统计查询次数和耗时
+type DBMetrics struct {
+	queries  *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// NewDBMetrics 创建查询指标和连接池指标 注册到reg
+// 连接池指标在采集时读取dbx.All()中的db 不包含只读副本和租户的连接池
+func NewDBMetrics(reg prometheus.Registerer) (*DBMetrics, error) {
+	m := &DBMetrics{
+		queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "db_queries_total",
+			Help: "Total number of database statements executed",
+		}, []string{"db", "operation", "table"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "db_query_duration_seconds",
+			Help:    "Duration of database statements execution",
+			Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"db", "operation", "table"}),
+	}
+	for _, c := range []prometheus.Collector{m.queries, m.duration, &dbStatsCollector{}} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// RegisterDBMetrics 创建指标并添加到dbx.All()中的db
+// 分配到只读副本的查询经过主库的回调 按主库的key统计
+// 租户模式为database时租户的连接池在使用时创建 不在dbx.All()中 查询不统计
+func RegisterDBMetrics(reg prometheus.Registerer) (*DBMetrics, error) {
+	m, err := NewDBMetrics(reg)
+	if err != nil {
+		return nil, err
+	}
+	for key, db := range dbx.All() {
+		if err := m.Use(key, db); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Use 为db注册统计查询的gorm回调 key为指标的db标签
+func (m *DBMetrics) Use(key string, db *gorm.DB) error {
+	return db.Use(&dbMetricsPlugin{key: key, m: m})
+}
+
+type dbMetricsPlugin struct {
+	key string
+	m   *DBMetrics
+}
+
+func (p *dbMetricsPlugin) Name() string {
+	return "monitoring:db_metrics:" + p.key
+}
+
+// Initialize 在gorm的create query update delete row raw前后记录时间
+func (p *dbMetricsPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	// 同一个db可以按不同的key注册 回调名称包含key
+	before, after := "monitoring:db_before:"+p.key, "monitoring:db_after:"+p.key
+	for _, err := range []error{
+		cb.Create().Before("gorm:create").Register(before, p.before),
+		cb.Create().After("gorm:create").Register(after, p.after("create")),
+		cb.Query().Before("gorm:query").Register(before, p.before),
+		cb.Query().After("gorm:query").Register(after, p.after("query")),
+		cb.Update().Before("gorm:update").Register(before, p.before),
+		cb.Update().After("gorm:update").Register(after, p.after("update")),
+		cb.Delete().Before("gorm:delete").Register(before, p.before),
+		cb.Delete().After("gorm:delete").Register(after, p.after("delete")),
+		cb.Row().Before("gorm:row").Register(before, p.before),
+		cb.Row().After("gorm:row").Register(after, p.after("query")),
+		cb.Raw().Before("gorm:raw").Register(before, p.before),
+		cb.Raw().After("gorm:raw").Register(after, p.after("raw")),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *dbMetricsPlugin) before(db *gorm.DB) {
+	db.InstanceSet(dbMetricsStartKey, time.Now())
+}
+
+func (p *dbMetricsPlugin) after(op string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		v, is := db.InstanceGet(dbMetricsStartKey)
+		if !is {
+			return
+		}
+		start, _ := v.(time.Time)
+		table := db.Statement.Table
+		if len(table) == 0 && db.Statement.Schema != nil {
+			table = db.Statement.Schema.Table
+		}
+		p.m.queries.WithLabelValues(p.key, op, table).Inc()
+		p.m.duration.WithLabelValues(p.key, op, table).Observe(time.Since(start).Seconds())
+	}
+}
+
+// dbStatsCollector 采集dbx.All()中db连接池的sql.DBStats 只读副本和租户的连接池不采集
+type dbStatsCollector struct{}
+
+var (
+	dbMaxOpenDesc      = prometheus.NewDesc("db_max_open_connections", "Maximum number of open connections to the database", []string{"db"}, nil)
+	dbOpenDesc         = prometheus.NewDesc("db_open_connections", "The number of established connections both in use and idle", []string{"db"}, nil)
+	dbInUseDesc        = prometheus.NewDesc("db_in_use_connections", "The number of connections currently in use", []string{"db"}, nil)
+	dbIdleDesc         = prometheus.NewDesc("db_idle_connections", "The number of idle connections", []string{"db"}, nil)
+	dbWaitCountDesc    = prometheus.NewDesc("db_wait_count_total", "The total number of connections waited for", []string{"db"}, nil)
+	dbWaitDurationDesc = prometheus.NewDesc("db_wait_duration_seconds_total", "The total time blocked waiting for a new connection", []string{"db"}, nil)
+)
+
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dbMaxOpenDesc
+	ch <- dbOpenDesc
+	ch <- dbInUseDesc
+	ch <- dbIdleDesc
+	ch <- dbWaitCountDesc
+	ch <- dbWaitDurationDesc
+}
+
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	for key, db := range dbx.All() {
+		dc, err := db.DB()
+		if err != nil {
+			continue
+		}
+		st := dc.Stats()
+		ch <- prometheus.MustNewConstMetric(dbMaxOpenDesc, prometheus.GaugeValue, float64(st.MaxOpenConnections), key)
+		ch <- prometheus.MustNewConstMetric(dbOpenDesc, prometheus.GaugeValue, float64(st.OpenConnections), key)
+		ch <- prometheus.MustNewConstMetric(dbInUseDesc, prometheus.GaugeValue, float64(st.InUse), key)
+		ch <- prometheus.MustNewConstMetric(dbIdleDesc, prometheus.GaugeValue, float64(st.Idle), key)
+		ch <- prometheus.MustNewConstMetric(dbWaitCountDesc, prometheus.CounterValue, float64(st.WaitCount), key)
+		ch <- prometheus.MustNewConstMetric(dbWaitDurationDesc, prometheus.CounterValue, st.WaitDuration.Seconds(), key)
+	}
+}
@@ -0,0 +1,91 @@
+package monitoring
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/wjoj/tool/v2/db/dbx"
+	"github.com/wjoj/tool/v2/log"
+)
+
+type metricItem struct {
+	ID   int
+	Name string
+}
+
+func TestDBMetrics(t *testing.T) {
+	if err := log.NewGlobal(log.Config{Level: "info"}); err != nil {
+		t.Fatal(err)
+	}
+	err := dbx.Init(map[string]dbx.Config{
+		"def": {Driver: dbx.DriverSQLite, DbName: filepath.Join(t.TempDir(), "m"), LogName: "--", LogLevel: dbx.LogLevelSilent},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbx.CloseAll() })
+	reg := prometheus.NewRegistry()
+	m, err := RegisterDBMetrics(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := dbx.Get("def")
+	db.AutoMigrate(&metricItem{})
+	db.Create(&metricItem{Name: "a"})
+	db.Find(&[]metricItem{})
+	db.Find(&[]metricItem{})
+	db.Exec("UPDATE metric_item SET name = ?", "b")
+
+	if n := testutil.ToFloat64(m.queries.WithLabelValues("def", "query", "metric_item")); n != 2 {
+		t.Fatalf("query count %v", n)
+	}
+	if n := testutil.ToFloat64(m.queries.WithLabelValues("def", "create", "metric_item")); n != 1 {
+		t.Fatalf("create count %v", n)
+	}
+	if n := testutil.ToFloat64(m.queries.WithLabelValues("def", "raw", "")); n == 0 {
+		t.Fatal("raw count")
+	}
+	expected := `
+# HELP db_wait_count_total The total number of connections waited for
+# TYPE db_wait_count_total counter
+db_wait_count_total{db="def"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "db_wait_count_total"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDBMetrics(reg); err == nil {
+		t.Fatal("duplicate registration should fail")
+	}
+}
+
+func TestDBMetricsKeys(t *testing.T) {
+	db, err := dbx.New(&dbx.Config{Driver: dbx.DriverSQLite, DbName: filepath.Join(t.TempDir(), "k"), LogName: "--", LogLevel: dbx.LogLevelSilent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if dc, err := db.DB(); err == nil {
+			dc.Close()
+		}
+	})
+	m, err := NewDBMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 同一个db按两个key注册 两个key都统计
+	for _, key := range []string{"a", "b"} {
+		if err := m.Use(key, db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.AutoMigrate(&metricItem{})
+	db.Find(&[]metricItem{})
+	for _, key := range []string{"a", "b"} {
+		if n := testutil.ToFloat64(m.queries.WithLabelValues(key, "query", "metric_item")); n != 1 {
+			t.Fatalf("%s query count %v", key, n)
+		}
+	}
+}
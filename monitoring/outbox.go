@@ -0,0 +1,55 @@
+package monitoring
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wjoj/tool/v2/db/dbx"
+	"github.com/wjoj/tool/v2/log"
+)
+
+var (
+	outboxPendingDesc   = prometheus.NewDesc("outbox_pending_messages", "The number of outbox messages waiting to be published", []string{"relay"}, nil)
+	outboxFailedDesc    = prometheus.NewDesc("outbox_failed_messages", "The number of outbox messages that exceeded max attempts", []string{"relay"}, nil)
+	outboxLagDesc       = prometheus.NewDesc("outbox_lag_seconds", "Age of the oldest pending outbox message", []string{"relay"}, nil)
+	outboxPublishedDesc = prometheus.NewDesc("outbox_published_total", "The total number of outbox messages published by the relay", []string{"relay"}, nil)
+	outboxErrorsDesc    = prometheus.NewDesc("outbox_publish_errors_total", "The total number of failed outbox publish attempts by the relay", []string{"relay"}, nil)
+)
+
+// outboxCollector 采集时查询每个relay的OutboxStats
+type outboxCollector struct {
+	relays  []*dbx.OutboxRelay
+	timeout time.Duration
+}
+
+// RegisterOutboxMetrics 注册发件箱的消息数 延迟和发送次数指标 relay标签为relay的名称
+func RegisterOutboxMetrics(reg prometheus.Registerer, relays ...*dbx.OutboxRelay) error {
+	return reg.Register(&outboxCollector{relays: relays, timeout: 5 * time.Second})
+}
+
+func (c *outboxCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- outboxPendingDesc
+	ch <- outboxFailedDesc
+	ch <- outboxLagDesc
+	ch <- outboxPublishedDesc
+	ch <- outboxErrorsDesc
+}
+
+func (c *outboxCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, r := range c.relays {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		st, err := r.Stats(ctx)
+		cancel()
+		name := r.Name()
+		if err != nil {
+			log.Warnf("outbox relay %s stats error: %v", name, err)
+		} else {
+			ch <- prometheus.MustNewConstMetric(outboxPendingDesc, prometheus.GaugeValue, float64(st.Pending), name)
+			ch <- prometheus.MustNewConstMetric(outboxFailedDesc, prometheus.GaugeValue, float64(st.Failed), name)
+			ch <- prometheus.MustNewConstMetric(outboxLagDesc, prometheus.GaugeValue, st.Lag.Seconds(), name)
+		}
+		ch <- prometheus.MustNewConstMetric(outboxPublishedDesc, prometheus.CounterValue, float64(st.Published), name)
+		ch <- prometheus.MustNewConstMetric(outboxErrorsDesc, prometheus.CounterValue, float64(st.Errors), name)
+	}
+}
@@ -0,0 +1,173 @@
+// Package pagination 分页的请求 游标和响应 dbx和mongox根据请求生成查询
+//
+// 页码分页使用Page和Size, 游标(keyset)分页使用上一页返回的NextCursor,
+// 游标分页按排序的列定位 不使用OFFSET 适合数据量大的表
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	DefaultSize = 20   //Size为0时的每页数量
+	MaxSize     = 1000 //每页的最大数量
+)
+
+// ErrInvalidCursor 游标格式错误或与排序的列不匹配
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Request 分页请求 Cursor不为空时使用游标分页 忽略Page
+type Request struct {
+	Page   int    `json:"page" form:"page"` //从1开始
+	Size   int    `json:"size" form:"size"`
+	Cursor string `json:"cursor" form:"cursor"` //上一页返回的NextCursor
+	Total  bool   `json:"total" form:"total"`   //是否返回总数
+}
+
+// Limit 每页数量 限制在1到MaxSize之间
+func (r Request) Limit() int {
+	if r.Size <= 0 {
+		return DefaultSize
+	}
+	return min(r.Size, MaxSize)
+}
+
+// Offset 页码分页的偏移
+func (r Request) Offset() int {
+	return (max(r.Page, 1) - 1) * r.Limit()
+}
+
+// Order 游标分页排序的列 多个列的组合需要唯一 最后一列通常为主键 列的值不能为NULL
+type Order struct {
+	Column string
+	Desc   bool
+}
+
+// Response 分页响应 可直接作为httpx.Success的data
+type Response[T any] struct {
+	Items      []T    `json:"items"`
+	Page       int    `json:"page,omitempty"` //游标分页时为0
+	Size       int    `json:"size"`
+	Total      *int64 `json:"total,omitempty"` //请求Total时返回
+	NextCursor string `json:"nextCursor,omitempty"`
+	HasMore    bool   `json:"hasMore"`
+}
+
+// NewResponse 创建响应 items为nil时返回空列表
+func NewResponse[T any](req Request, items []T) Response[T] {
+	if items == nil {
+		items = []T{}
+	}
+	res := Response[T]{Items: items, Size: req.Limit()}
+	if len(req.Cursor) == 0 {
+		res.Page = max(req.Page, 1)
+	}
+	return res
+}
+
+type cursorValue struct {
+	T string `json:"t"`
+	V any    `json:"v"`
+}
+
+// EncodeCursor 将排序列的值编码为游标 支持整数 浮点数 字符串 布尔 时间 []byte和nil
+func EncodeCursor(values ...any) (string, error) {
+	vals := make([]cursorValue, len(values))
+	for i, v := range values {
+		switch val := v.(type) {
+		case nil:
+			vals[i] = cursorValue{T: "n"}
+		case int, int8, int16, int32, int64:
+			vals[i] = cursorValue{T: "i", V: fmt.Sprint(val)}
+		case uint, uint8, uint16, uint32, uint64:
+			vals[i] = cursorValue{T: "u", V: fmt.Sprint(val)}
+		case float32, float64:
+			vals[i] = cursorValue{T: "f", V: val}
+		case string:
+			vals[i] = cursorValue{T: "s", V: val}
+		case bool:
+			vals[i] = cursorValue{T: "b", V: val}
+		case time.Time:
+			vals[i] = cursorValue{T: "t", V: val.Format(time.RFC3339Nano)}
+		case []byte:
+			vals[i] = cursorValue{T: "x", V: val}
+		default:
+			return "", fmt.Errorf("unsupported cursor value %T", v)
+		}
+	}
+	b, err := json.Marshal(vals)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// DecodeCursor 解码游标 值的类型为 int64 uint64 float64 string bool time.Time []byte 或 nil
+func DecodeCursor(cursor string) ([]any, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var vals []struct {
+		T string          `json:"t"`
+		V json.RawMessage `json:"v"`
+	}
+	if err := json.Unmarshal(b, &vals); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	values := make([]any, len(vals))
+	for i, v := range vals {
+		var dst any
+		switch v.T {
+		case "n":
+			continue
+		case "i", "u", "s", "t":
+			var s string
+			if err := json.Unmarshal(v.V, &s); err != nil {
+				return nil, ErrInvalidCursor
+			}
+			dst, err = parseCursorString(v.T, s)
+		case "f":
+			var f float64
+			err = json.Unmarshal(v.V, &f)
+			dst = f
+		case "b":
+			var bl bool
+			err = json.Unmarshal(v.V, &bl)
+			dst = bl
+		case "x":
+			var bs []byte
+			err = json.Unmarshal(v.V, &bs)
+			dst = bs
+		default:
+			err = ErrInvalidCursor
+		}
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		values[i] = dst
+	}
+	return values, nil
+}
+
+func parseCursorString(typ, s string) (v any, err error) {
+	switch typ {
+	case "i":
+		var n int64
+		_, err = fmt.Sscan(s, &n)
+		v = n
+	case "u":
+		var n uint64
+		_, err = fmt.Sscan(s, &n)
+		v = n
+	case "t":
+		v, err = time.Parse(time.RFC3339Nano, s)
+	default:
+		v = s
+	}
+	return
+}
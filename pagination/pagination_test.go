@@ -0,0 +1,47 @@
+package pagination
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCursor(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	cursor, err := EncodeCursor(int64(-1)<<62, uint64(1)<<63, 1.5, "a", true, now, []byte{1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := DecodeCursor(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[0] != int64(-1)<<62 || values[1] != uint64(1)<<63 || values[2] != 1.5 || values[3] != "a" ||
+		values[4] != true || !values[5].(time.Time).Equal(now) || values[6].([]byte)[0] != 1 || values[7] != nil {
+		t.Fatalf("decode %v", values)
+	}
+	if _, err := EncodeCursor(struct{}{}); err == nil {
+		t.Fatal("encode struct")
+	}
+	for _, c := range []string{"!", "e30", "W3sidCI6InoifV0"} {
+		if _, err := DecodeCursor(c); !errors.Is(err, ErrInvalidCursor) {
+			t.Fatalf("decode %q %v", c, err)
+		}
+	}
+}
+
+func TestRequest(t *testing.T) {
+	r := Request{Page: 3, Size: 10}
+	if r.Limit() != 10 || r.Offset() != 20 {
+		t.Fatalf("limit %d offset %d", r.Limit(), r.Offset())
+	}
+	if r = (Request{Size: MaxSize + 1}); r.Limit() != MaxSize || r.Offset() != 0 {
+		t.Fatalf("max limit %d", r.Limit())
+	}
+	if (Request{}).Limit() != DefaultSize {
+		t.Fatal("default size")
+	}
+	if res := NewResponse[int](Request{Cursor: "x"}, nil); res.Items == nil || res.Page != 0 {
+		t.Fatalf("response %+v", res)
+	}
+}
@@ -66,6 +66,17 @@ type Casbin struct {
 	cfg *Config
 }
 
+// Validate 校验配置 返回所有错误
+func (c *Config) Validate() error {
+	var errs utils.FieldErrors
+	switch c.DBType {
+	case DbTypeGorm, DbTypeRedis:
+	default:
+		errs.Add("dbType", fmt.Sprintf("must be %s or %s", DbTypeGorm, DbTypeRedis))
+	}
+	return errs.Err()
+}
+
 func New(cfg *Config) (*Casbin, error) {
 	if len(cfg.Key) == 0 {
 		cfg.Key = utils.DefaultKey.DefaultKey
@@ -1,8 +1,11 @@
 package jwt
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -15,6 +18,8 @@ const (
 	Contextlaims = "claims"
 )
 
+type claimsKey struct{}
+
 type Config struct {
 	Method  string        `json:"method" yaml:"method"`
 	Secret  string        `json:"secret" yaml:"secret"`   //密钥
@@ -35,11 +40,45 @@ type Claims[T any] struct {
 }
 
 type Jwt struct {
-	cfg *Config
+	cfg atomic.Pointer[Config]
 	pub any
 	pri any
 }
 
+// Validate 校验配置 返回所有错误
+func (c *Config) Validate() error {
+	var errs utils.FieldErrors
+	method := strings.ToUpper(c.Method)
+	switch {
+	case len(method) == 0 || strings.HasPrefix(method, "HS"):
+		if len(method) != 0 && jwt.GetSigningMethod(method) == nil {
+			errs.Add("method", fmt.Sprintf("unknown method %q", c.Method))
+		}
+		if len(c.Secret) == 0 {
+			errs.Add("secret", "is required")
+		}
+	case strings.HasPrefix(method, "RS"), strings.HasPrefix(method, "ES"), strings.HasPrefix(method, "ED"):
+		if !strings.HasPrefix(method, "ED") && jwt.GetSigningMethod(method) == nil {
+			errs.Add("method", fmt.Sprintf("unknown method %q", c.Method))
+		}
+		if len(c.Public) == 0 {
+			errs.Add("public", "is required")
+		}
+		if len(c.Private) == 0 {
+			errs.Add("private", "is required")
+		}
+	default:
+		errs.Add("method", fmt.Sprintf("unknown method %q", c.Method))
+	}
+	if c.Expire < 0 {
+		errs.Add("expire", "must be >= 0")
+	}
+	if c.Number < -1 {
+		errs.Add("number", "must be >= -1")
+	}
+	return errs.Err()
+}
+
 func New(cfg *Config) (*Jwt, error) {
 	if len(cfg.Method) == 0 {
 		cfg.Method = jwt.SigningMethodHS256.Alg()
@@ -49,9 +88,8 @@ func New(cfg *Config) (*Jwt, error) {
 	if strings.HasPrefix(string(cfg.Method), "ED") {
 		cfg.Method = jwt.SigningMethodEdDSA.Alg()
 	}
-	jt := &Jwt{
-		cfg: cfg,
-	}
+	jt := &Jwt{}
+	jt.cfg.Store(cfg)
 	if strings.HasPrefix(string(cfg.Method), "RS") {
 		pubBy, priBy, err := readPubPri(cfg.Public, cfg.Private)
 		if err != nil {
@@ -101,6 +139,32 @@ func New(cfg *Config) (*Jwt, error) {
 	return jt, nil
 }
 
+func (j *Jwt) config() *Config {
+	return j.cfg.Load()
+}
+
+// Reload 配置修改时调用 过期时间和token数量可以动态调整 其他修改需要重启
+func Reload(key string, old, new *Config) error {
+	if old == nil || new == nil {
+		return utils.ErrRestartRequired
+	}
+	j, is := cbMap[key]
+	if !is {
+		return utils.ErrRestartRequired
+	}
+	cfg := *j.config()
+	oldc, newc := *old, *new
+	oldc.Expire, newc.Expire = 0, 0
+	oldc.Number, newc.Number = 0, 0
+	if oldc != newc {
+		return utils.ErrRestartRequired
+	}
+	cfg.Expire = new.Expire
+	cfg.Number = new.Number
+	j.cfg.Store(&cfg)
+	return nil
+}
+
 func readPubPri(p, pi string) (pub, pri []byte, err error) {
 	pub, err = utils.FileRead(p)
 	if err != nil {
@@ -169,19 +233,39 @@ func AuthMiddleware[T any](key ...string) gin.HandlerFunc {
 			return
 		}
 		ctx.Set(Contextlaims, claims)
+		// 同时保存到c.Request的ctx 使用c.Request.Context()时也能获取
+		ctx.Request = ctx.Request.WithContext(context.WithValue(ctx.Request.Context(), claimsKey{}, claims))
 		ctx.Next()
 	}
 }
 
+// Subject AuthMiddleware设置的claims的subject ctx为*gin.Context或c.Request.Context()
+// 可用于dbx.WithActorAuditOption(jwt.Subject)
+func Subject(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	claims, is := ctx.Value(claimsKey{}).(jwt.Claims)
+	if !is {
+		if claims, is = ctx.Value(Contextlaims).(jwt.Claims); !is {
+			return ""
+		}
+	}
+	sub, _ := claims.GetSubject()
+	return sub
+}
+
 func GenerateToken[T any](uid string, data T, key ...string) (token *JwtToken, err error) {
 	j := Get(key...)
+	cfg := j.config()
 	cl := Claims[T]{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(j.cfg.Expire)),
+			Subject:   uid,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(cfg.Expire)),
 		},
 		Data: data,
 	}
-	tk := jwt.NewWithClaims(jwt.GetSigningMethod(string(j.cfg.Method)), cl)
+	tk := jwt.NewWithClaims(jwt.GetSigningMethod(string(cfg.Method)), cl)
 	s, err := tk.SignedString(j.pri)
 	if err != nil {
 		return nil, err
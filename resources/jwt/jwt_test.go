@@ -0,0 +1,65 @@
+package jwt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wjoj/tool/v2/log"
+)
+
+func TestMain(m *testing.M) {
+	log.NewGlobal(log.Config{Level: "info"})
+	os.Exit(m.Run())
+}
+
+func TestGenerateTokenSubject(t *testing.T) {
+	if err := Init(map[string]Config{"test": {Secret: "secret", Expire: time.Hour}}); err != nil {
+		t.Fatal(err)
+	}
+	type data struct {
+		Name string
+	}
+	token, err := GenerateToken("u1", data{Name: "a"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ValidateToken[data](token.Token, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// uid保存为标准的sub声明
+	if sub, _ := claims.GetSubject(); sub != "u1" || claims.Data.Name != "a" {
+		t.Fatalf("claims %+v", claims)
+	}
+}
+
+func TestSubject(t *testing.T) {
+	if err := Init(map[string]Config{"test": {Secret: "secret", Expire: time.Hour}}); err != nil {
+		t.Fatal(err)
+	}
+	token, err := GenerateToken("u1", struct{}{}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	g := gin.New()
+	var ginSub, reqSub string
+	g.GET("/", AuthMiddleware[struct{}]("test"), func(c *gin.Context) {
+		ginSub, reqSub = Subject(c), Subject(c.Request.Context())
+	})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || ginSub != "u1" || reqSub != "u1" {
+		t.Fatalf("status %d gin %q request %q", w.Code, ginSub, reqSub)
+	}
+	if sub := Subject(context.Background()); len(sub) != 0 {
+		t.Fatalf("subject without claims %q", sub)
+	}
+}
@@ -0,0 +1,59 @@
+package tool
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/wjoj/tool/v2/log"
+)
+
+// Server 长期运行的服务 如*http.Server
+type Server interface {
+	ListenAndServe() error              //阻塞运行 关闭后返回http.ErrServerClosed
+	Shutdown(ctx context.Context) error //优雅关闭
+}
+
+// serverComponent AddServerComponent添加的服务适配Component
+type serverComponent struct {
+	name string
+	srv  Server
+	deps []string
+	done chan struct{}
+}
+
+func (s *serverComponent) Name() string {
+	return s.name
+}
+
+func (s *serverComponent) Dependencies() []string {
+	return s.deps
+}
+
+func (s *serverComponent) Start(ctx context.Context) error {
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		log.Infof("server %s start", s.name)
+		if err := s.srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("server %s run error: %v", s.name, err)
+		}
+	}()
+	return nil
+}
+
+func (s *serverComponent) Stop(ctx context.Context) error {
+	if err := s.srv.Shutdown(ctx); err != nil {
+		log.Warnf("server %s shutdown error: %v", s.name, err)
+		return err
+	}
+	// 等待服务退出
+	select {
+	case <-s.done:
+		log.Infof("server %s gracefully stopped", s.name)
+	case <-ctx.Done():
+		log.Warnf("server %s timeout while waiting for stop", s.name)
+		return ctx.Err()
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Diff 按行比较old和new 返回增加(+)和删除(-)的行 不包含上下文 内容相同时返回空字符串
+func Diff(name, old, new string) string {
+	if old == new {
+		return ""
+	}
+	a, b := splitLines(old), splitLines(new)
+	// lcs[i][j] 为a[i:]和b[j:]的最长公共子序列长度
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- a/%s\n+++ b/%s\n", name, name)
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i, j = i+1, j+1
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			fmt.Fprintf(&sb, "+%s\n", b[j])
+			j++
+		default:
+			fmt.Fprintf(&sb, "-%s\n", a[i])
+			i++
+		}
+	}
+	return sb.String()
+}
+
+func splitLines(s string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrRestartRequired 配置修改无法动态生效 需要重启
+var ErrRestartRequired = errors.New("config change requires restart")
+
+// FieldError 配置字段错误 Path为字段路径 如dbs.def.port
+type FieldError struct {
+	Path string
+	Msg  string
+}
+
+func (e *FieldError) Error() string {
+	if len(e.Path) == 0 {
+		return e.Msg
+	}
+	return e.Path + ": " + e.Msg
+}
+
+// FieldErrors 多个配置字段错误
+type FieldErrors []*FieldError
+
+func (es FieldErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Add 添加字段错误
+func (es *FieldErrors) Add(path, msg string) {
+	*es = append(*es, &FieldError{Path: path, Msg: msg})
+}
+
+// Join 添加err 并在路径前加上prefix, err为FieldErrors时保留每个字段的路径
+func (es *FieldErrors) Join(prefix string, err error) {
+	if err == nil {
+		return
+	}
+	var fes FieldErrors
+	if !errors.As(err, &fes) {
+		es.Add(prefix, err.Error())
+		return
+	}
+	for _, fe := range fes {
+		path := fe.Path
+		if len(prefix) != 0 && len(path) != 0 {
+			path = prefix + "." + path
+		} else if len(prefix) != 0 {
+			path = prefix
+		}
+		es.Add(path, fe.Msg)
+	}
+}
+
+// Err 没有错误时返回nil
+func (es FieldErrors) Err() error {
+	if len(es) == 0 {
+		return nil
+	}
+	return es
+}